@@ -0,0 +1,34 @@
+// Command client-terminal is interactive banking client, commands are read from stdin
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	"github.com/ParvizBoymurodov/managers-core/pkg/terminal"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	dsn := flag.String("db", "db.sqlite", "sqlite database file")
+	flag.Parse()
+
+	db, err := sql.Open("sqlite3", *dsn)
+	if err != nil {
+		log.Fatalf("can't open db: %v", err)
+	}
+	err = core.Init(db)
+	if err != nil {
+		log.Fatalf("can't init db: %v", err)
+	}
+	err = terminal.Run(os.Stdin, os.Stdout, db)
+	if closeErr := db.Close(); closeErr != nil {
+		log.Printf("can't close db: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+)
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+// parse rejects positional arguments, commands take only flags unless said otherwise
+func parse(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	return nil
+}
+
+// output writes value as JSON or calls table with tab separated writer
+func output(env env, value interface{}, table func(w io.Writer)) error {
+	if env.json {
+		encoder := json.NewEncoder(env.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	writer := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	table(writer)
+	return writer.Flush()
+}
+
+func clientAdd(env env, args []string) error {
+	client := core.Client{}
+	flags := newFlagSet("client add")
+	flags.StringVar(&client.Name, "name", "", "")
+	flags.StringVar(&client.Login, "login", "", "")
+	flags.StringVar(&client.Password, "password", "", "")
+	flags.Int64Var(&client.PhoneNumber, "phone", 0, "")
+	flags.Uint64Var(&client.BalanceNumber, "balance-number", 0, "")
+	flags.Uint64Var(&client.Balance, "balance", 0, "")
+	err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if client.Name == "" || client.Login == "" || client.Password == "" || client.PhoneNumber == 0 || client.BalanceNumber == 0 {
+		return errUsage
+	}
+	err = core.AddClients(client, env.db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "client %s added\n", client.Login)
+	return nil
+}
+
+func clientFind(env env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	clients, err := core.FindClients(args[0], env.db)
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		clients = []core.Client{}
+	}
+	return output(env, clients, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tLOGIN\tPHONE\tBALANCE NUMBER\tBALANCE")
+		for _, client := range clients {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\n", client.Id, client.Name, client.Login,
+				client.PhoneNumber, client.BalanceNumber, client.Balance)
+		}
+	})
+}
+
+func atmAdd(env env, args []string) error {
+	atm := core.Atm{}
+	var operations string
+	flags := newFlagSet("atm add")
+	flags.StringVar(&atm.Name, "name", "", "")
+	flags.StringVar(&atm.Address, "address", "", "")
+	flags.Float64Var(&atm.Latitude, "lat", 0, "")
+	flags.Float64Var(&atm.Longitude, "lon", 0, "")
+	flags.StringVar(&atm.Status, "status", "", "")
+	flags.StringVar(&atm.OpenTime, "open", "", "")
+	flags.StringVar(&atm.CloseTime, "close", "", "")
+	flags.StringVar(&operations, "operations", "", "")
+	err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if atm.Name == "" || atm.Address == "" {
+		return errUsage
+	}
+	if operations != "" {
+		atm.Operations = strings.Split(operations, ",")
+	}
+	err = core.AddAtm(atm, env.db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "atm %s added\n", atm.Name)
+	return nil
+}
+
+func atmList(env env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	atms, err := core.GetAllAtms(env.db)
+	if err != nil {
+		return err
+	}
+	if atms == nil {
+		atms = []core.Atm{}
+	}
+	return output(env, atms, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tADDRESS\tSTATUS\tHOURS\tOPERATIONS")
+		for _, atm := range atms {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s-%s\t%s\n", atm.Id, atm.Name, atm.Address,
+				atm.Status, atm.OpenTime, atm.CloseTime, strings.Join(atm.Operations, ","))
+		}
+	})
+}
+
+func serviceAdd(env env, args []string) error {
+	service := core.Services{}
+	flags := newFlagSet("service add")
+	flags.StringVar(&service.Name, "name", "", "")
+	flags.Uint64Var(&service.MinAmount, "min", 0, "")
+	flags.Uint64Var(&service.MaxAmount, "max", 0, "")
+	flags.Int64Var(&service.CategoryId, "category", 0, "")
+	flags.StringVar(&service.ReferencePattern, "reference-pattern", "", "")
+	flags.StringVar(&service.Description, "description", "", "")
+	err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if service.Name == "" {
+		return errUsage
+	}
+	err = core.AddServices(service, env.db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "service %s added\n", service.Name)
+	return nil
+}
+
+func balanceTopUp(env env, args []string) error {
+	client := core.Client{}
+	flags := newFlagSet("balance topup")
+	flags.StringVar(&client.Login, "login", "", "")
+	flags.Uint64Var(&client.Balance, "amount", 0, "")
+	err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if client.Login == "" || client.Balance == 0 {
+		return errUsage
+	}
+	err = core.UpdateBalance(client, env.db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "balance of %s topped up by %d\n", client.Login, client.Balance)
+	return nil
+}
+
+// exportCommand writes to stdout unless file is given, files are written atomically by core
+func exportCommand(
+	export func(io.Writer, string, *sql.DB) error,
+	exportToPath func(string, string, *sql.DB) error,
+) func(env, []string) error {
+	return func(env env, args []string) error {
+		flags := newFlagSet("export")
+		format := flags.String("format", "", "")
+		file := flags.String("file", "", "")
+		err := parse(flags, args)
+		if err != nil {
+			return err
+		}
+		if *format == "" {
+			*format = formatOf(*file, core.FormatJSON)
+		}
+		if *file == "" || *file == "-" {
+			return export(env.stdout, *format, env.db)
+		}
+		return exportToPath(*file, *format, env.db)
+	}
+}
+
+// importCommand reads file or stdin for "-" and prints report of every record
+func importCommand(
+	importWithOptions func(io.Reader, string, core.ImportOptions, *sql.DB) (core.ImportReport, error),
+) func(env, []string) error {
+	return func(env env, args []string) (err error) {
+		flags := newFlagSet("import")
+		format := flags.String("format", "", "")
+		file := flags.String("file", "", "")
+		options := core.ImportOptions{}
+		flags.BoolVar(&options.DryRun, "dry-run", false, "")
+		err = parse(flags, args)
+		if err != nil {
+			return err
+		}
+		if *file == "" {
+			return errUsage
+		}
+		if *format == "" {
+			*format = formatOf(*file, "")
+			if *format == "" {
+				return errUsage
+			}
+		}
+
+		r := env.stdin
+		if *file != "-" {
+			opened, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = opened.Close()
+			}()
+			r = opened
+		}
+		report, err := importWithOptions(r, *format, options, env.db)
+		if err != nil && report.Records == nil {
+			return err
+		}
+		printErr := output(env, report, func(w io.Writer) {
+			fmt.Fprintln(w, "RECORD\tSTATUS\tREASON")
+			for _, record := range report.Records {
+				fmt.Fprintf(w, "%d\t%s\t%s\n", record.Index, record.Status, record.Reason)
+			}
+			fmt.Fprintf(w, "accepted %d, skipped %d, failed %d\n", report.Accepted, report.Skipped, report.Failed)
+		})
+		if err != nil {
+			return err
+		}
+		return printErr
+	}
+}
+
+// formatOf guesses format by file extension
+func formatOf(file string, fallback string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return core.FormatJSON
+	case ".xml":
+		return core.FormatXML
+	case ".csv":
+		return core.FormatCSV
+	}
+	return fallback
+}
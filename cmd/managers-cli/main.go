@@ -0,0 +1,146 @@
+// Command managers-cli runs manager operations of core from shell:
+//
+//	managers-cli -login vasya -password secret client add -name Masha -login masha -password 123 -phone 992900000003 -balance-number 1003
+//	managers-cli -json atm list
+//	managers-cli export clients -format xml -file clients.xml
+//	managers-cli import atms -file atms.json -dry-run
+//
+// Login and password may also be set by MANAGERS_LOGIN and MANAGERS_PASSWORD.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+var errUsage = errors.New("usage")
+var errUnauthorized = errors.New("invalid manager login or password")
+
+// env is shared by all commands
+type env struct {
+	db     *sql.DB
+	stdin  io.Reader
+	stdout io.Writer
+	json   bool
+}
+
+type command struct {
+	usage string
+	run   func(env env, args []string) error
+}
+
+// commands are keyed by "<group> <name>"
+var commands = map[string]command{
+	"client add":      {usage: "-name NAME -login LOGIN -password PASSWORD -phone PHONE -balance-number NUMBER [-balance AMOUNT]", run: clientAdd},
+	"client find":     {usage: "QUERY", run: clientFind},
+	"atm add":         {usage: "-name NAME -address ADDRESS [-lat LAT -lon LON -status STATUS -open HH:MM -close HH:MM -operations cash,deposit]", run: atmAdd},
+	"atm list":        {usage: "", run: atmList},
+	"service add":     {usage: "-name NAME [-min AMOUNT -max AMOUNT -category ID -reference-pattern REGEXP -description TEXT]", run: serviceAdd},
+	"balance topup":   {usage: "-login LOGIN -amount AMOUNT", run: balanceTopUp},
+	"export clients":  {usage: "[-format json|xml|csv] [-file PATH]", run: exportCommand(core.ExportClients, core.ExportClientsToPath)},
+	"export atms":     {usage: "[-format json|xml|csv] [-file PATH]", run: exportCommand(core.ExportAtms, core.ExportAtmsToPath)},
+	"export services": {usage: "[-format json|xml|csv] [-file PATH]", run: exportCommand(core.ExportServices, core.ExportServicesToPath)},
+	"import clients":  {usage: "-file PATH [-format json|xml|csv] [-dry-run]", run: importCommand(core.ImportClientsWithOptions)},
+	"import atms":     {usage: "-file PATH [-format json|xml|csv] [-dry-run]", run: importCommand(core.ImportAtmsWithOptions)},
+	"import services": {usage: "-file PATH [-format json|xml|csv] [-dry-run]", run: importCommand(core.ImportServicesWithOptions)},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("managers-cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dsn := flags.String("db", "db.sqlite", "sqlite database file")
+	login := flags.String("login", os.Getenv("MANAGERS_LOGIN"), "manager login")
+	password := flags.String("password", os.Getenv("MANAGERS_PASSWORD"), "manager password")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of tables")
+	flags.Usage = func() {
+		printUsage(flags, stderr)
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return exitUsage
+	}
+	name := flags.Arg(0) + " " + flags.Arg(1)
+	selected, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", name)
+		flags.Usage()
+		return exitUsage
+	}
+
+	db, err := sql.Open("sqlite3", *dsn)
+	if err != nil {
+		fmt.Fprintf(stderr, "can't open db: %v\n", err)
+		return exitError
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			fmt.Fprintf(stderr, "can't close db: %v\n", err)
+		}
+	}()
+	err = core.Init(db)
+	if err != nil {
+		fmt.Fprintf(stderr, "can't init db: %v\n", err)
+		return exitError
+	}
+	err = authenticate(*login, *password, db)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	err = selected.run(env{db: db, stdin: stdin, stdout: stdout, json: *jsonOutput}, flags.Args()[2:])
+	if err == errUsage || err == flag.ErrHelp {
+		fmt.Fprintf(stderr, "usage: managers-cli %s %s\n", name, selected.usage)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func authenticate(login string, password string, db *sql.DB) error {
+	ok, err := core.LoginForManagers(login, password, db)
+	if err == core.ErrInvalidPass || (err == nil && !ok) {
+		return errUnauthorized
+	}
+	return err
+}
+
+func printUsage(flags *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "usage: managers-cli [flags] <group> <command> [command flags]")
+	flags.PrintDefaults()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s %s\n", name, strings.TrimSpace(commands[name].usage))
+	}
+}
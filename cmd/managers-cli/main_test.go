@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+)
+
+func runCLI(t *testing.T, dsn string, stdin string, args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args = append([]string{"-db", dsn, "-login", "vasya", "-password", "secret"}, args...)
+	code := run(args, strings.NewReader(stdin), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Commands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "managers-cli")
+	if err != nil {
+		t.Fatalf("can't create dir: %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dsn := filepath.Join(dir, "db.sqlite")
+
+	code, _, stderr := runCLI(t, dsn, "", "client", "add", "-name", "Masha", "-login", "masha", "-password", "123",
+		"-phone", "992900000003", "-balance-number", "1003")
+	if code != exitOK {
+		t.Fatalf("can't add client: %d %s", code, stderr)
+	}
+	code, _, stderr = runCLI(t, dsn, "", "balance", "topup", "-login", "masha", "-amount", "50")
+	if code != exitOK {
+		t.Fatalf("can't top up: %d %s", code, stderr)
+	}
+	code, stdout, stderr := runCLI(t, dsn, "", "-json", "client", "find", "mash")
+	var clients []core.Client
+	if code != exitOK || json.Unmarshal([]byte(stdout), &clients) != nil || len(clients) != 1 || clients[0].Balance != 50 {
+		t.Errorf("unexpected clients: %d %s %s", code, stdout, stderr)
+	}
+
+	atms := `{"version":1,"atms":[{"name":"Central","address":"Rudaki 1"}]}`
+	code, stdout, stderr = runCLI(t, dsn, atms, "import", "atms", "-file", "-", "-format", "json")
+	if code != exitOK || !strings.Contains(stdout, "accepted 1, skipped 0, failed 0") {
+		t.Errorf("unexpected import: %d %s %s", code, stdout, stderr)
+	}
+	code, stdout, _ = runCLI(t, dsn, "", "atm", "list")
+	if code != exitOK || !strings.Contains(stdout, "Central") || !strings.HasPrefix(stdout, "ID") {
+		t.Errorf("unexpected atm list: %d %s", code, stdout)
+	}
+	code, stdout, _ = runCLI(t, dsn, "", "export", "atms", "-format", "xml")
+	if code != exitOK || !strings.HasPrefix(stdout, `<atms version="1">`) {
+		t.Errorf("unexpected export: %d %s", code, stdout)
+	}
+
+	code, _, stderr = runCLI(t, dsn, "", "atm", "add", "-name", "Broken")
+	if code != exitUsage || !strings.Contains(stderr, "usage: managers-cli atm add") {
+		t.Errorf("unexpected result without address: %d %s", code, stderr)
+	}
+	code = run([]string{"-db", dsn, "-login", "vasya", "-password", "wrong", "atm", "list"},
+		strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{})
+	if code != exitError {
+		t.Errorf("wrong password accepted: %d", code)
+	}
+}
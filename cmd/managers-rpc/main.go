@@ -0,0 +1,43 @@
+// Command managers-rpc serves client operations as JSON-RPC over TCP for kiosks
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"net"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	"github.com/ParvizBoymurodov/managers-core/pkg/rpc"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9998", "address to listen on")
+	dsn := flag.String("db", "db.sqlite", "sqlite database file")
+	flag.Parse()
+
+	db, err := sql.Open("sqlite3", *dsn)
+	if err != nil {
+		log.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("can't close db: %v", err)
+		}
+	}()
+	err = core.Init(db)
+	if err != nil {
+		log.Fatalf("can't init db: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("can't listen: %v", err)
+	}
+	log.Printf("listening on %s", *addr)
+	err = rpc.Serve(listener, db)
+	if err != nil {
+		log.Printf("server stopped: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Command managers-server serves core operations over HTTP
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	"github.com/ParvizBoymurodov/managers-core/pkg/server"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9999", "address to listen on")
+	dsn := flag.String("db", "db.sqlite", "sqlite database file")
+	flag.Parse()
+
+	db, err := sql.Open("sqlite3", *dsn)
+	if err != nil {
+		log.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("can't close db: %v", err)
+		}
+	}()
+	err = core.Init(db)
+	if err != nil {
+		log.Fatalf("can't init db: %v", err)
+	}
+
+	log.Printf("listening on %s", *addr)
+	err = http.ListenAndServe(*addr, server.NewServer(db))
+	if err != nil {
+		log.Printf("server stopped: %v", err)
+	}
+}
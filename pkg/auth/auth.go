@@ -0,0 +1,81 @@
+// Package auth keeps tokens issued after login for network interfaces of core
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TokenTTL is lifetime of tokens, clients log in again after it
+const TokenTTL = 24 * time.Hour
+
+const tokenLength = 32
+
+const (
+	RoleClient  = "client"
+	RoleManager = "manager"
+)
+
+// Session is owner of token, ClientId is set only for clients
+type Session struct {
+	Role     string
+	Login    string
+	ClientId int64
+	Expires  time.Time
+}
+
+// Tokens keeps sessions in memory, they are lost on restart
+type Tokens struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	now   func() time.Time
+	items map[string]Session
+}
+
+func NewTokens(ttl time.Duration) *Tokens {
+	return &Tokens{ttl: ttl, now: time.Now, items: map[string]Session{}}
+}
+
+// Issue returns new random token of owner, expired tokens are dropped on the way
+func (receiver *Tokens) Issue(owner Session) (token string, session Session, err error) {
+	data := make([]byte, tokenLength)
+	_, err = rand.Read(data)
+	if err != nil {
+		return "", Session{}, err
+	}
+	token = hex.EncodeToString(data)
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	now := receiver.now()
+	for key, item := range receiver.items {
+		if !now.Before(item.Expires) {
+			delete(receiver.items, key)
+		}
+	}
+	owner.Expires = now.Add(receiver.ttl)
+	receiver.items[token] = owner
+	return token, owner, nil
+}
+
+func (receiver *Tokens) Find(token string) (Session, bool) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	owner, ok := receiver.items[token]
+	if !ok {
+		return Session{}, false
+	}
+	if !receiver.now().Before(owner.Expires) {
+		delete(receiver.items, token)
+		return Session{}, false
+	}
+	return owner, true
+}
+
+func (receiver *Tokens) Revoke(token string) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	delete(receiver.items, token)
+}
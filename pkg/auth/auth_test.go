@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokens_Expire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tokens := NewTokens(time.Minute)
+	tokens.now = func() time.Time {
+		return now
+	}
+
+	token, session, err := tokens.Issue(Session{Role: RoleClient, Login: "vasya", ClientId: 1})
+	if err != nil {
+		t.Fatalf("can't issue token: %v", err)
+	}
+	if len(token) != 2*tokenLength || session.Expires != now.Add(time.Minute) {
+		t.Errorf("unexpected token: %s %v", token, session)
+	}
+	found, ok := tokens.Find(token)
+	if !ok || found.ClientId != 1 {
+		t.Errorf("token not found: %v %v", found, ok)
+	}
+
+	now = now.Add(time.Minute)
+	_, ok = tokens.Find(token)
+	if ok {
+		t.Errorf("expired token found")
+	}
+
+	token, _, err = tokens.Issue(Session{Role: RoleManager, Login: "vasya"})
+	if err != nil {
+		t.Fatalf("can't issue token: %v", err)
+	}
+	tokens.Revoke(token)
+	_, ok = tokens.Find(token)
+	if ok {
+		t.Errorf("revoked token found")
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+)
+
+// Error codes shared by network interfaces, clients should switch on code and show message
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeLoginLocked        = "login_locked"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeValidationFailed   = "validation_failed"
+	CodeInsufficientFunds  = "insufficient_funds"
+	CodeDatabaseError      = "database_error"
+	CodeInternalError      = "internal_error"
+)
+
+// Error is failure with code, its message is safe to show to caller
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (receiver *Error) Error() string {
+	return receiver.Message
+}
+
+var ErrInvalidCredentials = &Error{Code: CodeInvalidCredentials, Message: "invalid login or password"}
+var ErrLoginLocked = &Error{Code: CodeLoginLocked, Message: "login is locked after failed attempts, try later"}
+var ErrNotAccountOwner = &Error{Code: CodeForbidden, Message: "account does not belong to client"}
+var ErrInsufficientFunds = &Error{Code: CodeInsufficientFunds, Message: "not enough money on account"}
+var ErrInvalidAmount = &Error{Code: CodeInvalidRequest, Message: "amount must be positive"}
+
+// validationErrors are rejected input which caller can fix, their messages are safe to show
+var validationErrors = []error{
+	core.ErrInvalidAtmStatus,
+	core.ErrInvalidWorkingHours,
+	core.ErrInvalidReference,
+	core.ErrInvalidReferencePattern,
+	core.ErrInvalidAmountLimits,
+	core.ErrAmountTooSmall,
+	core.ErrAmountTooLarge,
+	core.ErrServiceDisabled,
+	core.ErrInvalidCursor,
+	core.ErrInvalidSortField,
+	core.ErrInvalidSettlementPeriod,
+	core.ErrEmptyField,
+}
+
+// Classify converts error of core to Error, details of database and unknown errors are only logged
+func Classify(err error) *Error {
+	var typedErr *Error
+	if errors.As(err, &typedErr) {
+		return typedErr
+	}
+	if errors.Is(err, core.ErrInvalidPass) {
+		return ErrInvalidCredentials
+	}
+	if errors.Is(err, core.ErrLoginLocked) {
+		return ErrLoginLocked
+	}
+	if errors.Is(err, core.ErrInsufficientFunds) {
+		return ErrInsufficientFunds
+	}
+	if errors.Is(err, core.ErrNotFound) {
+		return &Error{Code: CodeNotFound, Message: err.Error()}
+	}
+	var conflictErr *core.ConflictError
+	if errors.Is(err, core.ErrVersionConflict) || errors.As(err, &conflictErr) {
+		return &Error{Code: CodeConflict, Message: err.Error()}
+	}
+	for _, validationErr := range validationErrors {
+		if errors.Is(err, validationErr) {
+			return &Error{Code: CodeValidationFailed, Message: err.Error()}
+		}
+	}
+
+	var queryErr *core.QueryError
+	var dbErr *core.DbError
+	if errors.As(err, &queryErr) || errors.As(err, &dbErr) {
+		log.Printf("database error: %v", err)
+		return &Error{Code: CodeDatabaseError, Message: "database error"}
+	}
+	log.Printf("internal error: %v", err)
+	return &Error{Code: CodeInternalError, Message: "internal error"}
+}
+
+// CheckDebit allows client of session to spend only from own account,
+// core checks balance when account is debited and returns core.ErrInsufficientFunds
+func CheckDebit(owner Session, balanceNumber uint64, amount uint64, db *sql.DB) error {
+	if amount == 0 {
+		return ErrInvalidAmount
+	}
+	accounts, err := core.GetBalanceList(db, owner.ClientId)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if account.BalanceNumber == balanceNumber {
+			return nil
+		}
+	}
+	return ErrNotAccountOwner
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCheckDebit(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = core.Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = core.AddClients(core.Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	owner := Session{Role: RoleClient, Login: "vasya", ClientId: 1}
+	cases := []struct {
+		balanceNumber uint64
+		amount        uint64
+		expected      error
+	}{
+		{1001, 100, nil},
+		{1001, 0, ErrInvalidAmount},
+		{1001, 101, nil},
+		{1002, 1, ErrNotAccountOwner},
+	}
+	for _, item := range cases {
+		err = CheckDebit(owner, item.balanceNumber, item.amount, db)
+		if err != item.expected {
+			t.Errorf("unexpected result for %d from %d: %v", item.amount, item.balanceNumber, err)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := map[error]string{
+		core.ErrInvalidPass: CodeInvalidCredentials,
+		core.ErrNotFound:    CodeNotFound,
+		&core.ConflictError{Field: "login", Value: 1}:           CodeConflict,
+		fmt.Errorf("name: %w", core.ErrEmptyField):              CodeValidationFailed,
+		&core.QueryError{Query: "select", Err: sql.ErrConnDone}: CodeDatabaseError,
+		ErrInsufficientFunds:                                    CodeInsufficientFunds,
+		core.ErrInsufficientFunds:                               CodeInsufficientFunds,
+	}
+	for err, code := range cases {
+		classified := Classify(err)
+		if classified.Code != code {
+			t.Errorf("unexpected code of %v: %v", err, classified)
+		}
+	}
+	classified := Classify(&core.QueryError{Query: "select password", Err: sql.ErrConnDone})
+	if classified.Message != "database error" {
+		t.Errorf("details of database error shown: %v", classified)
+	}
+}
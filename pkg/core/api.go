@@ -2,14 +2,17 @@ package core
 
 import (
 	"database/sql"
-	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"time"
 )
 
 var ErrInvalidPass = errors.New("invalid password")
+var ErrLoginLocked = errors.New("login is locked")
 
 type QueryError struct { // alt + enter
 	Query string
@@ -26,25 +29,44 @@ type DbTxError struct {
 }
 
 type Atm struct {
-	Id int64
-	Name string
-	Address string
-}
-
+	Id int64 `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Address string `json:"address" xml:"address"`
+	Latitude float64 `json:"latitude" xml:"latitude"`
+	Longitude float64 `json:"longitude" xml:"longitude"`
+	Status string `json:"status" xml:"status"`
+	OpenTime string `json:"open_time" xml:"open_time"`
+	CloseTime string `json:"close_time" xml:"close_time"`
+	Operations []string `json:"operations" xml:"operations>operation"`
+	Version int64 `json:"version" xml:"version"`
+}
+
+// Client is exported without password unless profile asks for it
 type Client struct {
-	Id int64
-	Name string
-	Login string
-	Password string
-	Balance uint64
-	BalanceNumber uint64
-	PhoneNumber int64
+	Id int64 `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Login string `json:"login" xml:"login"`
+	Password string `json:"password,omitempty" xml:"password,omitempty"`
+	Balance uint64 `json:"balance" xml:"balance"`
+	BalanceNumber uint64 `json:"balance_number" xml:"balance_number"`
+	PhoneNumber int64 `json:"phone_number" xml:"phone_number"`
+	Version int64 `json:"version" xml:"version"`
 }
 
 type Services struct {
-	Id int64
-	Name string
-	Balance uint64
+	Id int64 `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Balance uint64 `json:"balance" xml:"balance"`
+	ReferencePattern string `json:"reference_pattern" xml:"reference_pattern"`
+	MinAmount uint64 `json:"min_amount" xml:"min_amount"`
+	MaxAmount uint64 `json:"max_amount" xml:"max_amount"`
+	CategoryId int64 `json:"category_id" xml:"category_id"`
+	Description string `json:"description" xml:"description"`
+	Icon string `json:"icon" xml:"icon"`
+	Disabled bool `json:"disabled" xml:"disabled"`
+	Position int `json:"position" xml:"position"`
+	SettlementPeriod int `json:"settlement_period" xml:"settlement_period"`
+	Version int64 `json:"version" xml:"version"`
 }
 
 
@@ -74,12 +96,13 @@ func dbError(err error) *DbError {
 
 
 func Init(db *sql.DB) (err error) {
-	ddls := []string{managersDDL, atmDDL,clientDDL,servicesDDL}
-	for _, ddl := range ddls {
-		_, err = db.Exec(ddl)
-		if err != nil {
-			return err
-		}
+	err = migrate(db)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(changesDDL)
+	if err != nil {
+		return err
 	}
 
 	initialData := []string{managersInitialData}
@@ -95,10 +118,10 @@ func Init(db *sql.DB) (err error) {
 
 func Login(login, password string, db *sql.DB) (int64,bool, error) {
 	var dbLogin, dbPassword string
-    var dbId int64
+    var dbId, lockedUntil int64
 	err := db.QueryRow(
 		LoginForClient,
-		login).Scan(&dbId,&dbLogin, &dbPassword)
+		login).Scan(&dbId,&dbLogin, &dbPassword, &lockedUntil)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -107,6 +130,14 @@ func Login(login, password string, db *sql.DB) (int64,bool, error) {
 
 		return -1, false, queryError(LoginForClient, err)
 	}
+	// password is not checked while login is locked, see LockLogin
+	if lockedUntil > time.Now().Unix() {
+		return -1, false, ErrLoginLocked
+	}
+	// client restored from redacted backup has no password
+	if dbPassword == "" {
+		return -1, false, nil
+	}
 
 	if dbPassword != password {
 		return -1, false, ErrInvalidPass
@@ -129,6 +160,10 @@ func LoginForManagers(login, password string, db *sql.DB) (bool, error) {
 
 		return false, queryError(loginSQL, err)
 	}
+	// manager restored from redacted backup has no password
+	if dbPassword == "" {
+		return false, nil
+	}
 
 	if dbPassword != password {
 		return false, ErrInvalidPass
@@ -149,8 +184,7 @@ func GetAllAtms(db *sql.DB) (atms []Atm, err error) {
 	}()
 
 	for rows.Next() {
-		atm := Atm{}
-		err = rows.Scan(&atm.Id, &atm.Name, &atm.Address)
+		atm, err := scanAtm(rows)
 		if err != nil {
 			return nil, dbError(err)
 		}
@@ -201,8 +235,7 @@ func GetServices(db *sql.DB)(ServiceList []Services,err error)  {
 	}()
 
 	for rows.Next() {
-		listService := Services{}
-		err = rows.Scan(&listService.Id, &listService.Name)
+		listService, err := scanService(rows)
 		if err != nil {
 			return nil, dbError(err)
 		}
@@ -216,6 +249,10 @@ func GetServices(db *sql.DB)(ServiceList []Services,err error)  {
 }
 
 func AddClients(client Client, db *sql.DB) (err error) {
+	err = checkClientUnique(client, db)
+	if err != nil {
+		return err
+	}
 
 	_, err = db.Exec(
 		insertClientSQL,
@@ -227,43 +264,85 @@ func AddClients(client Client, db *sql.DB) (err error) {
 		sql.Named("phone_number",client.PhoneNumber),
 	)
 	if err != nil {
-		return err
+		return queryError(insertClientSQL, err)
 	}
 
 	return nil
 }
 
 func AddAtm (atm Atm, db *sql.DB)(err error){
+		atm, err = normalizeAtm(atm)
+		if err != nil {
+			return err
+		}
 
 		_, err = db.Exec(
 			insertAtmSql,
 			sql.Named("name", atm.Name),
 			sql.Named("street", atm.Address),
+			sql.Named("latitude", atm.Latitude),
+			sql.Named("longitude", atm.Longitude),
+			sql.Named("status", atm.Status),
+			sql.Named("open_time", atm.OpenTime),
+			sql.Named("close_time", atm.CloseTime),
+			sql.Named("operations", joinOperations(atm.Operations)),
 		)
 		if err != nil {
-			return err
+			return queryError(insertAtmSql, err)
 		}
 
 		return nil
 }
 
 func AddServices(services Services,db *sql.DB)(err error)  {
+	err = checkUnique(checkServiceNameSQL, "name", services.Name, services.Id, db)
+	if err != nil {
+		return err
+	}
+	err = checkServiceLimits(services)
+	if err != nil {
+		return err
+	}
+	err = checkCategoryExists(services.CategoryId, db)
+	if err != nil {
+		return err
+	}
 
 	_, err = db.Exec(
 		insertServices,
 		sql.Named("name", services.Name),
 		sql.Named("balance", services.Balance),
+		sql.Named("reference_pattern", services.ReferencePattern),
+		sql.Named("min_amount", services.MinAmount),
+		sql.Named("max_amount", services.MaxAmount),
+		sql.Named("category_id", services.CategoryId),
+		sql.Named("description", services.Description),
+		sql.Named("icon", services.Icon),
+		sql.Named("disabled", services.Disabled),
+		sql.Named("position", services.Position),
+		sql.Named("settlement_period", services.SettlementPeriod),
 	)
 	if err != nil {
-		return err
+		return queryError(insertServices, err)
 	}
 
 	return nil
 }
 
 func UpdateBalance(listBalance Client,  db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
 
-	_, err = db.Exec(
+	result, err := tx.Exec(
 		updateCardBalanceSQL,
 		sql.Named("login", listBalance.Login),
 		sql.Named("balance", listBalance.Balance),
@@ -271,12 +350,24 @@ func UpdateBalance(listBalance Client,  db *sql.DB) (err error) {
 	if err != nil {
 		return err
 	}
+	err = checkAffected(result)
+	if err != nil {
+		return err
+	}
+	_, err = recordTopUp(listBalance.Login, listBalance.Balance, tx)
+	if err != nil {
+		return err
+	}
+	err = publishEvent(EventBalanceToppedUp, TopUp{Login: listBalance.Login, Amount: listBalance.Balance}, tx)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
 func transactionByPhoneNumberPlus(transaction Client, tx *sql.Tx) (err error) {
-	_, err = tx.Exec(
+	result, err := tx.Exec(
 		updateTransactionWithPhoneNumberPlus,
 		sql.Named("phone_number",transaction.PhoneNumber ),
 		sql.Named("balance", transaction.Balance ),
@@ -285,11 +376,11 @@ func transactionByPhoneNumberPlus(transaction Client, tx *sql.Tx) (err error) {
 		return err
 	}
 
-	return nil
+	return checkAffected(result)
 }
 
 func transactionByPhoneNumberMinus(balanceNumber uint64,balance uint64,tx *sql.Tx) (err error) {
-	_, err = tx.Exec(
+	result, err := tx.Exec(
 		updateTransactionWithPhoneNumberMinus,
 		sql.Named("balance_number", balanceNumber),
 		sql.Named("balance", balance),
@@ -298,12 +389,12 @@ func transactionByPhoneNumberMinus(balanceNumber uint64,balance uint64,tx *sql.T
 		return err
 	}
 
-	return nil
+	return checkDebited(result, balanceNumber, tx)
 }
 
 func transactionBalanceNumberPlus(transaction Client, tx *sql.Tx) (err error) {
 
-	_, err = tx.Exec(
+	result, err := tx.Exec(
 		updateTransactionWithBalanceNumberPlus,
 		sql.Named("balance_number", transaction.BalanceNumber),
 		sql.Named("balance", transaction.Balance),
@@ -312,12 +403,12 @@ func transactionBalanceNumberPlus(transaction Client, tx *sql.Tx) (err error) {
 		return err
 	}
 
-	return nil
+	return checkAffected(result)
 }
 
 func transactionBalanceNumberMinus(myBalanceNumber uint64,balance uint64, tx *sql.Tx) (err error) {
 
-	_, err = tx.Exec(
+	result, err := tx.Exec(
 		updateTransactionWithBalanceNumberMinus,
 		sql.Named("balance_number", myBalanceNumber),
 		sql.Named("balance", balance),
@@ -326,24 +417,24 @@ func transactionBalanceNumberMinus(myBalanceNumber uint64,balance uint64, tx *sq
 		return err
 	}
 
-	return nil
+	return checkDebited(result, myBalanceNumber, tx)
 }
 
-func servicePaying(pay Services, tx *sql.Tx) (err error) {
-	_, err = tx.Exec(
+func servicePaying(serviceId int64, balance uint64, tx *sql.Tx) (err error) {
+	result, err := tx.Exec(
 		updateServices,
-		sql.Named("id", pay.Id),
-		sql.Named("balance", pay.Balance),
+		sql.Named("id", serviceId),
+		sql.Named("balance", balance),
 	)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return checkAffected(result)
 }
 
 func repay(balanceNumber uint64,balance uint64,tx *sql.Tx) (err error)  {
-	_, err = tx.Exec(
+	result, err := tx.Exec(
 		payServices,
 		sql.Named("balance_number", balanceNumber),
 		sql.Named("balance", balance),
@@ -352,31 +443,31 @@ func repay(balanceNumber uint64,balance uint64,tx *sql.Tx) (err error)  {
 		return err
 	}
 
-	return nil
+	return checkDebited(result, balanceNumber, tx)
 }
 
 func CheckByBalanceNumber(balanceNumber uint64, db *sql.DB)(err error)  {
 	var id int
-	err = db.QueryRow("select id from client where balance_number=?", balanceNumber).Scan(&id)
+	err = db.QueryRow("select id from client where balance_number=? and removed = 0", balanceNumber).Scan(&id)
 	return err
 }
 
 func CheckByPhoneNumber(phoneNumber int64,db *sql.DB) (err error) {
 	var id int
-	err = db.QueryRow("select id from client where phone_number=?", phoneNumber).Scan(&id)
+	err = db.QueryRow("select id from client where phone_number=? and removed = 0", phoneNumber).Scan(&id)
 	return err
 }
 
 func CheckId(id int64,db *sql.DB) (err error) {
 	var name int
-	err = db.QueryRow("select id from services where id=?", id).Scan(&name)
+	err = db.QueryRow("select id from services where id=? and removed = 0", id).Scan(&name)
 	return err
 }
 
-func TransferByPhoneNumber(balanceNumber uint64,balance uint64,tranzaction Client, db *sql.DB)(err error) {
+func TransferByPhoneNumber(balanceNumber uint64,balance uint64,tranzaction Client, db *sql.DB)(receipt Receipt, err error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
 	defer func() {
 		if err != nil {
@@ -387,19 +478,23 @@ func TransferByPhoneNumber(balanceNumber uint64,balance uint64,tranzaction Clien
 	}()
 	err = transactionByPhoneNumberMinus(balanceNumber,balance,tx)
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
 	err = transactionByPhoneNumberPlus(tranzaction,tx)
 	if err != nil {
-		return err
+		return Receipt{}, err
+	}
+	transactionId, err := recordTransferByPhoneNumber(balanceNumber, tranzaction.PhoneNumber, balance, tx)
+	if err != nil {
+		return Receipt{}, err
 	}
-  return nil
+  return issueReceiptAndPublish(EventTransferCompleted, transactionId, tx)
 }
 
-func TransferByBalanceNumber(myBalanceNumber uint64,balance uint64,tranzaction Client, db *sql.DB)(err error)  {
+func TransferByBalanceNumber(myBalanceNumber uint64,balance uint64,tranzaction Client, db *sql.DB)(receipt Receipt, err error)  {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
 	defer func() {
 		if err != nil {
@@ -410,19 +505,24 @@ func TransferByBalanceNumber(myBalanceNumber uint64,balance uint64,tranzaction C
 	}()
 	err = transactionBalanceNumberMinus(myBalanceNumber,balance,tx)
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
 	err = transactionBalanceNumberPlus(tranzaction,tx)
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
-	return nil
+	transactionId, err := recordTransfer(myBalanceNumber, tranzaction.BalanceNumber, balance, tx)
+	if err != nil {
+		return Receipt{}, err
+	}
+	return issueReceiptAndPublish(EventTransferCompleted, transactionId, tx)
 }
 
-func PayForServices(balanceNumber uint64,balance uint64,pay Services, db *sql.DB) (err error) {
+// PayForServices moves money only when reference and amount pass service rules, only Id of pay is used
+func PayForServices(balanceNumber uint64,balance uint64,reference string,pay Services, db *sql.DB) (receipt Receipt, err error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
 	defer func() {
 		if err != nil {
@@ -431,121 +531,98 @@ func PayForServices(balanceNumber uint64,balance uint64,pay Services, db *sql.DB
 		}
 		err = tx.Commit()
 	}()
+	err = validatePayment(pay.Id, reference, balance, tx)
+	if err != nil {
+		return Receipt{}, err
+	}
 	err = repay(balanceNumber ,balance ,tx)
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
-	err = servicePaying(pay,tx)
+	err = servicePaying(pay.Id, balance, tx)
 	if err != nil {
-		return err
+		return Receipt{}, err
 	}
-	return nil
+	transactionId, err := recordPayment(balanceNumber, pay.Id, balance, reference, tx)
+	if err != nil {
+		return Receipt{}, err
+	}
+	return issueReceiptAndPublish(EventPaymentCompleted, transactionId, tx)
 }
 
 
+// ExportClientsToJSON writes clients.json without passwords, ExportClientsToPathWithOptions applies profile
 func ExportClientsToJSON(db *sql.DB) error {
-	return ExportToFile(db, getAllClientsDataSQL, "clients.json",
-		mapRowToClient, json.Marshal, mapInterfaceSliceToClients)
+	return clientsExchange.exportToPath("clients.json", FormatJSON, ExportOptions{}, db)
 }
 func ExportAtmsToJSON(db *sql.DB) error {
-	return ExportToFile(db, getAllAtmDataSQL, "atms.json",
-		mapRowToAtm, json.Marshal,
-		mapInterfaceSliceToAtms)
+	return atmsExchange.exportToPath("atms.json", FormatJSON, ExportOptions{}, db)
+}
+func ExportServicesToJSON(db *sql.DB) error {
+	return servicesExchange.exportToPath("services.json", FormatJSON, ExportOptions{}, db)
 }
 
 //XML
 
+// ExportClientsToXML writes clients.xml without passwords, ExportClientsToPathWithOptions applies profile
 func ExportClientsToXML(db *sql.DB) error {
-	return ExportToFile(db, getAllClientsDataSQL, "clients.xml",
-		mapRowToClient, xml.Marshal, mapInterfaceSliceToClients)
+	return clientsExchange.exportToPath("clients.xml", FormatXML, ExportOptions{}, db)
 }
 func ExportAtmsToXML(db *sql.DB) error {
-	return ExportToFile(db, getAllAtmDataSQL, "atms.xml",
-		mapRowToAtm, xml.Marshal,
-		mapInterfaceSliceToAtms)
+	return atmsExchange.exportToPath("atms.xml", FormatXML, ExportOptions{}, db)
+}
+func ExportServicesToXML(db *sql.DB) error {
+	return servicesExchange.exportToPath("services.xml", FormatXML, ExportOptions{}, db)
 }
 
 func mapRowToClient(rows *sql.Rows) (interface{}, error) {
-	client := Client{}
-	err := rows.Scan(&client.Id, &client.Login, &client.Password,
-		&client.Name, &client.PhoneNumber, &client.Balance, &client.BalanceNumber)
+	client, err := scanExportedClient(rows)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 func mapRowToAtm(rows *sql.Rows) (interface{}, error) {
-	atm := Atm{}
-	err := rows.Scan(&atm.Id,&atm.Name, &atm.Address)
+	atm, err := scanAtm(rows)
 	if err != nil {
 		return nil, err
 	}
 	return atm, nil
 }
-type ClientsExport struct {
-	Clients []Client
-}
-func mapInterfaceSliceToClients(ifaces []interface{}) interface{} {
-	clients := make([]Client, len(ifaces))
-	for i := range ifaces {
-		clients[i] = ifaces[i].(Client)
+func mapRowToService(rows *sql.Rows) (interface{}, error) {
+	service, err := scanService(rows)
+	if err != nil {
+		return nil, err
 	}
-	clientsExport := ClientsExport{Clients: clients}
-	return clientsExport
+	return service, nil
 }
-func mapInterfaceSliceToAtms(ifaces []interface{}) interface{} {
-	atms := make([]Atm, len(ifaces))
-	for i := range ifaces {
-		atms[i] = ifaces[i].(Atm)
-	}
-	atmsExport := AtmsExport{Atms: atms}
-	return atmsExport
+type ClientsExport struct {
+	XMLName xml.Name `json:"-" xml:"clients"`
+	Version int `json:"version" xml:"version,attr"`
+	Clients []Client `json:"clients" xml:"client"`
 }
 func ImportClientsFromJSON(db *sql.DB) error {
-	return ImportFromFile(
-		db,
-		"clients.json",
-		func(data []byte) ([]interface{}, error) {
-			return mapBytesToClients(data, json.Unmarshal)
-		},
-		insertClientToDB,
-	)
+	return clientsExchange.importFromPath("clients.json", FormatJSON, ImportOptions{}, db)
 }
 func ImportAtmsFromJSON(db *sql.DB) error {
-	return ImportFromFile(
-		db,
-		"atms.json",
-		func(data []byte) ([]interface{}, error) {
-			return mapBytesToAtms(data, json.Unmarshal)
-		},
-		insertAtmToDB,
-	)
+	return atmsExchange.importFromPath("atms.json", FormatJSON, ImportOptions{}, db)
+}
+func ImportServicesFromJSON(db *sql.DB) error {
+	return servicesExchange.importFromPath("services.json", FormatJSON, ImportOptions{}, db)
 }
 func ImportClientsFromXML(db *sql.DB) error {
-	return ImportFromFile(
-		db,
-		"clients.xml",
-		func(data []byte) ([]interface{}, error) {
-			return mapBytesToClients(data, xml.Unmarshal)
-		},
-		insertClientToDB,
-	)
+	return clientsExchange.importFromPath("clients.xml", FormatXML, ImportOptions{}, db)
 }
 func ImportAtmsFromXML(db *sql.DB) error {
-	return ImportFromFile(
-		db,
-		"atms.xml",
-		func(data []byte) ([]interface{}, error) {
-			return mapBytesToAtms(data, xml.Unmarshal)
-		},
-		insertAtmToDB,
-	)
+	return atmsExchange.importFromPath("atms.xml", FormatXML, ImportOptions{}, db)
+}
+func ImportServicesFromXML(db *sql.DB) error {
+	return servicesExchange.importFromPath("services.xml", FormatXML, ImportOptions{}, db)
 }
 func mapBytesToClients(data []byte,
 	unmarshal func([]byte, interface{}) error,
 ) ([]interface{}, error) {
-	clientsExport := ClientsExport{}
-	err := unmarshal(data, &clientsExport)
+	clientsExport, err := unmarshalClients(data, unmarshal)
 	if err != nil {
 		return nil, err
 	}
@@ -555,20 +632,19 @@ func mapBytesToClients(data []byte,
 	}
 	return ifaces, nil
 }
-func insertClientToDB(iface interface{}, db *sql.DB) error {
-	client := iface.(Client)
+func insertClient(client Client, db execer) error {
 	_, err := db.Exec(
-		insertClientSQL,
-		sql.Named("id", client.Id),
+		importClientSQL,
+		sql.Named("id", nullableId(client.Id)),
 		sql.Named("name", client.Name),
 		sql.Named("login", client.Login),
 		sql.Named("password", client.Password),
-		sql.Named("phone", client.PhoneNumber),
+		sql.Named("phone_number", client.PhoneNumber),
 		sql.Named("balance_number", client.BalanceNumber),
 		sql.Named("balance", client.Balance),
 	)
 	if err != nil {
-		return err
+		return queryError(importClientSQL, err)
 	}
 	return nil
 }
@@ -580,7 +656,9 @@ type ATM struct {
 }
 
 type AtmsExport struct {
-	Atms []Atm
+	XMLName xml.Name `json:"-" xml:"atms"`
+	Version int `json:"version" xml:"version,attr"`
+	Atms []Atm `json:"atms" xml:"atm"`
 }
 
 
@@ -588,8 +666,7 @@ type AtmsExport struct {
 func mapBytesToAtms(data []byte,
 	unmarshal func([]byte, interface{}) error,
 ) ([]interface{}, error) {
-	atmsExport := AtmsExport{}
-	err := unmarshal(data, &atmsExport)
+	atmsExport, err := unmarshalAtms(data, unmarshal)
 	if err != nil {
 		return nil, err
 	}
@@ -599,16 +676,62 @@ func mapBytesToAtms(data []byte,
 	}
 	return ifaces, nil
 }
-func insertAtmToDB(iface interface{}, db *sql.DB) error {
-	atm := iface.(Atm)
+func insertAtm(atm Atm, db execer) error {
 	_, err := db.Exec(
-		insertAtmSql,
-		sql.Named("id", atm.Id),
+		importAtmSQL,
+		sql.Named("id", nullableId(atm.Id)),
 		sql.Named("name", atm.Name),
 		sql.Named("street", atm.Address),
+		sql.Named("latitude", atm.Latitude),
+		sql.Named("longitude", atm.Longitude),
+		sql.Named("status", atm.Status),
+		sql.Named("open_time", atm.OpenTime),
+		sql.Named("close_time", atm.CloseTime),
+		sql.Named("operations", joinOperations(atm.Operations)),
 	)
 	if err != nil {
-		return err
+		return queryError(importAtmSQL, err)
+	}
+	return nil
+}
+
+type ServicesExport struct {
+	XMLName xml.Name `json:"-" xml:"services"`
+	Version int `json:"version" xml:"version,attr"`
+	Services []Services `json:"services" xml:"service"`
+}
+
+func mapBytesToServices(data []byte,
+	unmarshal func([]byte, interface{}) error,
+) ([]interface{}, error) {
+	servicesExport, err := unmarshalServices(data, unmarshal)
+	if err != nil {
+		return nil, err
+	}
+	ifaces := make([]interface{}, len(servicesExport.Services))
+	for index := range ifaces {
+		ifaces[index] = servicesExport.Services[index]
+	}
+	return ifaces, nil
+}
+func insertService(service Services, db execer) error {
+	_, err := db.Exec(
+		importServiceSQL,
+		sql.Named("id", nullableId(service.Id)),
+		sql.Named("name", service.Name),
+		sql.Named("balance", service.Balance),
+		sql.Named("reference_pattern", service.ReferencePattern),
+		sql.Named("min_amount", service.MinAmount),
+		sql.Named("max_amount", service.MaxAmount),
+		sql.Named("category_id", service.CategoryId),
+		sql.Named("description", service.Description),
+		sql.Named("icon", service.Icon),
+		sql.Named("disabled", service.Disabled),
+		sql.Named("position", service.Position),
+		sql.Named("settlement_period", service.SettlementPeriod),
+	)
+	if err != nil {
+		return queryError(importServiceSQL, err)
 	}
 	return nil
 }
@@ -624,13 +747,40 @@ func ExportToFile(
 	mapRow MapperRowTo,
 	marshal Marshaller,
 	mapDataSlice MapperInterfaceSliceTo) error {
+	return exportQueryToFile(db, filename, mapRow, marshal, mapDataSlice, getDataFromDbSQL)
+}
+
+// exportQueryToFile is ExportToFile for queries with arguments, file is replaced atomically
+func exportQueryToFile(
+	db *sql.DB,
+	filename string,
+	mapRow MapperRowTo,
+	marshal Marshaller,
+	mapDataSlice MapperInterfaceSliceTo,
+	getDataFromDbSQL string,
+	args ...interface{}) error {
+	return writeFileAtomic(filename, exportFileMode, func(w io.Writer) error {
+		return exportQueryToWriter(w, db, mapRow, marshal, mapDataSlice, getDataFromDbSQL, args...)
+	})
+}
+
+func exportQueryToWriter(
+	w io.Writer,
+	db *sql.DB,
+	mapRow MapperRowTo,
+	marshal Marshaller,
+	mapDataSlice MapperInterfaceSliceTo,
+	getDataFromDbSQL string,
+	args ...interface{}) (err error) {
 
-	rows, err := db.Query(getDataFromDbSQL)
+	rows, err := db.Query(getDataFromDbSQL, args...)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		err = rows.Close()
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError(innerErr)
+		}
 	}()
 	var dataSlice []interface{}
 	for rows.Next() {
@@ -640,9 +790,15 @@ func ExportToFile(
 		}
 		dataSlice = append(dataSlice, dataElement)
 	}
+	if rows.Err() != nil {
+		return dbError(rows.Err())
+	}
 	exportData := mapDataSlice(dataSlice)
 	data, err := marshal(exportData)
-	err = ioutil.WriteFile(filename, data, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
 	if err != nil {
 		return err
 	}
@@ -652,18 +808,35 @@ func ExportToFile(
 
 type MapperBytesTo func([]byte) ([]interface{}, error)
 
+// ImportFromFile inserts records one by one, records before failed one stay imported,
+// ImportFromFileTx imports whole file in one transaction
 func ImportFromFile(
 	db *sql.DB,
 	filename string,
 	mapBytes MapperBytesTo,
 	insertToDB func(interface{}, *sql.DB) error,
-) error {
-	itemsData, err := ioutil.ReadFile(filename)
+) (err error) {
+	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return ImportFromReader(db, file, mapBytes, insertToDB)
+}
 
-	sliceData, err := mapBytes(itemsData)
+func ImportFromReader(
+	db *sql.DB,
+	r io.Reader,
+	mapBytes MapperBytesTo,
+	insertToDB func(interface{}, *sql.DB) error,
+) error {
+	sliceData, err := mapReader(r, mapBytes)
+	if err != nil {
+		return err
+	}
 
 	for _, datum := range sliceData {
 		err = insertToDB(datum, db)
@@ -673,4 +846,54 @@ func ImportFromFile(
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func ImportFromFileTx(
+	db *sql.DB,
+	filename string,
+	mapBytes MapperBytesTo,
+	insertToDB func(interface{}, *sql.Tx) error,
+) (err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return ImportFromReaderTx(db, file, mapBytes, insertToDB)
+}
+
+// ImportFromReaderTx inserts all records in one transaction, nothing is imported when some of them failed
+func ImportFromReaderTx(
+	db *sql.DB,
+	r io.Reader,
+	mapBytes MapperBytesTo,
+	insertToDB func(interface{}, *sql.Tx) error,
+) error {
+	sliceData, err := mapReader(r, mapBytes)
+	if err != nil {
+		return err
+	}
+
+	items := make([]importItem, len(sliceData))
+	for index, datum := range sliceData {
+		items[index] = importItem{index: index + 1, value: datum}
+	}
+	report, err := importItems(items, func(value interface{}, _ ImportOptions, tx *sql.Tx) error {
+		return insertToDB(value, tx)
+	}, ImportOptions{}, db)
+	if err != nil {
+		return err
+	}
+	return report.err()
+}
+
+func mapReader(r io.Reader, mapBytes MapperBytesTo) ([]interface{}, error) {
+	itemsData, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return mapBytes(itemsData)
+}
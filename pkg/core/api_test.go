@@ -40,7 +40,9 @@ func TestLoginClient_NoSuchLoginForEmptyDb(t *testing.T) {
   CREATE TABLE client (
    id INTEGER PRIMARY KEY AUTOINCREMENT,
   login TEXT NOT NULL UNIQUE,
-  password TEXT NOT NULL)`)
+  password TEXT NOT NULL,
+  removed INTEGER NOT NULL DEFAULT 0,
+  locked_until INTEGER NOT NULL DEFAULT 0)`)
 	if err != nil {
 		t.Errorf("can't execute query: %v", err)
 	}
@@ -72,7 +74,9 @@ func TestLoginClient_LoginOk(t *testing.T) {
   CREATE TABLE client (
    id INTEGER PRIMARY KEY AUTOINCREMENT,
   login TEXT NOT NULL UNIQUE,
-  password TEXT NOT NULL)`)
+  password TEXT NOT NULL,
+  removed INTEGER NOT NULL DEFAULT 0,
+  locked_until INTEGER NOT NULL DEFAULT 0)`)
 	if err != nil {
 		t.Errorf("can't execute Login: %v", err)
 	}
@@ -108,7 +112,9 @@ func TestLoginClient_LoginNotOkForInvalidPassword(t *testing.T) {
  CREATE TABLE client (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
  login TEXT NOT NULL UNIQUE,
- password TEXT NOT NULL)`)
+ password TEXT NOT NULL,
+ removed INTEGER NOT NULL DEFAULT 0,
+ locked_until INTEGER NOT NULL DEFAULT 0)`)
 	if err != nil {
 		t.Errorf("can't execute Login: %v", err)
 	}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AtmOnline       = "online"
+	AtmOutOfService = "out_of_service"
+	AtmOutOfCash    = "out_of_cash"
+)
+
+const (
+	OperationWithdraw = "withdraw"
+	OperationDeposit  = "deposit"
+	OperationTransfer = "transfer"
+	OperationPayment  = "payment"
+)
+
+// earthRadius in meters, used for great-circle distance
+const earthRadius = 6371000.0
+
+var ErrInvalidAtmStatus = errors.New("invalid atm status")
+var ErrInvalidWorkingHours = errors.New("invalid working hours")
+
+type AtmFilter struct {
+	// OpenAt keeps only ATMs working at given moment, zero value disables check
+	OpenAt     time.Time
+	Statuses   []string
+	Operations []string
+}
+
+type NearestAtm struct {
+	Atm
+	Distance float64
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAtm(row rowScanner) (atm Atm, err error) {
+	var operations string
+	err = row.Scan(&atm.Id, &atm.Name, &atm.Address, &atm.Latitude, &atm.Longitude,
+		&atm.Status, &atm.OpenTime, &atm.CloseTime, &operations, &atm.Version)
+	if err != nil {
+		return Atm{}, err
+	}
+	atm.Operations = splitOperations(operations)
+	return atm, nil
+}
+
+func joinOperations(operations []string) string {
+	return strings.Join(operations, ",")
+}
+
+func splitOperations(operations string) []string {
+	if operations == "" {
+		return nil
+	}
+	return strings.Split(operations, ",")
+}
+
+func normalizeAtm(atm Atm) (Atm, error) {
+	switch atm.Status {
+	case "":
+		atm.Status = AtmOnline
+	case AtmOnline, AtmOutOfService, AtmOutOfCash:
+	default:
+		return atm, ErrInvalidAtmStatus
+	}
+
+	if atm.OpenTime == "" {
+		atm.OpenTime = "00:00"
+	}
+	if atm.CloseTime == "" {
+		atm.CloseTime = "24:00"
+	}
+	if _, err := parseClock(atm.OpenTime); err != nil {
+		return atm, err
+	}
+	if _, err := parseClock(atm.CloseTime); err != nil {
+		return atm, err
+	}
+
+	return atm, nil
+}
+
+// parseClock converts "HH:MM" to minutes since midnight, "24:00" is allowed as end of day
+func parseClock(clock string) (int, error) {
+	if len(clock) != 5 || clock[2] != ':' {
+		return 0, ErrInvalidWorkingHours
+	}
+	digits := clock[:2] + clock[3:]
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, ErrInvalidWorkingHours
+		}
+	}
+	hours := int(clock[0]-'0')*10 + int(clock[1]-'0')
+	minutes := int(clock[3]-'0')*10 + int(clock[4]-'0')
+	if minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, ErrInvalidWorkingHours
+	}
+	return hours*60 + minutes, nil
+}
+
+func (receiver Atm) IsOpen(moment time.Time) bool {
+	open, err := parseClock(receiver.OpenTime)
+	if err != nil {
+		return false
+	}
+	closing, err := parseClock(receiver.CloseTime)
+	if err != nil {
+		return false
+	}
+	current := moment.Hour()*60 + moment.Minute()
+
+	switch {
+	case open == closing || (open == 0 && closing == 24*60):
+		return true
+	case open < closing:
+		return current >= open && current < closing
+	default: // works overnight, e.g. 20:00-08:00
+		return current >= open || current < closing
+	}
+}
+
+func (receiver Atm) supports(operation string) bool {
+	for _, supported := range receiver.Operations {
+		if supported == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func (receiver AtmFilter) match(atm Atm) bool {
+	if !receiver.OpenAt.IsZero() {
+		if atm.Status != AtmOnline || !atm.IsOpen(receiver.OpenAt) {
+			return false
+		}
+	}
+	if len(receiver.Statuses) != 0 {
+		found := false
+		for _, status := range receiver.Statuses {
+			if atm.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, operation := range receiver.Operations {
+		if !atm.supports(operation) {
+			return false
+		}
+	}
+	return true
+}
+
+// Distance returns great-circle distance in meters (haversine formula)
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	deltaPhi := (lat2 - lat1) * math.Pi / 180
+	deltaLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// FindNearestAtms returns ATMs within radius (meters) from point ordered by distance
+func FindNearestAtms(lat, lon, radius float64, filter AtmFilter, db *sql.DB) (atms []NearestAtm, err error) {
+	delta := radius / earthRadius * 180 / math.Pi
+	rows, err := db.Query(getAtmsInLatitudeRangeSQL, lat-delta, lat+delta)
+	if err != nil {
+		return nil, queryError(getAtmsInLatitudeRangeSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			atms, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		atm, err := scanAtm(rows)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		if !filter.match(atm) {
+			continue
+		}
+		distance := Distance(lat, lon, atm.Latitude, atm.Longitude)
+		if distance > radius {
+			continue
+		}
+		atms = append(atms, NearestAtm{Atm: atm, Distance: distance})
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+
+	sort.SliceStable(atms, func(i, j int) bool {
+		return atms[i].Distance < atms[j].Distance
+	})
+	return atms, nil
+}
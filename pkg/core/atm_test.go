@@ -0,0 +1,95 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestDistance_KnownPoints(t *testing.T) {
+	// Dushanbe - Khujand, about 195 km
+	distance := Distance(38.5598, 68.7870, 40.2826, 69.6222)
+	if distance < 190000 || distance > 210000 {
+		t.Errorf("unexpected distance: %v", distance)
+	}
+}
+
+func TestAtm_IsOpenOvernight(t *testing.T) {
+	atm := Atm{OpenTime: "20:00", CloseTime: "08:00"}
+	night := time.Date(2020, 1, 1, 23, 30, 0, 0, time.UTC)
+	day := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if !atm.IsOpen(night) {
+		t.Error("atm must be open at night")
+	}
+	if atm.IsOpen(day) {
+		t.Error("atm must be closed at day")
+	}
+}
+
+func TestAddAtm_InvalidStatus(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = AddAtm(Atm{Name: "atm", Address: "Rudaki 1", Status: "broken"}, db)
+	if !errors.Is(err, ErrInvalidAtmStatus) {
+		t.Errorf("Not ErrInvalidAtmStatus for invalid status: %v", err)
+	}
+}
+
+func TestFindNearestAtms_FiltersAndSorts(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+
+	atms := []Atm{
+		{Name: "far", Address: "Rudaki 100", Latitude: 38.60, Longitude: 68.80},
+		{Name: "near", Address: "Rudaki 1", Latitude: 38.5600, Longitude: 68.7870, Operations: []string{OperationWithdraw}},
+		{Name: "empty", Address: "Rudaki 2", Latitude: 38.5601, Longitude: 68.7871, Status: AtmOutOfCash},
+		{Name: "closed", Address: "Rudaki 3", Latitude: 38.5602, Longitude: 68.7872, OpenTime: "09:00", CloseTime: "18:00"},
+		{Name: "other city", Address: "Lenin 1", Latitude: 40.2826, Longitude: 69.6222},
+	}
+	for _, atm := range atms {
+		err = AddAtm(atm, db)
+		if err != nil {
+			t.Fatalf("can't add atm: %v", err)
+		}
+	}
+
+	night := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	found, err := FindNearestAtms(38.5598, 68.7870, 10000, AtmFilter{OpenAt: night}, db)
+	if err != nil {
+		t.Fatalf("can't find atms: %v", err)
+	}
+	if len(found) != 2 || found[0].Name != "near" || found[1].Name != "far" {
+		t.Errorf("unexpected atms: %v", found)
+	}
+
+	found, err = FindNearestAtms(38.5598, 68.7870, 10000, AtmFilter{Operations: []string{OperationWithdraw}}, db)
+	if err != nil {
+		t.Fatalf("can't find atms: %v", err)
+	}
+	if len(found) != 1 || found[0].Name != "near" {
+		t.Errorf("unexpected atms: %v", found)
+	}
+}
@@ -0,0 +1,493 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is stored in database by Init, backups are restored only into the same version
+const SchemaVersion = 1
+
+const backupFormatVersion = 1
+const backupManifestFile = "manifest.json"
+
+// Policies for passwords of clients and managers in backup
+const (
+	SensitiveRedact  = "redact"
+	SensitiveInclude = "include"
+)
+
+var ErrInvalidBackup = errors.New("invalid backup")
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+var ErrSchemaVersion = errors.New("backup schema version differs from database")
+var ErrDatabaseNotEmpty = errors.New("database is not empty")
+var ErrUnknownSensitivePolicy = errors.New("unknown sensitive fields policy")
+var ErrMaskedBackup = errors.New("backup masked by profile can't be restored")
+
+// backupTables are listed in restore order, sensitive columns are handled by policy
+var backupTables = []struct {
+	name      string
+	sensitive []string
+}{
+	{name: "managers", sensitive: []string{"password"}},
+	{name: "client", sensitive: []string{"password"}},
+	{name: "atm"},
+	{name: "categories"},
+	{name: "services"},
+	{name: "settlements"},
+	{name: "transactions"},
+	{name: "receipts"},
+	{name: "webhooks", sensitive: []string{"secret"}},
+	{name: "outbox"},
+}
+
+type BackupManifest struct {
+	FormatVersion int           `json:"format_version"`
+	SchemaVersion int           `json:"schema_version"`
+	CreatedAt     int64         `json:"created_at"`
+	Sensitive     string        `json:"sensitive"`
+	Profile       string        `json:"profile,omitempty"`
+	Tables        []BackupTable `json:"tables"`
+}
+
+type BackupTable struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+type backupTableData struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// backupProfileColumns maps export fields to table columns where names differ
+var backupProfileColumns = map[string]string{"address": "street"}
+
+// Backup writes tar.gz with manifest and one JSON file per table, all tables are read in one transaction
+func Backup(w io.Writer, sensitive string, db *sql.DB) (err error) {
+	return BackupWithProfile(w, sensitive, nil, db)
+}
+
+// BackupWithProfile masks client, atm and service columns as profile tells, columns not chosen by profile
+// are redacted. Account numbers of transactions and receipts follow balance_number of clients and are
+// redacted with statement counterparty, references follow statement reference. Passwords and webhook
+// secrets are redacted whatever sensitive is. Masked backup is meant for analysis, Restore refuses it.
+func BackupWithProfile(w io.Writer, sensitive string, profile *ExportProfile, db *sql.DB) (err error) {
+	if sensitive != SensitiveRedact && sensitive != SensitiveInclude {
+		return ErrUnknownSensitivePolicy
+	}
+	masked := ExportProfile{}
+	if profile != nil {
+		masked = *profile
+		err = masked.checkStatementFields()
+		if err != nil {
+			return err
+		}
+		for _, fields := range [][]ExportField{masked.Clients, masked.Atms, masked.Services, masked.Statements} {
+			for _, field := range fields {
+				if _, err := masked.mask("", field.Mask); err != nil {
+					return fmt.Errorf("%w: %s", err, field.Mask)
+				}
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	manifest := BackupManifest{
+		FormatVersion: backupFormatVersion,
+		CreatedAt:     time.Now().Unix(),
+		Sensitive:     sensitive,
+	}
+	if profile != nil {
+		manifest.Profile = profile.Name
+		if manifest.Profile == "" {
+			manifest.Profile = "custom"
+		}
+	}
+	err = tx.QueryRow(getSchemaVersionSQL).Scan(&manifest.SchemaVersion)
+	if err != nil {
+		return queryError(getSchemaVersionSQL, err)
+	}
+
+	files := make([][]byte, len(backupTables))
+	for index, table := range backupTables {
+		var redacted []string
+		if sensitive == SensitiveRedact || profile != nil {
+			redacted = table.sensitive
+		}
+		data, err := dumpTable(table.name, redacted, newBackupMasks(table.name, profile), masked, tx)
+		if err != nil {
+			return err
+		}
+		files[index], err = json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		checksum := sha256.Sum256(files[index])
+		manifest.Tables = append(manifest.Tables, BackupTable{
+			Name:   table.name,
+			File:   table.name + ".json",
+			Rows:   len(data.Rows),
+			SHA256: hex.EncodeToString(checksum[:]),
+		})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	compressor := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressor)
+	err = writeTarFile(archive, backupManifestFile, manifestData, manifest.CreatedAt)
+	if err != nil {
+		return err
+	}
+	for index, table := range manifest.Tables {
+		err = writeTarFile(archive, table.File, files[index], manifest.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+// backupMasks returns rule per column of table, columns not chosen by profile use others rule
+type backupMasks struct {
+	columns map[string]string
+	others  string
+}
+
+func (receiver backupMasks) rule(column string) string {
+	if rule, ok := receiver.columns[column]; ok {
+		return rule
+	}
+	return receiver.others
+}
+
+func newBackupMasks(table string, profile *ExportProfile) backupMasks {
+	masks := backupMasks{columns: map[string]string{}, others: MaskNone}
+	if profile == nil {
+		return masks
+	}
+	var fields []ExportField
+	switch table {
+	case "client":
+		fields = profile.Clients
+	case "atm":
+		fields = profile.Atms
+	case "services":
+		fields = profile.Services
+	case "transactions", "receipts":
+		account := MaskNone
+		if profile.Clients != nil {
+			account = MaskRedact
+		}
+		for _, field := range profile.Clients {
+			if field.Name == "balance_number" {
+				account = field.Mask
+			}
+		}
+		if profile.statementMask("counterparty") == MaskRedact {
+			account = MaskRedact
+		}
+		masks.columns["payer_balance_number"] = account
+		masks.columns["payee_balance_number"] = account
+		masks.columns["reference"] = profile.statementMask("reference")
+		return masks
+	}
+	if fields != nil {
+		masks.others = MaskRedact
+	}
+	for _, field := range fields {
+		column := field.Name
+		if mapped, ok := backupProfileColumns[column]; ok {
+			column = mapped
+		}
+		masks.columns[column] = field.Mask
+	}
+	return masks
+}
+
+func dumpTable(table string, redacted []string, masks backupMasks, profile ExportProfile, tx *sql.Tx) (data backupTableData, err error) {
+	query := fmt.Sprintf(backupSelectSQL, table)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return backupTableData{}, queryError(query, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			data, err = backupTableData{}, dbError(innerErr)
+		}
+	}()
+
+	data.Columns, err = rows.Columns()
+	if err != nil {
+		return backupTableData{}, dbError(err)
+	}
+	data.Rows = [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(data.Columns))
+		pointers := make([]interface{}, len(values))
+		for index := range values {
+			pointers[index] = &values[index]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return backupTableData{}, dbError(err)
+		}
+		for index, column := range data.Columns {
+			if bytesValue, ok := values[index].([]byte); ok {
+				values[index] = string(bytesValue)
+			}
+			values[index], err = profile.mask(values[index], masks.rule(column))
+			if err != nil {
+				return backupTableData{}, err
+			}
+			for _, sensitive := range redacted {
+				if column == sensitive {
+					values[index] = ""
+				}
+			}
+		}
+		data.Rows = append(data.Rows, values)
+	}
+	if rows.Err() != nil {
+		return backupTableData{}, dbError(rows.Err())
+	}
+	return data, nil
+}
+
+func writeTarFile(archive *tar.Writer, name string, data []byte, modified int64) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(exportFileMode),
+		Size:    int64(len(data)),
+		ModTime: time.Unix(modified, 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(data)
+	return err
+}
+
+// ReadBackupManifest reads archive written by Backup and validates checksums of all tables
+func ReadBackupManifest(r io.Reader) (manifest BackupManifest, err error) {
+	manifest, _, err = readBackup(r)
+	return manifest, err
+}
+
+func readBackup(r io.Reader) (manifest BackupManifest, files map[string][]byte, err error) {
+	decompressor, err := gzip.NewReader(r)
+	if err != nil {
+		return BackupManifest{}, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	archive := tar.NewReader(decompressor)
+	files = map[string][]byte{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return BackupManifest{}, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		files[header.Name], err = ioutil.ReadAll(archive)
+		if err != nil {
+			return BackupManifest{}, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+	}
+
+	manifestData, ok := files[backupManifestFile]
+	if !ok {
+		return BackupManifest{}, nil, fmt.Errorf("%w: no %s", ErrInvalidBackup, backupManifestFile)
+	}
+	err = json.Unmarshal(manifestData, &manifest)
+	if err != nil {
+		return BackupManifest{}, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if manifest.FormatVersion != backupFormatVersion {
+		return BackupManifest{}, nil, fmt.Errorf("%w: format version %d", ErrInvalidBackup, manifest.FormatVersion)
+	}
+	if len(manifest.Tables) != len(backupTables) {
+		return BackupManifest{}, nil, fmt.Errorf("%w: expected %d tables", ErrInvalidBackup, len(backupTables))
+	}
+	for index, table := range manifest.Tables {
+		if table.Name != backupTables[index].name {
+			return BackupManifest{}, nil, fmt.Errorf("%w: unexpected table %s", ErrInvalidBackup, table.Name)
+		}
+		data, ok := files[table.File]
+		if !ok {
+			return BackupManifest{}, nil, fmt.Errorf("%w: no %s", ErrInvalidBackup, table.File)
+		}
+		checksum := sha256.Sum256(data)
+		if hex.EncodeToString(checksum[:]) != table.SHA256 {
+			return BackupManifest{}, nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, table.File)
+		}
+	}
+	return manifest, files, nil
+}
+
+// Restore loads backup into database prepared by Init, database has to be empty except
+// for managers added by Init which are replaced. Nothing is restored on error.
+// Clients and managers of redacted backup are restored with empty passwords and can't log in
+// until password is set again, webhooks without secret are disabled until added again.
+func Restore(r io.Reader, db *sql.DB) (err error) {
+	manifest, files, err := readBackup(r)
+	if err != nil {
+		return err
+	}
+	if manifest.SchemaVersion != SchemaVersion {
+		return ErrSchemaVersion
+	}
+	if manifest.Profile != "" {
+		return ErrMaskedBackup
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			err = dbError(err)
+		}
+	}()
+
+	var version int
+	err = tx.QueryRow(getSchemaVersionSQL).Scan(&version)
+	if err != nil {
+		return queryError(getSchemaVersionSQL, err)
+	}
+	if version != SchemaVersion {
+		return ErrSchemaVersion
+	}
+	for _, table := range backupTables[1:] {
+		query := fmt.Sprintf(countTableRowsSQL, table.name)
+		var count int
+		err = tx.QueryRow(query).Scan(&count)
+		if err != nil {
+			return queryError(query, err)
+		}
+		if count != 0 {
+			return ErrDatabaseNotEmpty
+		}
+	}
+	_, err = tx.Exec(deleteManagersSQL)
+	if err != nil {
+		return queryError(deleteManagersSQL, err)
+	}
+
+	for _, table := range manifest.Tables {
+		decoder := json.NewDecoder(bytes.NewReader(files[table.File]))
+		decoder.UseNumber()
+		data := backupTableData{}
+		err = decoder.Decode(&data)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, table.File, err)
+		}
+		err = restoreTable(table.Name, data, tx)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(disableWebhooksWithoutSecretSQL)
+	if err != nil {
+		return queryError(disableWebhooksWithoutSecretSQL, err)
+	}
+	return nil
+}
+
+func restoreTable(table string, data backupTableData, tx *sql.Tx) (err error) {
+	err = checkBackupColumns(table, data.Columns, tx)
+	if err != nil {
+		return err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(data.Columns)), ", ")
+	query := fmt.Sprintf(restoreRowSQL, table, `"`+strings.Join(data.Columns, `", "`)+`"`, placeholders)
+	statement, err := tx.Prepare(query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	defer func() {
+		_ = statement.Close()
+	}()
+
+	for _, row := range data.Rows {
+		if len(row) != len(data.Columns) {
+			return fmt.Errorf("%w: %s: expected %d values, got %d", ErrInvalidBackup, table, len(data.Columns), len(row))
+		}
+		for index, value := range row {
+			if number, ok := value.(json.Number); ok {
+				row[index], err = number.Int64()
+				if err != nil {
+					row[index], err = number.Float64()
+				}
+				if err != nil {
+					return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, table, err)
+				}
+			}
+		}
+		_, err = statement.Exec(row...)
+		if err != nil {
+			return queryError(query, err)
+		}
+	}
+	return nil
+}
+
+// checkBackupColumns allows only columns existing in table, names are put into restore query
+func checkBackupColumns(table string, columns []string, tx *sql.Tx) (err error) {
+	query := fmt.Sprintf(backupColumnsSQL, table)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	existing, err := rows.Columns()
+	_ = rows.Close()
+	if err != nil {
+		return dbError(err)
+	}
+
+	for _, column := range columns {
+		found := false
+		for _, candidate := range existing {
+			found = found || candidate == column
+		}
+		if !found {
+			return fmt.Errorf("%w: %s: unknown column %q", ErrInvalidBackup, table, column)
+		}
+	}
+	return nil
+}
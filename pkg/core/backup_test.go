@@ -0,0 +1,239 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openInitDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	return db
+}
+
+func TestBackup_RestoreIntoEmptyDatabase(t *testing.T) {
+	source := openInitDB(t)
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, source)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddClients(Client{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002}, source)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddAtm(Atm{Name: "Center", Address: "Rudaki 1", Latitude: 38.5}, source)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+	receipt, err := TransferByBalanceNumber(1001, 30, Client{BalanceNumber: 1002, Balance: 30}, source)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+
+	for _, sensitive := range []string{SensitiveInclude, SensitiveRedact} {
+		buffer := &bytes.Buffer{}
+		err = Backup(buffer, sensitive, source)
+		if err != nil {
+			t.Fatalf("can't backup: %v", err)
+		}
+
+		target := openInitDB(t)
+		err = Restore(bytes.NewReader(buffer.Bytes()), target)
+		if err != nil {
+			t.Fatalf("can't restore: %v", err)
+		}
+		_, ok, err := Login("vasya", "secret", target)
+		if err != nil && !errors.Is(err, ErrInvalidPass) {
+			t.Errorf("can't login: %v", err)
+		}
+		if ok != (sensitive == SensitiveInclude) {
+			t.Errorf("unexpected login result for %s: %v", sensitive, ok)
+		}
+		restored, err := GetReceipt(receipt.Number, target)
+		if err != nil || restored.Amount != 30 {
+			t.Errorf("receipt not restored: %v %v", restored, err)
+		}
+		atms, err := GetAllAtms(target)
+		if err != nil || len(atms) != 1 || atms[0].Latitude != 38.5 {
+			t.Errorf("atms not restored: %v %v", atms, err)
+		}
+
+		err = Restore(bytes.NewReader(buffer.Bytes()), target)
+		if err != ErrDatabaseNotEmpty {
+			t.Errorf("Not ErrDatabaseNotEmpty for second restore: %v", err)
+		}
+		_ = target.Close()
+	}
+
+	err = Backup(&bytes.Buffer{}, "plain", source)
+	if err != ErrUnknownSensitivePolicy {
+		t.Errorf("Not ErrUnknownSensitivePolicy for plain: %v", err)
+	}
+}
+
+func TestRestore_ChecksumMismatch(t *testing.T) {
+	source := openInitDB(t)
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	buffer := &bytes.Buffer{}
+	err := Backup(buffer, SensitiveRedact, source)
+	if err != nil {
+		t.Fatalf("can't backup: %v", err)
+	}
+
+	decompressor, err := gzip.NewReader(buffer)
+	if err != nil {
+		t.Fatalf("can't read backup: %v", err)
+	}
+	archive := tar.NewReader(decompressor)
+	tampered := &bytes.Buffer{}
+	compressor := gzip.NewWriter(tampered)
+	writer := tar.NewWriter(compressor)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatalf("can't read backup: %v", err)
+		}
+		if header.Name == "managers.json" {
+			data = bytes.Replace(data, []byte("Vasya"), []byte("Vanya"), 1)
+		}
+		err = writeTarFile(writer, header.Name, data, header.ModTime.Unix())
+		if err != nil {
+			t.Fatalf("can't write backup: %v", err)
+		}
+	}
+	_ = writer.Close()
+	_ = compressor.Close()
+
+	target := openInitDB(t)
+	defer func() {
+		if err := target.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Restore(tampered, target)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Not ErrChecksumMismatch for tampered backup: %v", err)
+	}
+}
+
+func TestRestore_RedactedPasswords(t *testing.T) {
+	source := openInitDB(t)
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, source)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	buffer := &bytes.Buffer{}
+	err = Backup(buffer, SensitiveRedact, source)
+	if err != nil {
+		t.Fatalf("can't backup: %v", err)
+	}
+	target := openInitDB(t)
+	defer func() {
+		if err := target.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Restore(bytes.NewReader(buffer.Bytes()), target)
+	if err != nil {
+		t.Fatalf("can't restore: %v", err)
+	}
+
+	for _, password := range []string{"", "secret"} {
+		_, ok, err := Login("vasya", password, target)
+		if ok || err != nil && !errors.Is(err, ErrInvalidPass) {
+			t.Errorf("client logged in with %q after redacted restore: %v %v", password, ok, err)
+		}
+		ok, err = LoginForManagers("vasya", password, target)
+		if ok || err != nil && !errors.Is(err, ErrInvalidPass) {
+			t.Errorf("manager logged in with %q after redacted restore: %v %v", password, ok, err)
+		}
+	}
+}
+
+func TestRestore_RedactedWebhooks(t *testing.T) {
+	source := openInitDB(t)
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddWebhook(Webhook{URL: "https://example.com/hook", Secret: "key", Events: []string{EventLoginLocked}}, source)
+	if err != nil {
+		t.Fatalf("can't add webhook: %v", err)
+	}
+	err = LockLogin("vasya", time.Now().Add(time.Minute), source)
+	if err != nil {
+		t.Fatalf("can't publish: %v", err)
+	}
+
+	for _, sensitive := range []string{SensitiveInclude, SensitiveRedact} {
+		buffer := &bytes.Buffer{}
+		err = Backup(buffer, sensitive, source)
+		if err != nil {
+			t.Fatalf("can't backup: %v", err)
+		}
+		target := openInitDB(t)
+		err = Restore(bytes.NewReader(buffer.Bytes()), target)
+		if err != nil {
+			t.Fatalf("can't restore: %v", err)
+		}
+
+		events, err := GetEventsByStatus(OutboxPending, target)
+		if err != nil || len(events) != 1 {
+			t.Errorf("outbox not restored: %v %v", events, err)
+		}
+		webhooks, err := GetWebhooks(target)
+		if err != nil || len(webhooks) != 1 || webhooks[0].Disabled != (sensitive == SensitiveRedact) {
+			t.Errorf("unexpected webhooks after %s restore: %v %v", sensitive, webhooks, err)
+		}
+		err = SetWebhookDisabled(1, false, target)
+		if err != nil {
+			t.Fatalf("can't enable webhook: %v", err)
+		}
+		err = LockLogin("petya", time.Now().Add(time.Minute), target)
+		if err != nil {
+			t.Fatalf("can't publish: %v", err)
+		}
+		expected := 2
+		if sensitive == SensitiveRedact {
+			expected = 1
+		}
+		events, err = GetEventsByStatus(OutboxPending, target)
+		if err != nil || len(events) != expected {
+			t.Errorf("webhook without secret got event after %s restore: %v %v", sensitive, events, err)
+		}
+		_ = target.Close()
+	}
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrCategoryCycle = errors.New("category can't be moved into itself or its subcategory")
+
+// Category groups services into payment menu, zero ParentId means top level
+type Category struct {
+	Id          int64
+	ParentId    int64
+	Name        string
+	Description string
+	Icon        string
+	Disabled    bool
+	Position    int
+}
+
+func checkCategoryExists(id int64, db queryRower) (err error) {
+	if id == 0 {
+		return nil
+	}
+	var existing int64
+	err = db.QueryRow(checkCategoryExistsSQL, id).Scan(&existing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return queryError(checkCategoryExistsSQL, err)
+	}
+	return nil
+}
+
+func AddCategory(category Category, db *sql.DB) (err error) {
+	err = checkCategoryExists(category.ParentId, db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		insertCategorySQL,
+		sql.Named("parent_id", category.ParentId),
+		sql.Named("name", category.Name),
+		sql.Named("description", category.Description),
+		sql.Named("icon", category.Icon),
+		sql.Named("disabled", category.Disabled),
+		sql.Named("position", category.Position),
+	)
+	if err != nil {
+		return queryError(insertCategorySQL, err)
+	}
+
+	return nil
+}
+
+// UpdateCategory changes category and moves it under ParentId, disabled flag is changed by SetCategoryDisabled
+func UpdateCategory(category Category, db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			err = dbError(err)
+		}
+	}()
+
+	err = checkCategoryExists(category.ParentId, tx)
+	if err != nil {
+		return err
+	}
+	err = checkCategoryCycle(category.Id, category.ParentId, tx)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		updateCategorySQL,
+		sql.Named("id", category.Id),
+		sql.Named("parent_id", category.ParentId),
+		sql.Named("name", category.Name),
+		sql.Named("description", category.Description),
+		sql.Named("icon", category.Icon),
+		sql.Named("position", category.Position),
+	)
+	if err != nil {
+		return queryError(updateCategorySQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// checkCategoryCycle walks up from new parent, category can't be found among its own ancestors
+func checkCategoryCycle(id int64, parentId int64, tx *sql.Tx) (err error) {
+	if parentId == 0 {
+		return nil
+	}
+	var count int
+	err = tx.QueryRow(checkCategoryCycleSQL, sql.Named("id", id), sql.Named("parent_id", parentId)).Scan(&count)
+	if err != nil {
+		return queryError(checkCategoryCycleSQL, err)
+	}
+	if count != 0 {
+		return ErrCategoryCycle
+	}
+	return nil
+}
+
+// GetCategories returns enabled subcategories of enabled parent in menu order, use zero for top level
+func GetCategories(parentId int64, db *sql.DB) (categories []Category, err error) {
+	rows, err := db.Query(getCategoriesSQL, parentId)
+	if err != nil {
+		return nil, queryError(getCategoriesSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			categories, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		category := Category{}
+		err = rows.Scan(&category.Id, &category.ParentId, &category.Name, &category.Description,
+			&category.Icon, &category.Disabled, &category.Position)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		categories = append(categories, category)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+
+	return categories, nil
+}
+
+// GetServicesByCategory returns enabled services of category in menu order, category and its parents have to be enabled
+func GetServicesByCategory(categoryId int64, db *sql.DB) (services []Services, err error) {
+	rows, err := db.Query(getServicesByCategorySQL, categoryId)
+	if err != nil {
+		return nil, queryError(getServicesByCategorySQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			services, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		service, err := scanService(rows)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		services = append(services, service)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+
+	return services, nil
+}
+
+func setDisabled(query string, id int64, disabled bool, db *sql.DB) (err error) {
+	result, err := db.Exec(
+		query,
+		sql.Named("id", id),
+		sql.Named("disabled", disabled),
+	)
+	if err != nil {
+		return queryError(query, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func SetCategoryDisabled(id int64, disabled bool, db *sql.DB) (err error) {
+	return setDisabled(setCategoryDisabledSQL, id, disabled, db)
+}
+
+func SetServiceDisabled(id int64, disabled bool, db *sql.DB) (err error) {
+	return setDisabled(setServiceDisabledSQL, id, disabled, db)
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestGetCategories_HidesDisabledBranch(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	categories := []Category{
+		{Name: "Mobile", Position: 2},
+		{Name: "Utilities", Position: 1},
+		{ParentId: 1, Name: "Tajikistan"},
+	}
+	for _, category := range categories {
+		err := AddCategory(category, db)
+		if err != nil {
+			t.Fatalf("can't add category: %v", err)
+		}
+	}
+	err := AddServices(Services{Name: "Tcell", CategoryId: 3}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+
+	found, err := GetCategories(0, db)
+	if err != nil || len(found) != 2 || found[0].Name != "Utilities" {
+		t.Errorf("unexpected top level categories: %v %v", found, err)
+	}
+	services, err := GetServicesByCategory(3, db)
+	if err != nil || len(services) != 1 {
+		t.Errorf("unexpected services: %v %v", services, err)
+	}
+
+	err = SetCategoryDisabled(1, true, db)
+	if err != nil {
+		t.Fatalf("can't disable category: %v", err)
+	}
+	found, err = GetCategories(0, db)
+	if err != nil || len(found) != 1 || found[0].Name != "Utilities" {
+		t.Errorf("disabled category listed: %v %v", found, err)
+	}
+	found, err = GetCategories(1, db)
+	if err != nil || len(found) != 0 {
+		t.Errorf("subcategory of disabled category listed: %v %v", found, err)
+	}
+	services, err = GetServicesByCategory(3, db)
+	if err != nil || len(services) != 0 {
+		t.Errorf("service of disabled category listed: %v %v", services, err)
+	}
+}
+
+func TestPayForServices_DisabledCategory(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddCategory(Category{Name: "Mobile"}, db)
+	if err != nil {
+		t.Fatalf("can't add category: %v", err)
+	}
+	err = AddCategory(Category{ParentId: 1, Name: "Tajikistan"}, db)
+	if err != nil {
+		t.Fatalf("can't add category: %v", err)
+	}
+	err = AddServices(Services{Name: "Tcell", CategoryId: 2}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+	err = SetCategoryDisabled(1, true, db)
+	if err != nil {
+		t.Fatalf("can't disable category: %v", err)
+	}
+
+	_, err = PayForServices(1001, 50, "900123456", Services{Id: 1}, db)
+	if !errors.Is(err, ErrServiceDisabled) {
+		t.Errorf("Not ErrServiceDisabled for service in disabled category: %v", err)
+	}
+
+	err = SetCategoryDisabled(1, false, db)
+	if err != nil {
+		t.Fatalf("can't enable category: %v", err)
+	}
+	_, err = PayForServices(1001, 50, "900123456", Services{Id: 1}, db)
+	if err != nil {
+		t.Errorf("can't pay for service in enabled category: %v", err)
+	}
+}
+
+func TestUpdateCategory_Cycle(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	categories := []Category{
+		{Name: "Payments"},
+		{ParentId: 1, Name: "Mobile"},
+		{ParentId: 2, Name: "Tajikistan"},
+		{Name: "Utilities"},
+	}
+	for _, category := range categories {
+		err := AddCategory(category, db)
+		if err != nil {
+			t.Fatalf("can't add category: %v", err)
+		}
+	}
+
+	err := UpdateCategory(Category{Id: 1, ParentId: 1, Name: "Payments"}, db)
+	if !errors.Is(err, ErrCategoryCycle) {
+		t.Errorf("Not ErrCategoryCycle for own parent: %v", err)
+	}
+	err = UpdateCategory(Category{Id: 1, ParentId: 3, Name: "Payments"}, db)
+	if !errors.Is(err, ErrCategoryCycle) {
+		t.Errorf("Not ErrCategoryCycle for subcategory as parent: %v", err)
+	}
+	err = UpdateCategory(Category{Id: 1, ParentId: 5, Name: "Payments"}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for unknown parent: %v", err)
+	}
+	err = UpdateCategory(Category{Id: 6, Name: "Unknown"}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for unknown category: %v", err)
+	}
+
+	err = UpdateCategory(Category{Id: 2, ParentId: 4, Name: "Mobile"}, db)
+	if err != nil {
+		t.Fatalf("can't move category: %v", err)
+	}
+	found, err := GetCategories(4, db)
+	if err != nil || len(found) != 1 || found[0].Name != "Mobile" {
+		t.Errorf("unexpected subcategories: %v %v", found, err)
+	}
+	err = UpdateCategory(Category{Id: 1, ParentId: 3, Name: "Payments"}, db)
+	if err != nil {
+		t.Errorf("can't move category under former subcategory: %v", err)
+	}
+}
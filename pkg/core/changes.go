@@ -0,0 +1,139 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+)
+
+// Operations of exported changes
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
+
+// Entities of exported changes
+const (
+	EntityClient      = "client"
+	EntityAtm         = "atm"
+	EntityService     = "service"
+	EntityTransaction = "transaction"
+)
+
+// Change is one changed row, Data is empty for deleted rows and clients have no password.
+// Sequence numbers changes of all entities in commit order.
+type Change struct {
+	Entity    string      `json:"entity"`
+	Operation string      `json:"operation"`
+	Id        int64       `json:"id"`
+	Sequence  int64       `json:"sequence"`
+	CreatedAt int64       `json:"created_at"`
+	UpdatedAt int64       `json:"updated_at"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// changeSource scans rows of one entity, columns removed, change_seq, created_at and updated_at go first
+type changeSource struct {
+	entity string
+	query  string
+	scan   func(row rowScanner) (id int64, data interface{}, err error)
+}
+
+var changeSources = []changeSource{
+	{entity: EntityClient, query: changedClientsSQL, scan: func(row rowScanner) (int64, interface{}, error) {
+		client, err := scanClient(row)
+		return client.Id, client, err
+	}},
+	{entity: EntityAtm, query: changedAtmsSQL, scan: func(row rowScanner) (int64, interface{}, error) {
+		atm, err := scanAtm(row)
+		return atm.Id, atm, err
+	}},
+	{entity: EntityService, query: changedServicesSQL, scan: func(row rowScanner) (int64, interface{}, error) {
+		service, err := scanService(row)
+		return service.Id, service, err
+	}},
+	{entity: EntityTransaction, query: changedTransactionsSQL, scan: func(row rowScanner) (int64, interface{}, error) {
+		transaction, err := scanTransaction(row)
+		return transaction.Id, transaction, err
+	}},
+}
+
+// changeRow scans change columns before columns of entity
+type changeRow struct {
+	rows    *sql.Rows
+	change  *Change
+	removed *bool
+}
+
+func (receiver changeRow) Scan(dest ...interface{}) error {
+	return receiver.rows.Scan(append([]interface{}{receiver.removed, &receiver.change.Sequence, &receiver.change.CreatedAt, &receiver.change.UpdatedAt}, dest...)...)
+}
+
+// ExportChanges writes rows changed since watermark as JSON lines and returns watermark for next export.
+// Watermark is next change sequence number, start with 0 to export all rows.
+func ExportChanges(w io.Writer, since int64, db *sql.DB) (watermark int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return since, dbError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = tx.QueryRow(getChangeSequenceSQL).Scan(&watermark)
+	if err != nil {
+		return since, queryError(getChangeSequenceSQL, err)
+	}
+	watermark++
+	if watermark < since {
+		watermark = since
+	}
+	err = exportChanges(w, since, watermark, tx)
+	if err != nil {
+		return since, err
+	}
+	return watermark, nil
+}
+
+func exportChanges(w io.Writer, since int64, until int64, tx *sql.Tx) error {
+	encoder := json.NewEncoder(w)
+	for _, source := range changeSources {
+		err := source.export(encoder, since, until, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (receiver changeSource) export(encoder *json.Encoder, since int64, until int64, tx *sql.Tx) (err error) {
+	rows, err := tx.Query(receiver.query, sql.Named("since", since), sql.Named("until", until))
+	if err != nil {
+		return queryError(receiver.query, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			err = dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		change := Change{Entity: receiver.entity, Operation: ChangeUpsert}
+		removed := false
+		change.Id, change.Data, err = receiver.scan(changeRow{rows: rows, change: &change, removed: &removed})
+		if err != nil {
+			return dbError(err)
+		}
+		if removed {
+			change.Operation, change.Data = ChangeDelete, nil
+		}
+		err = encoder.Encode(change)
+		if err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		return dbError(rows.Err())
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestExportChanges_SinceWatermark(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddClients(Client{Name: "Petya", Login: "petya", Password: "secret", BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddAtm(Atm{Name: "Central", Address: "Rudaki 1"}, db)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+	_, err = db.Exec(`update client set created_at = 100, updated_at = 100; update atm set created_at = 100, updated_at = 100;`)
+	if err != nil {
+		t.Fatalf("can't move time: %v", err)
+	}
+	since, err := ExportChanges(ioutil.Discard, 0, db)
+	if err != nil {
+		t.Fatalf("can't export changes: %v", err)
+	}
+
+	err = RemoveClient(2, 1, db)
+	if err != nil {
+		t.Fatalf("can't remove client: %v", err)
+	}
+	// row committed with time older than export is still exported after it
+	_, err = db.Exec(`update client set updated_at = 50 where id = 2;`)
+	if err != nil {
+		t.Fatalf("can't move time: %v", err)
+	}
+	_, err = db.Exec(`update client set name = 'Vasiliy' where id = 1;`)
+	if err != nil {
+		t.Fatalf("can't update client: %v", err)
+	}
+	var touched int64
+	err = db.QueryRow(`select updated_at from client where id = 1;`).Scan(&touched)
+	if err != nil || touched <= 100 {
+		t.Fatalf("updated_at is not touched: %d %v", touched, err)
+	}
+
+	buffer := &bytes.Buffer{}
+	watermark, err := ExportChanges(buffer, since, db)
+	if err != nil || watermark <= since {
+		t.Fatalf("can't export changes: %d %v", watermark, err)
+	}
+	var changes []Change
+	scanner := bufio.NewScanner(buffer)
+	for scanner.Scan() {
+		change := Change{}
+		err = json.Unmarshal(scanner.Bytes(), &change)
+		if err != nil {
+			t.Fatalf("can't decode change %s: %v", scanner.Text(), err)
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %v", changes)
+	}
+	if changes[0].Id != 2 || changes[0].Operation != ChangeDelete || changes[0].Data != nil || changes[0].CreatedAt != 100 || changes[0].UpdatedAt != 50 {
+		t.Errorf("expected tombstone of client 2, got %v", changes[0])
+	}
+	data, _ := changes[1].Data.(map[string]interface{})
+	if changes[1].Id != 1 || changes[1].Operation != ChangeUpsert || data["name"] != "Vasiliy" || data["password"] != nil {
+		t.Errorf("expected client 1 without password, got %v", changes[1])
+	}
+}
+
+func TestExportChanges_Watermark(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddAtm(Atm{Name: "Central", Address: "Rudaki 1"}, db)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+
+	buffer := &bytes.Buffer{}
+	watermark, err := ExportChanges(buffer, 0, db)
+	if err != nil {
+		t.Fatalf("can't export changes: %v", err)
+	}
+	if watermark == 0 {
+		t.Errorf("watermark is not moved")
+	}
+	next, err := ExportChanges(buffer, watermark+10, db)
+	if err != nil || next != watermark+10 {
+		t.Errorf("watermark moved back: %d %v", next, err)
+	}
+}
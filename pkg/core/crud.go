@@ -0,0 +1,179 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrVersionConflict = errors.New("version conflict: entity was modified concurrently")
+
+type ConflictError struct {
+	Field string
+	Value interface{}
+}
+
+func (receiver *ConflictError) Error() string {
+	return fmt.Sprintf("%s %v already in use", receiver.Field, receiver.Value)
+}
+
+func checkUnique(query string, field string, value interface{}, id int64, db queryRower) (err error) {
+	var existing int64
+	err = db.QueryRow(query, value, id).Scan(&existing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return queryError(query, err)
+	}
+	return &ConflictError{Field: field, Value: value}
+}
+
+func checkClientUnique(client Client, db queryRower) (err error) {
+	err = checkUnique(checkClientLoginSQL, "login", client.Login, client.Id, db)
+	if err != nil {
+		return err
+	}
+	err = checkUnique(checkClientBalanceNumberSQL, "balance_number", client.BalanceNumber, client.Id, db)
+	if err != nil {
+		return err
+	}
+	return checkUnique(checkClientPhoneNumberSQL, "phone_number", client.PhoneNumber, client.Id, db)
+}
+
+// checkChanged tells apart missing entity and stale version when nothing was updated
+func checkChanged(result sql.Result, existsQuery string, id int64, db *sql.DB) (err error) {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if affected != 0 {
+		return nil
+	}
+
+	var existing int64
+	err = db.QueryRow(existsQuery, id).Scan(&existing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return queryError(existsQuery, err)
+	}
+	return ErrVersionConflict
+}
+
+func UpdateAtm(atm Atm, db *sql.DB) (err error) {
+	atm, err = normalizeAtm(atm)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(
+		updateAtmSQL,
+		sql.Named("id", atm.Id),
+		sql.Named("version", atm.Version),
+		sql.Named("name", atm.Name),
+		sql.Named("street", atm.Address),
+		sql.Named("latitude", atm.Latitude),
+		sql.Named("longitude", atm.Longitude),
+		sql.Named("status", atm.Status),
+		sql.Named("open_time", atm.OpenTime),
+		sql.Named("close_time", atm.CloseTime),
+		sql.Named("operations", joinOperations(atm.Operations)),
+	)
+	if err != nil {
+		return queryError(updateAtmSQL, err)
+	}
+
+	return checkChanged(result, checkAtmExistsSQL, atm.Id, db)
+}
+
+func UpdateService(service Services, db *sql.DB) (err error) {
+	err = checkUnique(checkServiceNameSQL, "name", service.Name, service.Id, db)
+	if err != nil {
+		return err
+	}
+	err = checkServiceLimits(service)
+	if err != nil {
+		return err
+	}
+	err = checkCategoryExists(service.CategoryId, db)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(
+		updateServiceSQL,
+		sql.Named("id", service.Id),
+		sql.Named("version", service.Version),
+		sql.Named("name", service.Name),
+		sql.Named("reference_pattern", service.ReferencePattern),
+		sql.Named("min_amount", service.MinAmount),
+		sql.Named("max_amount", service.MaxAmount),
+		sql.Named("category_id", service.CategoryId),
+		sql.Named("description", service.Description),
+		sql.Named("icon", service.Icon),
+		sql.Named("disabled", service.Disabled),
+		sql.Named("position", service.Position),
+		sql.Named("settlement_period", service.SettlementPeriod),
+	)
+	if err != nil {
+		return queryError(updateServiceSQL, err)
+	}
+
+	return checkChanged(result, checkServiceExistsSQL, service.Id, db)
+}
+
+// UpdateClient changes name, login and phone number, password is changed only when not empty
+func UpdateClient(client Client, db *sql.DB) (err error) {
+	err = checkUnique(checkClientLoginSQL, "login", client.Login, client.Id, db)
+	if err != nil {
+		return err
+	}
+	err = checkUnique(checkClientPhoneNumberSQL, "phone_number", client.PhoneNumber, client.Id, db)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(
+		updateClientSQL,
+		sql.Named("id", client.Id),
+		sql.Named("version", client.Version),
+		sql.Named("name", client.Name),
+		sql.Named("login", client.Login),
+		sql.Named("password", client.Password),
+		sql.Named("phone_number", client.PhoneNumber),
+	)
+	if err != nil {
+		return queryError(updateClientSQL, err)
+	}
+
+	return checkChanged(result, checkClientExistsSQL, client.Id, db)
+}
+
+func remove(query string, existsQuery string, id int64, version int64, db *sql.DB) (err error) {
+	result, err := db.Exec(
+		query,
+		sql.Named("id", id),
+		sql.Named("version", version),
+	)
+	if err != nil {
+		return queryError(query, err)
+	}
+
+	return checkChanged(result, existsQuery, id, db)
+}
+
+func RemoveAtm(id int64, version int64, db *sql.DB) (err error) {
+	return remove(removeAtmSQL, checkAtmExistsSQL, id, version, db)
+}
+
+func RemoveService(id int64, version int64, db *sql.DB) (err error) {
+	return remove(removeServiceSQL, checkServiceExistsSQL, id, version, db)
+}
+
+// RemoveClient keeps password of client, Login skips removed clients
+func RemoveClient(id int64, version int64, db *sql.DB) (err error) {
+	return remove(removeClientSQL, checkClientExistsSQL, id, version, db)
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestUpdateAtm_VersionConflict(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddAtm(Atm{Name: "atm", Address: "Rudaki 1"}, db)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+
+	atms, err := GetAllAtms(db)
+	if err != nil || len(atms) != 1 {
+		t.Fatalf("can't get atms: %v", err)
+	}
+	atm := atms[0]
+	atm.Address = "Rudaki 2"
+	err = UpdateAtm(atm, db)
+	if err != nil {
+		t.Errorf("can't update atm: %v", err)
+	}
+
+	atm.Address = "Rudaki 3"
+	err = UpdateAtm(atm, db)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Not ErrVersionConflict for stale version: %v", err)
+	}
+}
+
+func TestRemoveService_HiddenFromList(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddServices(Services{Name: "Tcell"}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+
+	err = RemoveService(1, 1, db)
+	if err != nil {
+		t.Errorf("can't remove service: %v", err)
+	}
+	services, err := GetServices(db)
+	if err != nil {
+		t.Errorf("can't get services: %v", err)
+	}
+	if len(services) != 0 {
+		t.Errorf("removed service is listed: %v", services)
+	}
+
+	err = RemoveService(1, 2, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for removed service: %v", err)
+	}
+}
+
+func TestUpdateClient_LoginConflict(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	clients := []Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya", Login: "petya", Password: "secret", BalanceNumber: 1002, PhoneNumber: 992900000002},
+	}
+	for _, client := range clients {
+		err = AddClients(client, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+
+	err = UpdateClient(Client{Id: 2, Version: 1, Name: "Petya", Login: "vasya", PhoneNumber: 992900000002}, db)
+	var typedErr *ConflictError
+	if ok := errors.As(err, &typedErr); !ok || typedErr.Field != "login" {
+		t.Errorf("error not match ConflictError: %v", err)
+	}
+}
+
+func TestRemoveClient_NoLoginAndNoTransfers(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	clients := []Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 1000, BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya", Login: "petya", Password: "secret", BalanceNumber: 1002, PhoneNumber: 992900000002},
+	}
+	for _, client := range clients {
+		err = AddClients(client, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+	err = RemoveClient(2, 1, db)
+	if err != nil {
+		t.Fatalf("can't remove client: %v", err)
+	}
+
+	_, result, err := Login("petya", "secret", db)
+	if err != nil || result {
+		t.Errorf("removed client logged in: %v %v", result, err)
+	}
+	err = CheckByBalanceNumber(1002, db)
+	if err != sql.ErrNoRows {
+		t.Errorf("removed client found by balance number: %v", err)
+	}
+	err = CheckByPhoneNumber(992900000002, db)
+	if err != sql.ErrNoRows {
+		t.Errorf("removed client found by phone number: %v", err)
+	}
+	balances, err := GetBalanceList(db, 2)
+	if err != nil || len(balances) != 0 {
+		t.Errorf("removed client has balance list: %v %v", balances, err)
+	}
+
+	_, err = TransferByBalanceNumber(1001, 100, Client{BalanceNumber: 1002, Balance: 100}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for transfer to removed client: %v", err)
+	}
+	_, err = TransferByPhoneNumber(1001, 100, Client{PhoneNumber: 992900000002, Balance: 100}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for transfer by phone to removed client: %v", err)
+	}
+	err = UpdateBalance(Client{Login: "petya", Balance: 100}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for top up of removed client: %v", err)
+	}
+	balances, err = GetBalanceList(db, 1)
+	if err != nil || len(balances) != 1 || balances[0].Balance != 1000 {
+		t.Errorf("payer balance changed: %v %v", balances, err)
+	}
+}
@@ -0,0 +1,406 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrUnknownEncoding = errors.New("unknown encoding")
+var ErrInvalidCSVHeader = errors.New("invalid csv header")
+
+// CSVOptions configures spreadsheet files, Columns maps field name to column title
+// (e.g. "phone_number": "Телефон"), fields without mapping use their own names
+type CSVOptions struct {
+	Delimiter rune
+	Encoding  string
+	Columns   map[string]string
+}
+
+// CSVRowError points to line of file where row starts, header is line 1
+type CSVRowError struct {
+	Line int
+	Err  error
+}
+
+func (receiver *CSVRowError) Error() string {
+	return fmt.Sprintf("line %d: %v", receiver.Line, receiver.Err)
+}
+
+func (receiver *CSVRowError) Unwrap() error {
+	return receiver.Err
+}
+
+// CSVImportError lists every invalid row of file
+type CSVImportError struct {
+	Rows []*CSVRowError
+}
+
+func (receiver *CSVImportError) Error() string {
+	messages := make([]string, len(receiver.Rows))
+	for index, row := range receiver.Rows {
+		messages[index] = row.Error()
+	}
+	return fmt.Sprintf("%d invalid rows: %s", len(receiver.Rows), strings.Join(messages, "; "))
+}
+
+// csvSpec lists fields of entity in file order, values returns them typed in the same order,
+// required fields have to be in header of imported file
+type csvSpec struct {
+	fields     []string
+	required   []string
+	values     func(interface{}) []interface{}
+	fromRecord func(values csvValues) (interface{}, error)
+}
+
+func (receiver csvSpec) toRecord(item interface{}) []string {
+	values := receiver.values(item)
+	record := make([]string, len(values))
+	for index, value := range values {
+		record[index] = formatCSVValue(value)
+	}
+	return record
+}
+
+func formatCSVValue(value interface{}) string {
+	switch value := value.(type) {
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case uint64:
+		return strconv.FormatUint(value, 10)
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case []string:
+		return joinOperations(value)
+	case string:
+		return value
+	}
+	return fmt.Sprint(value)
+}
+
+type csvRecord struct {
+	line  int
+	value interface{}
+}
+
+type csvValues map[string]string
+
+func (receiver csvValues) string(field string) string {
+	return strings.TrimSpace(receiver[field])
+}
+
+func (receiver csvValues) int(field string, err *error) int64 {
+	value := receiver.string(field)
+	if value == "" || *err != nil {
+		return 0
+	}
+	result, parseErr := strconv.ParseInt(value, 10, 64)
+	if parseErr != nil {
+		*err = fmt.Errorf("%s: invalid number %q", field, value)
+	}
+	return result
+}
+
+func (receiver csvValues) uint(field string, err *error) uint64 {
+	value := receiver.string(field)
+	if value == "" || *err != nil {
+		return 0
+	}
+	result, parseErr := strconv.ParseUint(value, 10, 64)
+	if parseErr != nil {
+		*err = fmt.Errorf("%s: invalid number %q", field, value)
+	}
+	return result
+}
+
+func (receiver csvValues) float(field string, err *error) float64 {
+	value := receiver.string(field)
+	if value == "" || *err != nil {
+		return 0
+	}
+	result, parseErr := strconv.ParseFloat(value, 64)
+	if parseErr != nil {
+		*err = fmt.Errorf("%s: invalid number %q", field, value)
+	}
+	return result
+}
+
+func (receiver csvValues) bool(field string, err *error) bool {
+	value := receiver.string(field)
+	if value == "" || *err != nil {
+		return false
+	}
+	result, parseErr := strconv.ParseBool(value)
+	if parseErr != nil {
+		*err = fmt.Errorf("%s: invalid boolean %q", field, value)
+	}
+	return result
+}
+
+var clientCSVSpec = csvSpec{
+	fields:   []string{"id", "name", "login", "password", "balance", "balance_number", "phone_number"},
+	required: []string{"name", "login", "balance_number", "phone_number"},
+	values: func(iface interface{}) []interface{} {
+		client := iface.(Client)
+		return []interface{}{client.Id, client.Name, client.Login, client.Password,
+			client.Balance, client.BalanceNumber, client.PhoneNumber}
+	},
+	fromRecord: func(values csvValues) (interface{}, error) {
+		var err error
+		client := Client{
+			Id:            values.int("id", &err),
+			Name:          values.string("name"),
+			Login:         values.string("login"),
+			Password:      values.string("password"),
+			Balance:       values.uint("balance", &err),
+			BalanceNumber: values.uint("balance_number", &err),
+			PhoneNumber:   values.int("phone_number", &err),
+		}
+		return client, err
+	},
+}
+
+var atmCSVSpec = csvSpec{
+	fields:   []string{"id", "name", "address", "latitude", "longitude", "status", "open_time", "close_time", "operations"},
+	required: []string{"name", "address"},
+	values: func(iface interface{}) []interface{} {
+		atm := iface.(Atm)
+		return []interface{}{atm.Id, atm.Name, atm.Address, atm.Latitude, atm.Longitude,
+			atm.Status, atm.OpenTime, atm.CloseTime, atm.Operations}
+	},
+	fromRecord: func(values csvValues) (interface{}, error) {
+		var err error
+		atm := Atm{
+			Id:         values.int("id", &err),
+			Name:       values.string("name"),
+			Address:    values.string("address"),
+			Latitude:   values.float("latitude", &err),
+			Longitude:  values.float("longitude", &err),
+			Status:     values.string("status"),
+			OpenTime:   values.string("open_time"),
+			CloseTime:  values.string("close_time"),
+			Operations: splitOperations(values.string("operations")),
+		}
+		if err != nil {
+			return nil, err
+		}
+		return normalizeAtm(atm)
+	},
+}
+
+var serviceCSVSpec = csvSpec{
+	fields: []string{"id", "name", "balance", "reference_pattern", "min_amount", "max_amount", "category_id",
+		"description", "icon", "disabled", "position", "settlement_period"},
+	required: []string{"name"},
+	values: func(iface interface{}) []interface{} {
+		service := iface.(Services)
+		return []interface{}{service.Id, service.Name, service.Balance, service.ReferencePattern,
+			service.MinAmount, service.MaxAmount, service.CategoryId, service.Description,
+			service.Icon, service.Disabled, service.Position, service.SettlementPeriod}
+	},
+	fromRecord: func(values csvValues) (interface{}, error) {
+		var err error
+		service := Services{
+			Id:               values.int("id", &err),
+			Name:             values.string("name"),
+			Balance:          values.uint("balance", &err),
+			ReferencePattern: values.string("reference_pattern"),
+			MinAmount:        values.uint("min_amount", &err),
+			MaxAmount:        values.uint("max_amount", &err),
+			CategoryId:       values.int("category_id", &err),
+			Description:      values.string("description"),
+			Icon:             values.string("icon"),
+			Disabled:         values.bool("disabled", &err),
+			Position:         int(values.int("position", &err)),
+			SettlementPeriod: int(values.int("settlement_period", &err)),
+		}
+		if err != nil {
+			return nil, err
+		}
+		return service, checkServiceLimits(service)
+	},
+}
+
+// headerFields maps columns of header to fields, every column has to be known field used once
+func (receiver csvSpec) headerFields(header []string, options CSVOptions) ([]string, error) {
+	fields := make([]string, len(header))
+	used := map[string]bool{}
+	for index, column := range header {
+		field := options.field(column)
+		known := false
+		for _, candidate := range receiver.fields {
+			known = known || candidate == field
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidCSVHeader, column)
+		}
+		if used[field] {
+			return nil, fmt.Errorf("%w: duplicate column %q", ErrInvalidCSVHeader, column)
+		}
+		used[field] = true
+		fields[index] = field
+	}
+	for _, field := range receiver.required {
+		if !used[field] {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidCSVHeader, options.column(field))
+		}
+	}
+	return fields, nil
+}
+
+func (receiver CSVOptions) column(field string) string {
+	if title, ok := receiver.Columns[field]; ok {
+		return title
+	}
+	return field
+}
+
+func (receiver CSVOptions) field(column string) string {
+	column = strings.TrimSpace(column)
+	for field, title := range receiver.Columns {
+		if strings.EqualFold(title, column) {
+			return field
+		}
+	}
+	return strings.ToLower(column)
+}
+
+func (receiver CSVOptions) delimiter() rune {
+	if receiver.Delimiter == 0 {
+		return ','
+	}
+	return receiver.Delimiter
+}
+
+// csvLineReader gives csv.Reader at most one line per Read, so reader never buffers past
+// record it returned and line is where last returned byte is
+type csvLineReader struct {
+	data []byte
+	line int
+	// lineEnded is true when last returned byte is line break
+	lineEnded bool
+}
+
+func (receiver *csvLineReader) Read(p []byte) (n int, err error) {
+	if len(receiver.data) == 0 {
+		return 0, io.EOF
+	}
+	n = bytes.IndexByte(receiver.data, '\n') + 1
+	if n == 0 || n > len(p) {
+		n = len(p)
+		if n > len(receiver.data) {
+			n = len(receiver.data)
+		}
+	}
+	if receiver.line == 0 || receiver.lineEnded {
+		receiver.line++
+	}
+	receiver.lineEnded = receiver.data[n-1] == '\n'
+	copy(p, receiver.data[:n])
+	receiver.data = receiver.data[n:]
+	return n, nil
+}
+
+// startLine is line where row just read starts, line breaks in quoted fields are counted back
+func (receiver *csvLineReader) startLine(row []string) int {
+	line := receiver.line
+	for _, field := range row {
+		line -= strings.Count(field, "\n")
+	}
+	return line
+}
+
+// mapBytesToCSVRecords parses whole file and reports all invalid rows at once,
+// valid records are returned along with CSVImportError
+func mapBytesToCSVRecords(data []byte, spec csvSpec, options CSVOptions) ([]interface{}, error) {
+	data, err := decodeText(data, options.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := &csvLineReader{data: data}
+	reader := csv.NewReader(lines)
+	reader.Comma = options.delimiter()
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, &CSVRowError{Line: 1, Err: err}
+	}
+	fields, err := spec.headerFields(header, options)
+	if err != nil {
+		return nil, &CSVRowError{Line: 1, Err: err}
+	}
+
+	var records []interface{}
+	var rowErrors []*CSVRowError
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, &CSVRowError{Line: parseErr.StartLine, Err: err})
+			continue
+		}
+		if err != nil {
+			return records, err
+		}
+		line := lines.startLine(row)
+		if len(row) != len(fields) {
+			rowErrors = append(rowErrors, &CSVRowError{Line: line,
+				Err: fmt.Errorf("expected %d columns, got %d", len(fields), len(row))})
+			continue
+		}
+
+		values := make(csvValues, len(fields))
+		for index, field := range fields {
+			values[field] = row[index]
+		}
+		value, err := spec.fromRecord(values)
+		if err != nil {
+			rowErrors = append(rowErrors, &CSVRowError{Line: line, Err: err})
+			continue
+		}
+		records = append(records, csvRecord{line: line, value: value})
+	}
+
+	if len(rowErrors) != 0 {
+		return records, &CSVImportError{Rows: rowErrors}
+	}
+	return records, nil
+}
+
+func ExportClientsToCSV(db *sql.DB, options CSVOptions) error {
+	return clientsExchange.exportToPath("clients.csv", FormatCSV, ExportOptions{CSV: options}, db)
+}
+
+func ExportAtmsToCSV(db *sql.DB, options CSVOptions) error {
+	return atmsExchange.exportToPath("atms.csv", FormatCSV, ExportOptions{CSV: options}, db)
+}
+
+func ExportServicesToCSV(db *sql.DB, options CSVOptions) error {
+	return servicesExchange.exportToPath("services.csv", FormatCSV, ExportOptions{CSV: options}, db)
+}
+
+func ImportClientsFromCSV(db *sql.DB, options CSVOptions) error {
+	return clientsExchange.importFromPath("clients.csv", FormatCSV, ImportOptions{CSV: options}, db)
+}
+
+func ImportAtmsFromCSV(db *sql.DB, options CSVOptions) error {
+	return atmsExchange.importFromPath("atms.csv", FormatCSV, ImportOptions{CSV: options}, db)
+}
+
+func ImportServicesFromCSV(db *sql.DB, options CSVOptions) error {
+	return servicesExchange.importFromPath("services.csv", FormatCSV, ImportOptions{CSV: options}, db)
+}
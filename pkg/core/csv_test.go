@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCSV_Windows1251RoundTrip(t *testing.T) {
+	options := CSVOptions{
+		Delimiter: ';',
+		Encoding:  EncodingWindows1251,
+		Columns:   map[string]string{"name": "Название", "address": "Адрес"},
+	}
+	atm := Atm{Id: 1, Name: "Банкомат; центр", Address: "Рудаки 1", Status: AtmOnline, OpenTime: "00:00", CloseTime: "24:00"}
+
+	buffer := &bytes.Buffer{}
+	encoder := newCSVRowEncoder(buffer, atmCSVSpec, options)
+	err := encoder.begin()
+	if err == nil {
+		err = encoder.encode(atm)
+	}
+	if err == nil {
+		err = encoder.end()
+	}
+	if err != nil {
+		t.Fatalf("can't encode csv: %v", err)
+	}
+	data := buffer.Bytes()
+	if !bytes.Contains(data, []byte{0xc0, 0xe4, 0xf0, 0xe5, 0xf1}) { // "Адрес" in windows-1251
+		t.Errorf("header not encoded in windows-1251: %v", data)
+	}
+
+	records, err := mapBytesToCSVRecords(data, atmCSVSpec, options)
+	if err != nil {
+		t.Fatalf("can't parse csv: %v", err)
+	}
+	atm = records[0].(csvRecord).value.(Atm)
+	if atm.Name != "Банкомат; центр" || atm.Address != "Рудаки 1" {
+		t.Errorf("unexpected atm: %+v", atm)
+	}
+}
+
+func TestCSV_RowErrorsWithLines(t *testing.T) {
+	data := []byte("name,login,balance,balance_number,phone_number\n" +
+		"Vasya,vasya,100,1001,992900000001\n" +
+		"Petya,petya,lots,1002,992900000002\n" +
+		"Masha,masha,10\n")
+
+	_, err := mapBytesToCSVRecords(data, clientCSVSpec, CSVOptions{})
+	var typedErr *CSVImportError
+	if ok := errors.As(err, &typedErr); !ok {
+		t.Fatalf("error not match CSVImportError: %v", err)
+	}
+	if len(typedErr.Rows) != 2 || typedErr.Rows[0].Line != 3 || typedErr.Rows[1].Line != 4 {
+		t.Errorf("unexpected row errors: %v", err)
+	}
+}
+
+func TestCSV_RowErrorsCountPhysicalLines(t *testing.T) {
+	data := []byte("name,login,balance,balance_number,phone_number\n" +
+		"\"Vasya\nPupkin\",vasya,100,1001,992900000001\n" +
+		"Petya,petya,lots,1002,992900000002\n" +
+		"Masha,\"ma\"sha\",10,1003,992900000003\n" +
+		"\n" +
+		"Dasha,dasha,much,1004,992900000004")
+
+	records, err := mapBytesToCSVRecords(data, clientCSVSpec, CSVOptions{})
+	var typedErr *CSVImportError
+	if ok := errors.As(err, &typedErr); !ok {
+		t.Fatalf("error not match CSVImportError: %v", err)
+	}
+	if len(typedErr.Rows) != 3 || typedErr.Rows[0].Line != 4 || typedErr.Rows[1].Line != 5 || typedErr.Rows[2].Line != 7 {
+		t.Errorf("unexpected row errors: %v", err)
+	}
+	if len(records) != 1 || records[0].(csvRecord).line != 2 {
+		t.Errorf("unexpected records: %v", records)
+	}
+}
+
+func TestCSV_InvalidHeader(t *testing.T) {
+	headers := []string{
+		"name,login,balance,balance_number,phone_number,email\n",
+		"name,login,login,balance_number,phone_number\n",
+		"name,login,balance\n",
+	}
+	for _, header := range headers {
+		_, err := mapBytesToCSVRecords([]byte(header+"Vasya,vasya,100,1001,992900000001\n"), clientCSVSpec, CSVOptions{})
+		var typedErr *CSVRowError
+		if ok := errors.As(err, &typedErr); !ok || typedErr.Line != 1 || !errors.Is(err, ErrInvalidCSVHeader) {
+			t.Errorf("Not ErrInvalidCSVHeader on line 1 for %q: %v", header, err)
+		}
+	}
+
+	options := CSVOptions{Columns: map[string]string{"phone_number": "Телефон"}}
+	_, err := mapBytesToCSVRecords([]byte("name,login,balance_number\nVasya,vasya,1001\n"), clientCSVSpec, options)
+	if err == nil || !strings.Contains(err.Error(), `"Телефон"`) {
+		t.Errorf("missing column not named by title: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// exportFileMode keeps exported files with logins and balances readable by owner only
+const exportFileMode os.FileMode = 0600
+
+// exchangeEntity describes how one table is exported and imported in every format,
+// root names list of records in JSON and XML and field names record in XML, e.g. clients and client
+type exchangeEntity struct {
+	root         string
+	field        string
+	query        string
+	mapRow       MapperRowTo
+	mapBytes     func([]byte, func([]byte, interface{}) error) ([]interface{}, error)
+	csv          csvSpec
+	importRecord func(interface{}, ImportOptions, *sql.Tx) error
+	// redact removes credentials from records exported without chosen fields
+	redact func(interface{}) interface{}
+}
+
+var clientsExchange = exchangeEntity{
+	root:         "clients",
+	field:        "client",
+	query:        getAllClientsDataSQL,
+	mapRow:       mapRowToClient,
+	mapBytes:     mapBytesToClients,
+	csv:          clientCSVSpec,
+	importRecord: importClient,
+	redact: func(item interface{}) interface{} {
+		client := item.(Client)
+		client.Password = ""
+		return client
+	},
+}
+
+var atmsExchange = exchangeEntity{
+	root:         "atms",
+	field:        "atm",
+	query:        getAllAtmDataSQL,
+	mapRow:       mapRowToAtm,
+	mapBytes:     mapBytesToAtms,
+	csv:          atmCSVSpec,
+	importRecord: importAtm,
+}
+
+var servicesExchange = exchangeEntity{
+	root:         "services",
+	field:        "service",
+	query:        getAllServices,
+	mapRow:       mapRowToService,
+	mapBytes:     mapBytesToServices,
+	csv:          serviceCSVSpec,
+	importRecord: importService,
+}
+
+// ExportOptions configures exports, nil Profile exports all fields
+type ExportOptions struct {
+	Profile  *ExportProfile
+	CSV      CSVOptions
+	Seal     SealOptions
+	Progress ExportProgress
+}
+
+func (receiver exchangeEntity) export(w io.Writer, format string, options ExportOptions, db *sql.DB) error {
+	if options.Seal.empty() {
+		return receiver.stream(w, format, options, db)
+	}
+
+	writer, err := newSealWriter(w, options.Seal)
+	if err != nil {
+		return err
+	}
+	err = receiver.stream(writer, format, options, db)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func (receiver exchangeEntity) exportToPath(path string, format string, options ExportOptions, db *sql.DB) error {
+	return writeFileAtomic(path, exportFileMode, func(w io.Writer) error {
+		return receiver.export(w, format, options, db)
+	})
+}
+
+func (receiver exchangeEntity) importFrom(r io.Reader, format string, options ImportOptions, db *sql.DB) error {
+	report, err := receiver.importWithOptions(r, format, options, db)
+	if err != nil {
+		return err
+	}
+	return report.err()
+}
+
+func (receiver exchangeEntity) importFromPath(path string, format string, options ImportOptions, db *sql.DB) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return receiver.importFrom(file, format, options, db)
+}
+
+// writeFileAtomic writes file next to target and renames it, readers never see partial file
+func writeFileAtomic(filename string, mode os.FileMode, write func(w io.Writer) error) (err error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := ioutil.TempFile(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	err = write(file)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	err = file.Chmod(mode)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// ExportClients writes all clients to w in FormatJSON, FormatXML or FormatCSV
+func ExportClients(w io.Writer, format string, db *sql.DB) error {
+	return clientsExchange.export(w, format, ExportOptions{}, db)
+}
+
+func ExportAtms(w io.Writer, format string, db *sql.DB) error {
+	return atmsExchange.export(w, format, ExportOptions{}, db)
+}
+
+func ExportServices(w io.Writer, format string, db *sql.DB) error {
+	return servicesExchange.export(w, format, ExportOptions{}, db)
+}
+
+// ExportClientsWithOptions applies profile, CSV options and sealing to ExportClients
+func ExportClientsWithOptions(w io.Writer, format string, options ExportOptions, db *sql.DB) error {
+	return clientsExchange.export(w, format, options, db)
+}
+
+func ExportAtmsWithOptions(w io.Writer, format string, options ExportOptions, db *sql.DB) error {
+	return atmsExchange.export(w, format, options, db)
+}
+
+func ExportServicesWithOptions(w io.Writer, format string, options ExportOptions, db *sql.DB) error {
+	return servicesExchange.export(w, format, options, db)
+}
+
+// ImportClients reads clients written by ExportClients in the same format. Exports have no passwords,
+// so matched clients keep their passwords and new clients can't log in until password is set by UpdateClient
+func ImportClients(r io.Reader, format string, db *sql.DB) error {
+	return clientsExchange.importFrom(r, format, ImportOptions{}, db)
+}
+
+func ImportAtms(r io.Reader, format string, db *sql.DB) error {
+	return atmsExchange.importFrom(r, format, ImportOptions{}, db)
+}
+
+func ImportServices(r io.Reader, format string, db *sql.DB) error {
+	return servicesExchange.importFrom(r, format, ImportOptions{}, db)
+}
+
+// ExportClientsToPath replaces file at path atomically, file is readable by owner only
+func ExportClientsToPath(path string, format string, db *sql.DB) error {
+	return clientsExchange.exportToPath(path, format, ExportOptions{}, db)
+}
+
+func ExportAtmsToPath(path string, format string, db *sql.DB) error {
+	return atmsExchange.exportToPath(path, format, ExportOptions{}, db)
+}
+
+func ExportServicesToPath(path string, format string, db *sql.DB) error {
+	return servicesExchange.exportToPath(path, format, ExportOptions{}, db)
+}
+
+// ExportClientsToPathWithOptions applies profile, CSV options and sealing to ExportClientsToPath
+func ExportClientsToPathWithOptions(path string, format string, options ExportOptions, db *sql.DB) error {
+	return clientsExchange.exportToPath(path, format, options, db)
+}
+
+func ExportAtmsToPathWithOptions(path string, format string, options ExportOptions, db *sql.DB) error {
+	return atmsExchange.exportToPath(path, format, options, db)
+}
+
+func ExportServicesToPathWithOptions(path string, format string, options ExportOptions, db *sql.DB) error {
+	return servicesExchange.exportToPath(path, format, options, db)
+}
+
+func ImportClientsFromPath(path string, format string, db *sql.DB) error {
+	return clientsExchange.importFromPath(path, format, ImportOptions{}, db)
+}
+
+func ImportAtmsFromPath(path string, format string, db *sql.DB) error {
+	return atmsExchange.importFromPath(path, format, ImportOptions{}, db)
+}
+
+func ImportServicesFromPath(path string, format string, db *sql.DB) error {
+	return servicesExchange.importFromPath(path, format, ImportOptions{}, db)
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestExportClients_RoundTrip(t *testing.T) {
+	source, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(source)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, source)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	for _, format := range []string{FormatJSON, FormatXML, FormatCSV} {
+		buffer := &bytes.Buffer{}
+		err = ExportClients(buffer, format, source)
+		if err != nil {
+			t.Fatalf("can't export %s: %v", format, err)
+		}
+		if bytes.Contains(buffer.Bytes(), []byte("secret")) {
+			t.Errorf("password exported to %s: %s", format, buffer)
+		}
+
+		target, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("can't open db: %v", err)
+		}
+		err = Init(target)
+		if err != nil {
+			t.Fatalf("can't init db: %v", err)
+		}
+		err = ImportClients(bytes.NewReader(buffer.Bytes()), format, target)
+		if err != nil {
+			t.Errorf("can't import %s: %v", format, err)
+		}
+		profile, err := GetClientProfile(1, target)
+		if err != nil || profile.Login != "vasya" || profile.Balance != 100 {
+			t.Errorf("client not imported from %s: %+v %v", format, profile, err)
+		}
+		_, ok, err := Login("vasya", "", target)
+		if err != nil || ok {
+			t.Errorf("new client without password logged in from %s: %v %v", format, ok, err)
+		}
+		_ = target.Close()
+
+		report, err := ImportClientsWithOptions(bytes.NewReader(buffer.Bytes()), format, ImportOptions{Conflict: ConflictOverwrite}, source)
+		if err != nil || report.Accepted != 1 {
+			t.Errorf("can't import %s over source: %+v %v", format, report, err)
+		}
+		id, ok, err := Login("vasya", "secret", source)
+		if err != nil || !ok || id != 1 {
+			t.Errorf("password lost by import from %s: %v %v %v", format, id, ok, err)
+		}
+	}
+
+	err = ExportClients(&bytes.Buffer{}, "yaml", source)
+	if err != ErrUnknownFormat {
+		t.Errorf("Not ErrUnknownFormat for yaml: %v", err)
+	}
+}
+
+func TestExportAtmsToPath_ReplacesFile(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddAtm(Atm{Name: "Center", Address: "Rudaki 1"}, db)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatalf("can't create dir: %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "atms.json")
+	err = ioutil.WriteFile(path, []byte("stale"), 0666)
+	if err != nil {
+		t.Fatalf("can't write file: %v", err)
+	}
+
+	err = ExportAtmsToPath(path, FormatJSON, db)
+	if err != nil {
+		t.Fatalf("can't export atms: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("can't stat export: %v", err)
+	}
+	if info.Mode().Perm() != exportFileMode {
+		t.Errorf("unexpected mode: %v", info.Mode())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("temporary file left: %v %v", files, err)
+	}
+
+	err = ExportAtmsToPath(filepath.Join(dir, "atms.yaml"), "yaml", db)
+	if err != ErrUnknownFormat {
+		t.Errorf("Not ErrUnknownFormat for yaml: %v", err)
+	}
+	files, err = ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("temporary file left after failure: %v %v", files, err)
+	}
+
+	err = ImportAtmsFromPath(path, FormatJSON, db)
+	var typedErr *ImportError
+	if ok := errors.As(err, &typedErr); !ok {
+		t.Errorf("error not match ImportError for existing atm: %v", err)
+	}
+	atms, err := GetAllAtms(db)
+	if err != nil || len(atms) != 1 {
+		t.Errorf("unexpected atms: %v %v", atms, err)
+	}
+}
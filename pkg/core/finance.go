@@ -0,0 +1,318 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ofxBankId = "MANAGERSCORE"
+const ofxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+`
+const ofxSGMLHeader = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+SECURITY:NONE
+ENCODING:UNICODE
+CHARSET:NONE
+COMPRESSION:NONE
+OLDFILEUID:NONE
+NEWFILEUID:NONE
+
+`
+
+// lineBreaks are replaced in values of line based formats, QIF field and SGML value end at line end
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// TransactionsExport is account history as seen from BalanceNumber, Balance is current balance
+type TransactionsExport struct {
+	BalanceNumber uint64
+	Balance       uint64
+	Transactions  []Transaction
+}
+
+// ExportTransactionsToOFX writes account history as OFX 2.1 (XML flavour) file
+func ExportTransactionsToOFX(balanceNumber uint64, filename string, db *sql.DB) error {
+	return exportAccountTransactions(balanceNumber, filename, marshalOFX, nil, db)
+}
+
+// ExportTransactionsToOFXWithProfile masks counterparties and references as profile Statements tell
+func ExportTransactionsToOFXWithProfile(balanceNumber uint64, filename string, profile *ExportProfile, db *sql.DB) error {
+	return exportAccountTransactions(balanceNumber, filename, marshalOFX, profile, db)
+}
+
+// ExportTransactionsToOFXSGML writes account history as OFX 1.0.2 (SGML flavour) file for older finance tools
+func ExportTransactionsToOFXSGML(balanceNumber uint64, filename string, db *sql.DB) error {
+	return exportAccountTransactions(balanceNumber, filename, marshalOFXSGML, nil, db)
+}
+
+func ExportTransactionsToOFXSGMLWithProfile(balanceNumber uint64, filename string, profile *ExportProfile, db *sql.DB) error {
+	return exportAccountTransactions(balanceNumber, filename, marshalOFXSGML, profile, db)
+}
+
+// ExportTransactionsToQIF writes account history as QIF bank file
+func ExportTransactionsToQIF(balanceNumber uint64, filename string, db *sql.DB) error {
+	return exportAccountTransactions(balanceNumber, filename, marshalQIF, nil, db)
+}
+
+func ExportTransactionsToQIFWithProfile(balanceNumber uint64, filename string, profile *ExportProfile, db *sql.DB) error {
+	return exportAccountTransactions(balanceNumber, filename, marshalQIF, profile, db)
+}
+
+func exportAccountTransactions(balanceNumber uint64, filename string, marshal Marshaller, profile *ExportProfile, db *sql.DB) error {
+	mapRow := mapRowToTransaction
+	if profile != nil {
+		err := profile.checkStatementFields()
+		if err != nil {
+			return err
+		}
+		mapRow = func(rows *sql.Rows) (interface{}, error) {
+			item, err := mapRowToTransaction(rows)
+			if err != nil {
+				return nil, err
+			}
+			return maskTransaction(balanceNumber, item.(Transaction), *profile)
+		}
+	}
+
+	var owner string
+	var balance uint64
+	err := db.QueryRow(getStatementAccountSQL, balanceNumber).Scan(&owner, &balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return queryError(getStatementAccountSQL, err)
+	}
+
+	return exportQueryToFile(db, filename, mapRow, marshal,
+		func(ifaces []interface{}) interface{} {
+			return mapInterfaceSliceToTransactions(balanceNumber, balance, ifaces)
+		},
+		getAccountTransactionsSQL, balanceNumber, balanceNumber)
+}
+
+func mapRowToTransaction(rows *sql.Rows) (interface{}, error) {
+	transaction, err := scanTransaction(rows)
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// maskTransaction masks reference and removes number of other account when profile redacts counterparty
+func maskTransaction(balanceNumber uint64, transaction Transaction, profile ExportProfile) (Transaction, error) {
+	var err error
+	transaction.Reference, err = profile.maskString(transaction.Reference, "reference")
+	if err != nil {
+		return Transaction{}, err
+	}
+	if profile.statementMask("counterparty") == MaskRedact {
+		if transaction.PayerBalanceNumber != balanceNumber {
+			transaction.PayerBalanceNumber = 0
+		}
+		if transaction.PayeeBalanceNumber != balanceNumber {
+			transaction.PayeeBalanceNumber = 0
+		}
+	}
+	return transaction, nil
+}
+
+func mapInterfaceSliceToTransactions(balanceNumber uint64, balance uint64, ifaces []interface{}) interface{} {
+	transactions := make([]Transaction, len(ifaces))
+	for i := range ifaces {
+		transactions[i] = ifaces[i].(Transaction)
+	}
+	return TransactionsExport{BalanceNumber: balanceNumber, Balance: balance, Transactions: transactions}
+}
+
+// signedAmount is transaction amount from account point of view, negative for debit
+func (receiver TransactionsExport) signedAmount(transaction Transaction) int64 {
+	var amount int64
+	if transaction.PayerBalanceNumber == receiver.BalanceNumber {
+		amount -= int64(transaction.Amount)
+	}
+	if transaction.PayeeBalanceNumber == receiver.BalanceNumber {
+		amount += int64(transaction.Amount)
+	}
+	return amount
+}
+
+func (receiver TransactionsExport) payee(transaction Transaction) string {
+	switch transaction.Kind {
+	case TransactionPayment:
+		return fmt.Sprintf("Service %d", transaction.ServiceId)
+	case TransactionTopUp:
+		return "Top up"
+	}
+	if transaction.PayerBalanceNumber == receiver.BalanceNumber {
+		if transaction.PayeeBalanceNumber == 0 {
+			return "Transfer out"
+		}
+		return fmt.Sprintf("Transfer to %d", transaction.PayeeBalanceNumber)
+	}
+	if transaction.PayerBalanceNumber == 0 {
+		return "Transfer in"
+	}
+	return fmt.Sprintf("Transfer from %d", transaction.PayerBalanceNumber)
+}
+
+// fitId is stable per transaction so finance tools skip already imported entries
+func fitId(transaction Transaction) string {
+	return "T" + strconv.FormatInt(transaction.Id, 10)
+}
+
+func ofxTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("20060102150405") + "[0:GMT]"
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxTransaction struct {
+	Type   string `xml:"TRNTYPE"`
+	Posted string `xml:"DTPOSTED"`
+	Amount string `xml:"TRNAMT"`
+	FitId  string `xml:"FITID"`
+	Name   string `xml:"NAME"`
+	Memo   string `xml:"MEMO,omitempty"`
+}
+
+type ofxDocument struct {
+	XMLName      xml.Name         `xml:"OFX"`
+	SignOnStatus ofxStatus        `xml:"SIGNONMSGSRSV1>SONRS>STATUS"`
+	ServerTime   string           `xml:"SIGNONMSGSRSV1>SONRS>DTSERVER"`
+	Language     string           `xml:"SIGNONMSGSRSV1>SONRS>LANGUAGE"`
+	TrnUid       string           `xml:"BANKMSGSRSV1>STMTTRNRS>TRNUID"`
+	Status       ofxStatus        `xml:"BANKMSGSRSV1>STMTTRNRS>STATUS"`
+	Currency     string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>CURDEF"`
+	BankId       string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>BANKID"`
+	AccountId    string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>ACCTID"`
+	AccountType  string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>ACCTTYPE"`
+	Start        string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>DTSTART"`
+	End          string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>DTEND"`
+	Transactions []ofxTransaction `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>STMTTRN"`
+	Balance      string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>LEDGERBAL>BALAMT"`
+	BalanceAsOf  string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>LEDGERBAL>DTASOF"`
+}
+
+func newOFXDocument(export TransactionsExport) ofxDocument {
+	now := time.Now().Unix()
+	document := ofxDocument{
+		SignOnStatus: ofxStatus{Code: 0, Severity: "INFO"},
+		ServerTime:   ofxTime(now),
+		Language:     "ENG",
+		TrnUid:       strconv.FormatInt(now, 10),
+		Status:       ofxStatus{Code: 0, Severity: "INFO"},
+		Currency:     Currency,
+		BankId:       ofxBankId,
+		AccountId:    strconv.FormatUint(export.BalanceNumber, 10),
+		AccountType:  "CHECKING",
+		Start:        ofxTime(now),
+		End:          ofxTime(now),
+		Balance:      formatMinorUnits(int64(export.Balance)),
+		BalanceAsOf:  ofxTime(now),
+	}
+	if len(export.Transactions) != 0 {
+		document.Start = ofxTime(export.Transactions[0].CreatedAt)
+	}
+
+	for _, transaction := range export.Transactions {
+		amount := export.signedAmount(transaction)
+		transactionType := "CREDIT"
+		if amount < 0 {
+			transactionType = "DEBIT"
+		}
+		if transaction.Kind == TransactionPayment {
+			transactionType = "PAYMENT"
+		}
+		document.Transactions = append(document.Transactions, ofxTransaction{
+			Type:   transactionType,
+			Posted: ofxTime(transaction.CreatedAt),
+			Amount: formatMinorUnits(amount),
+			FitId:  fitId(transaction),
+			Name:   export.payee(transaction),
+			Memo:   transaction.Reference,
+		})
+	}
+
+	return document
+}
+
+func marshalOFX(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(newOFXDocument(v.(TransactionsExport)), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(ofxHeader), data...), nil
+}
+
+// marshalOFXSGML writes the same document in OFX 1.x way: elements with values have no end tags
+// and empty ones are left out
+func marshalOFXSGML(v interface{}) ([]byte, error) {
+	data, err := xml.Marshal(newOFXDocument(v.(TransactionsExport)))
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bytes.NewBufferString(ofxSGMLHeader)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	opened, value := "", ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if opened != "" {
+				fmt.Fprintf(buffer, "<%s>\n", opened)
+			}
+			opened, value = token.Name.Local, ""
+		case xml.CharData:
+			value += string(token)
+		case xml.EndElement:
+			switch {
+			case opened == "":
+				fmt.Fprintf(buffer, "</%s>\n", token.Name.Local)
+			case value != "":
+				fmt.Fprintf(buffer, "<%s>", opened)
+				err = xml.EscapeText(buffer, []byte(lineBreaks.Replace(value)))
+				if err != nil {
+					return nil, err
+				}
+				buffer.WriteString("\n")
+			}
+			opened, value = "", ""
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+func marshalQIF(v interface{}) ([]byte, error) {
+	export := v.(TransactionsExport)
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("!Type:Bank\n")
+	for _, transaction := range export.Transactions {
+		fmt.Fprintf(buffer, "D%s\n", time.Unix(transaction.CreatedAt, 0).UTC().Format("01/02/2006"))
+		fmt.Fprintf(buffer, "T%s\n", formatMinorUnits(export.signedAmount(transaction)))
+		fmt.Fprintf(buffer, "N%s\n", fitId(transaction))
+		fmt.Fprintf(buffer, "P%s\n", lineBreaks.Replace(export.payee(transaction)))
+		if transaction.Reference != "" {
+			fmt.Fprintf(buffer, "M%s\n", lineBreaks.Replace(transaction.Reference))
+		}
+		buffer.WriteString("^\n")
+	}
+	return buffer.Bytes(), nil
+}
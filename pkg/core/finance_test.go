@@ -0,0 +1,124 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openFinanceDB(t *testing.T) *sql.DB {
+	db := openInitDB(t)
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 1000, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddClients(Client{Name: "Petya", Login: "petya", Password: "secret", BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddServices(Services{Name: "Tcell"}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+	_, err = TransferByBalanceNumber(1001, 250, Client{BalanceNumber: 1002, Balance: 250}, db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+	_, err = PayForServices(1001, 100, "9001\r\n!Type:Cat & <x>", Services{Id: 1}, db)
+	if err != nil {
+		t.Fatalf("can't pay: %v", err)
+	}
+	return db
+}
+
+func readFinanceExport(t *testing.T, export func(uint64, string, *sql.DB) error, db *sql.DB) string {
+	dir, err := ioutil.TempDir("", "finance")
+	if err != nil {
+		t.Fatalf("can't create dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("can't remove dir: %v", err)
+		}
+	}()
+
+	filename := filepath.Join(dir, "export")
+	err = export(1001, filename, db)
+	if err != nil {
+		t.Fatalf("can't export: %v", err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("can't read export: %v", err)
+	}
+	return string(data)
+}
+
+func TestExportTransactionsToOFX(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	data := readFinanceExport(t, ExportTransactionsToOFX, db)
+	document := ofxDocument{}
+	err := xml.Unmarshal([]byte(data), &document)
+	if err != nil {
+		t.Fatalf("invalid ofx: %v", err)
+	}
+	if document.Balance != "6.50" || len(document.Transactions) != 2 {
+		t.Fatalf("unexpected ofx: %s", data)
+	}
+	if document.Transactions[0].Amount != "-2.50" || document.Transactions[1].Type != "PAYMENT" || document.Transactions[1].FitId != "T2" {
+		t.Errorf("unexpected ofx transactions: %v", document.Transactions)
+	}
+}
+
+func TestExportTransactionsToOFXSGML(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	data := readFinanceExport(t, ExportTransactionsToOFXSGML, db)
+	if !strings.HasPrefix(data, "OFXHEADER:100\nDATA:OFXSGML\nVERSION:102\n") {
+		t.Errorf("unexpected sgml header: %s", data)
+	}
+	body := data[strings.Index(data, "\n\n")+2:]
+	for _, line := range []string{"<OFX>", "<TRNAMT>-2.50", "<FITID>T2", "<MEMO>9001 !Type:Cat &amp; &lt;x&gt;", "</STMTTRN>", "</OFX>"} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("no %q in sgml: %s", line, body)
+		}
+	}
+	if strings.Contains(body, "</TRNAMT>") || strings.Contains(body, "<?xml") {
+		t.Errorf("sgml has xml syntax: %s", body)
+	}
+}
+
+func TestExportTransactionsToQIF_StripsLineBreaks(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	data := readFinanceExport(t, ExportTransactionsToQIF, db)
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if len(lines) != 12 || lines[0] != "!Type:Bank" || lines[2] != "T-2.50" {
+		t.Fatalf("unexpected qif: %q", data)
+	}
+	if lines[10] != "M9001 !Type:Cat & <x>" {
+		t.Errorf("memo not on one line: %q", lines[10])
+	}
+}
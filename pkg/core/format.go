@@ -0,0 +1,145 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExchangeFormatVersion is written to JSON and XML exports, struct tags of exported types
+// are names of this format so they change only together with version:
+//
+//	{"version":1,"clients":[{"id":1,"name":"Vasya","login":"vasya",...}]}
+//	<clients version="1"><client><id>1</id><name>Vasya</name>...</client></clients>
+//
+// Files without version are legacy exports with Go field names and password of clients.
+const ExchangeFormatVersion = 1
+
+const legacyFormatVersion = 0
+
+var ErrFormatVersion = errors.New("unsupported format version")
+
+// exchangeVersion reads only version attribute of file, any root element is accepted
+func exchangeVersion(data []byte, unmarshal func([]byte, interface{}) error) (int, error) {
+	probe := struct {
+		Version int `json:"version" xml:"version,attr"`
+	}{}
+	err := unmarshal(data, &probe)
+	if err != nil {
+		return 0, err
+	}
+	if probe.Version < legacyFormatVersion || probe.Version > ExchangeFormatVersion {
+		return 0, fmt.Errorf("%w: %d", ErrFormatVersion, probe.Version)
+	}
+	return probe.Version, nil
+}
+
+// legacy types have same fields as exported types without tags, so they convert to each other
+
+type legacyClient struct {
+	Id            int64
+	Name          string
+	Login         string
+	Password      string
+	Balance       uint64
+	BalanceNumber uint64
+	PhoneNumber   int64
+	Version       int64
+}
+
+type legacyAtm struct {
+	Id         int64
+	Name       string
+	Address    string
+	Latitude   float64
+	Longitude  float64
+	Status     string
+	OpenTime   string
+	CloseTime  string
+	Operations []string
+	Version    int64
+}
+
+type legacyService struct {
+	Id               int64
+	Name             string
+	Balance          uint64
+	ReferencePattern string
+	MinAmount        uint64
+	MaxAmount        uint64
+	CategoryId       int64
+	Description      string
+	Icon             string
+	Disabled         bool
+	Position         int
+	SettlementPeriod int
+	Version          int64
+}
+
+func unmarshalClients(data []byte, unmarshal func([]byte, interface{}) error) (ClientsExport, error) {
+	version, err := exchangeVersion(data, unmarshal)
+	if err != nil {
+		return ClientsExport{}, err
+	}
+	export := ClientsExport{}
+	if version != legacyFormatVersion {
+		err = unmarshal(data, &export)
+		return export, err
+	}
+
+	legacy := struct{ Clients []legacyClient }{}
+	err = unmarshal(data, &legacy)
+	if err != nil {
+		return ClientsExport{}, err
+	}
+	export.Clients = make([]Client, len(legacy.Clients))
+	for index, client := range legacy.Clients {
+		export.Clients[index] = Client(client)
+	}
+	return export, nil
+}
+
+func unmarshalAtms(data []byte, unmarshal func([]byte, interface{}) error) (AtmsExport, error) {
+	version, err := exchangeVersion(data, unmarshal)
+	if err != nil {
+		return AtmsExport{}, err
+	}
+	export := AtmsExport{}
+	if version != legacyFormatVersion {
+		err = unmarshal(data, &export)
+		return export, err
+	}
+
+	legacy := struct{ Atms []legacyAtm }{}
+	err = unmarshal(data, &legacy)
+	if err != nil {
+		return AtmsExport{}, err
+	}
+	export.Atms = make([]Atm, len(legacy.Atms))
+	for index, atm := range legacy.Atms {
+		export.Atms[index] = Atm(atm)
+	}
+	return export, nil
+}
+
+func unmarshalServices(data []byte, unmarshal func([]byte, interface{}) error) (ServicesExport, error) {
+	version, err := exchangeVersion(data, unmarshal)
+	if err != nil {
+		return ServicesExport{}, err
+	}
+	export := ServicesExport{}
+	if version != legacyFormatVersion {
+		err = unmarshal(data, &export)
+		return export, err
+	}
+
+	legacy := struct{ Services []legacyService }{}
+	err = unmarshal(data, &legacy)
+	if err != nil {
+		return ServicesExport{}, err
+	}
+	export.Services = make([]Services, len(legacy.Services))
+	for index, service := range legacy.Services {
+		export.Services[index] = Services(service)
+	}
+	return export, nil
+}
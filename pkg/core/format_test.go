@@ -0,0 +1,55 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestImportAtms_LegacyAndVersionedFormats(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	files := map[string]string{
+		FormatJSON: `{"Atms":[{"Name":"Legacy JSON","Address":"Rudaki 1","Operations":["cash"]}]}`,
+		FormatXML: `<AtmsExport><Atms><Name>Legacy XML</Name><Address>Rudaki 2</Address>` +
+			`<Operations>cash</Operations><Operations>deposit</Operations></Atms></AtmsExport>`,
+	}
+	for format, file := range files {
+		err := ImportAtms(strings.NewReader(file), format, db)
+		if err != nil {
+			t.Errorf("can't import legacy %s: %v", format, err)
+		}
+	}
+	files = map[string]string{
+		FormatJSON: `{"version":1,"atms":[{"name":"Versioned JSON","address":"Rudaki 3","operations":["cash"]}]}`,
+		FormatXML: `<atms version="1"><atm><name>Versioned XML</name><address>Rudaki 4</address>` +
+			`<operations><operation>cash</operation><operation>deposit</operation></operations></atm></atms>`,
+	}
+	for format, file := range files {
+		err := ImportAtms(strings.NewReader(file), format, db)
+		if err != nil {
+			t.Errorf("can't import versioned %s: %v", format, err)
+		}
+	}
+	atms, err := GetAllAtms(db)
+	if err != nil || len(atms) != 4 {
+		t.Fatalf("unexpected atms: %v %v", atms, err)
+	}
+	for _, atm := range atms {
+		if atm.Address == "" || len(atm.Operations) == 0 {
+			t.Errorf("fields of %s not imported: %v", atm.Name, atm)
+		}
+	}
+
+	err = ImportAtms(strings.NewReader(`{"version":2,"atms":[]}`), FormatJSON, db)
+	if !errors.Is(err, ErrFormatVersion) {
+		t.Errorf("Not ErrFormatVersion for version 2: %v", err)
+	}
+}
@@ -0,0 +1,639 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	ImportAccepted = "accepted"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+const (
+	ConflictFail      = "fail"
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictMerge     = "merge"
+)
+
+const (
+	MatchByNaturalKey = "natural_key"
+	MatchById         = "id"
+)
+
+var ErrEmptyField = errors.New("required field is empty")
+var ErrInvalidImportOptions = errors.New("invalid import options")
+
+var errRecordSkipped = errors.New("record already exists")
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// ImportOptions configures imports, zero BatchSize imports whole file in one transaction.
+// Records are matched to existing rows (removed ones too) by MatchBy, natural key is login for clients,
+// name and address for atms and name for services. Conflict tells what to do with matched record,
+// default is ConflictFail. Ids of new records are preserved unless already taken by other row.
+// Updated records keep balance of matched row, balances change only with transactions.
+// Seal opens files written by sealed exports and rejects tampered ones.
+type ImportOptions struct {
+	BatchSize int
+	DryRun    bool
+	CSV       CSVOptions
+	Conflict  string
+	MatchBy   string
+	Seal      SealOptions
+}
+
+// ImportRecord is outcome of one record, Index is record number in file or line for CSV
+type ImportRecord struct {
+	Index  int
+	Status string
+	Reason string
+}
+
+// ImportReport lists every record of file, batch with failed record is rolled back
+// and its other records are skipped
+type ImportReport struct {
+	Accepted int
+	Skipped  int
+	Failed   int
+	Records  []ImportRecord
+}
+
+// ImportError is returned by imports without report when some records failed
+type ImportError struct {
+	Records []ImportRecord
+}
+
+func (receiver *ImportError) Error() string {
+	messages := make([]string, len(receiver.Records))
+	for index, record := range receiver.Records {
+		messages[index] = fmt.Sprintf("record %d: %s", record.Index, record.Reason)
+	}
+	return fmt.Sprintf("%d records failed: %s", len(receiver.Records), strings.Join(messages, "; "))
+}
+
+func (receiver *ImportReport) add(record ImportRecord) {
+	switch record.Status {
+	case ImportAccepted:
+		receiver.Accepted++
+	case ImportSkipped:
+		receiver.Skipped++
+	case ImportFailed:
+		receiver.Failed++
+	}
+	receiver.Records = append(receiver.Records, record)
+}
+
+func (receiver ImportReport) err() error {
+	if receiver.Failed == 0 {
+		return nil
+	}
+	failed := make([]ImportRecord, 0, receiver.Failed)
+	for _, record := range receiver.Records {
+		if record.Status == ImportFailed {
+			failed = append(failed, record)
+		}
+	}
+	return &ImportError{Records: failed}
+}
+
+// importItem is decoded record, err is set when record can't be parsed
+type importItem struct {
+	index int
+	value interface{}
+	err   error
+}
+
+func requireField(field string, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("%s: %w", field, ErrEmptyField)
+	}
+	return nil
+}
+
+func (receiver ImportOptions) check() error {
+	switch receiver.Conflict {
+	case "", ConflictFail, ConflictSkip, ConflictOverwrite, ConflictMerge:
+	default:
+		return ErrInvalidImportOptions
+	}
+	switch receiver.MatchBy {
+	case "", MatchByNaturalKey, MatchById:
+	default:
+		return ErrInvalidImportOptions
+	}
+	return nil
+}
+
+// resolveConflict returns nil when matched row has to be updated from record
+func (receiver ImportOptions) resolveConflict(field string, value interface{}) error {
+	switch receiver.Conflict {
+	case ConflictOverwrite, ConflictMerge:
+		return nil
+	case ConflictSkip:
+		return errRecordSkipped
+	}
+	return &ConflictError{Field: field, Value: value}
+}
+
+func nullableId(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func scanExportedClient(row rowScanner) (client Client, err error) {
+	err = row.Scan(&client.Id, &client.Login, &client.Password,
+		&client.Name, &client.PhoneNumber, &client.Balance, &client.BalanceNumber, &client.Version)
+	if err != nil {
+		return Client{}, err
+	}
+	return client, nil
+}
+
+// freeId returns id when no row of table has it yet, otherwise 0 so inserted row gets a new one
+func freeId(query string, id int64, tx *sql.Tx) (int64, error) {
+	if id == 0 {
+		return 0, nil
+	}
+	var existing int64
+	err := tx.QueryRow(query, id).Scan(&existing)
+	if err == sql.ErrNoRows {
+		return id, nil
+	}
+	if err != nil {
+		return 0, queryError(query, err)
+	}
+	return 0, nil
+}
+
+// findExisting scans row found by query, found is false when there is no such row
+func findExisting(query string, scan func(rowScanner) error, tx *sql.Tx, args ...interface{}) (found bool, err error) {
+	err = scan(tx.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, queryError(query, err)
+	}
+	return true, nil
+}
+
+// importClient validates client against rows already in transaction, then inserts or updates it.
+// New client without password can't log in until password is set by UpdateClient.
+func importClient(iface interface{}, options ImportOptions, tx *sql.Tx) (err error) {
+	client := iface.(Client)
+	var existing Client
+	scan := func(row rowScanner) (err error) {
+		existing, err = scanExportedClient(row)
+		return err
+	}
+	found, field, value := false, "login", interface{}(client.Login)
+	if options.MatchBy == MatchById {
+		field, value = "id", client.Id
+		if client.Id != 0 {
+			found, err = findExisting(findClientByIdSQL, scan, tx, client.Id)
+		}
+	} else {
+		client.Id, err = freeId(checkClientIdSQL, client.Id, tx)
+		if err != nil {
+			return err
+		}
+		found, err = findExisting(findClientByLoginSQL, scan, tx, client.Login)
+	}
+	if err != nil {
+		return err
+	}
+	if found {
+		err = options.resolveConflict(field, value)
+		if err != nil {
+			return err
+		}
+		if options.Conflict == ConflictMerge {
+			client = mergeClient(existing, client)
+		}
+		client.Id = existing.Id
+		client.Balance = existing.Balance
+		// exports have no passwords, matched client keeps its own
+		if client.Password == "" {
+			client.Password = existing.Password
+		}
+	}
+
+	for _, field := range [][2]string{{"name", client.Name}, {"login", client.Login}} {
+		err = requireField(field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
+	err = checkClientUnique(client, tx)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return insertClient(client, tx)
+	}
+	_, err = tx.Exec(
+		importUpdateClientSQL,
+		sql.Named("id", client.Id),
+		sql.Named("name", client.Name),
+		sql.Named("login", client.Login),
+		sql.Named("password", client.Password),
+		sql.Named("balance_number", client.BalanceNumber),
+		sql.Named("phone_number", client.PhoneNumber),
+	)
+	if err != nil {
+		return queryError(importUpdateClientSQL, err)
+	}
+	return nil
+}
+
+// mergeClient takes non-empty fields of record over existing client
+func mergeClient(existing Client, record Client) Client {
+	if record.Name != "" {
+		existing.Name = record.Name
+	}
+	if record.Login != "" {
+		existing.Login = record.Login
+	}
+	if record.Password != "" {
+		existing.Password = record.Password
+	}
+	if record.BalanceNumber != 0 {
+		existing.BalanceNumber = record.BalanceNumber
+	}
+	if record.PhoneNumber != 0 {
+		existing.PhoneNumber = record.PhoneNumber
+	}
+	return existing
+}
+
+func importAtm(iface interface{}, options ImportOptions, tx *sql.Tx) (err error) {
+	atm := iface.(Atm)
+	var existing Atm
+	scan := func(row rowScanner) (err error) {
+		existing, err = scanAtm(row)
+		return err
+	}
+	found, field, value := false, "address", interface{}(atm.Name+", "+atm.Address)
+	if options.MatchBy == MatchById {
+		field, value = "id", atm.Id
+		if atm.Id != 0 {
+			found, err = findExisting(findAtmByIdSQL, scan, tx, atm.Id)
+		}
+	} else {
+		atm.Id, err = freeId(checkAtmIdSQL, atm.Id, tx)
+		if err != nil {
+			return err
+		}
+		found, err = findExisting(findAtmByAddressSQL, scan, tx, atm.Name, atm.Address)
+	}
+	if err != nil {
+		return err
+	}
+	if found {
+		err = options.resolveConflict(field, value)
+		if err != nil {
+			return err
+		}
+		if options.Conflict == ConflictMerge {
+			atm = mergeAtm(existing, atm)
+		}
+		atm.Id = existing.Id
+	}
+
+	atm, err = normalizeAtm(atm)
+	if err != nil {
+		return err
+	}
+	err = requireField("name", atm.Name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return insertAtm(atm, tx)
+	}
+	_, err = tx.Exec(
+		importUpdateAtmSQL,
+		sql.Named("id", atm.Id),
+		sql.Named("name", atm.Name),
+		sql.Named("street", atm.Address),
+		sql.Named("latitude", atm.Latitude),
+		sql.Named("longitude", atm.Longitude),
+		sql.Named("status", atm.Status),
+		sql.Named("open_time", atm.OpenTime),
+		sql.Named("close_time", atm.CloseTime),
+		sql.Named("operations", joinOperations(atm.Operations)),
+	)
+	if err != nil {
+		return queryError(importUpdateAtmSQL, err)
+	}
+	return nil
+}
+
+func mergeAtm(existing Atm, record Atm) Atm {
+	if record.Name != "" {
+		existing.Name = record.Name
+	}
+	if record.Address != "" {
+		existing.Address = record.Address
+	}
+	if record.Latitude != 0 {
+		existing.Latitude = record.Latitude
+	}
+	if record.Longitude != 0 {
+		existing.Longitude = record.Longitude
+	}
+	if record.Status != "" {
+		existing.Status = record.Status
+	}
+	if record.OpenTime != "" {
+		existing.OpenTime = record.OpenTime
+	}
+	if record.CloseTime != "" {
+		existing.CloseTime = record.CloseTime
+	}
+	if len(record.Operations) != 0 {
+		existing.Operations = record.Operations
+	}
+	return existing
+}
+
+func importService(iface interface{}, options ImportOptions, tx *sql.Tx) (err error) {
+	service := iface.(Services)
+	var existing Services
+	scan := func(row rowScanner) (err error) {
+		existing, err = scanService(row)
+		return err
+	}
+	found, field, value := false, "name", interface{}(service.Name)
+	if options.MatchBy == MatchById {
+		field, value = "id", service.Id
+		if service.Id != 0 {
+			found, err = findExisting(findServiceByIdSQL, scan, tx, service.Id)
+		}
+	} else {
+		service.Id, err = freeId(checkServiceIdSQL, service.Id, tx)
+		if err != nil {
+			return err
+		}
+		found, err = findExisting(findServiceByNameSQL, scan, tx, service.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if found {
+		err = options.resolveConflict(field, value)
+		if err != nil {
+			return err
+		}
+		if options.Conflict == ConflictMerge {
+			service = mergeService(existing, service)
+		}
+		service.Id = existing.Id
+		service.Balance = existing.Balance
+	}
+
+	err = requireField("name", service.Name)
+	if err != nil {
+		return err
+	}
+	err = checkServiceLimits(service)
+	if err != nil {
+		return err
+	}
+	err = checkUnique(checkServiceNameSQL, "name", service.Name, service.Id, tx)
+	if err != nil {
+		return err
+	}
+	err = checkCategoryExists(service.CategoryId, tx)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return insertService(service, tx)
+	}
+	_, err = tx.Exec(
+		importUpdateServiceSQL,
+		sql.Named("id", service.Id),
+		sql.Named("name", service.Name),
+		sql.Named("reference_pattern", service.ReferencePattern),
+		sql.Named("min_amount", service.MinAmount),
+		sql.Named("max_amount", service.MaxAmount),
+		sql.Named("category_id", service.CategoryId),
+		sql.Named("description", service.Description),
+		sql.Named("icon", service.Icon),
+		sql.Named("disabled", service.Disabled),
+		sql.Named("position", service.Position),
+		sql.Named("settlement_period", service.SettlementPeriod),
+	)
+	if err != nil {
+		return queryError(importUpdateServiceSQL, err)
+	}
+	return nil
+}
+
+func mergeService(existing Services, record Services) Services {
+	if record.Name != "" {
+		existing.Name = record.Name
+	}
+	if record.ReferencePattern != "" {
+		existing.ReferencePattern = record.ReferencePattern
+	}
+	if record.MinAmount != 0 {
+		existing.MinAmount = record.MinAmount
+	}
+	if record.MaxAmount != 0 {
+		existing.MaxAmount = record.MaxAmount
+	}
+	if record.CategoryId != 0 {
+		existing.CategoryId = record.CategoryId
+	}
+	if record.Description != "" {
+		existing.Description = record.Description
+	}
+	if record.Icon != "" {
+		existing.Icon = record.Icon
+	}
+	if record.Disabled {
+		existing.Disabled = true
+	}
+	if record.Position != 0 {
+		existing.Position = record.Position
+	}
+	if record.SettlementPeriod != 0 {
+		existing.SettlementPeriod = record.SettlementPeriod
+	}
+	return existing
+}
+
+func (receiver exchangeEntity) decode(r io.Reader, format string, options ImportOptions) ([]importItem, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = Open(data, options.Seal)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []importItem
+	switch format {
+	case FormatJSON, FormatXML:
+		unmarshal := json.Unmarshal
+		if format == FormatXML {
+			unmarshal = xml.Unmarshal
+		}
+		values, err := receiver.mapBytes(data, unmarshal)
+		if err != nil {
+			return nil, err
+		}
+		for index, value := range values {
+			items = append(items, importItem{index: index + 1, value: value})
+		}
+	case FormatCSV:
+		records, err := mapBytesToCSVRecords(data, receiver.csv, options.CSV)
+		var typedErr *CSVImportError
+		if err != nil && !errors.As(err, &typedErr) {
+			return nil, err
+		}
+		for _, record := range records {
+			record := record.(csvRecord)
+			items = append(items, importItem{index: record.line, value: record.value})
+		}
+		if typedErr != nil {
+			for _, row := range typedErr.Rows {
+				items = append(items, importItem{index: row.Line, err: row.Err})
+			}
+			sort.Slice(items, func(i, j int) bool {
+				return items[i].index < items[j].index
+			})
+		}
+	default:
+		return nil, ErrUnknownFormat
+	}
+	return items, nil
+}
+
+func (receiver exchangeEntity) importWithOptions(r io.Reader, format string, options ImportOptions, db *sql.DB) (report ImportReport, err error) {
+	err = options.check()
+	if err != nil {
+		return ImportReport{}, err
+	}
+	items, err := receiver.decode(r, format, options)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return importItems(items, receiver.importRecord, options, db)
+}
+
+func importItems(items []importItem, importRecord func(interface{}, ImportOptions, *sql.Tx) error, options ImportOptions, db *sql.DB) (report ImportReport, err error) {
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(items)
+	}
+
+	// dry run keeps all batches in one transaction so later batches see earlier ones
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		if tx == nil {
+			tx, err = db.Begin()
+			if err != nil {
+				return ImportReport{}, dbError(err)
+			}
+		}
+		records, err := importBatch(items[start:end], importRecord, options, tx)
+		if err != nil {
+			return ImportReport{}, err
+		}
+		for _, record := range records {
+			report.add(record)
+		}
+		if !options.DryRun {
+			err = tx.Commit()
+			tx = nil
+			if err != nil {
+				return ImportReport{}, dbError(err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// importBatch imports items under savepoint, keeps them only when none of them failed
+func importBatch(items []importItem, importRecord func(interface{}, ImportOptions, *sql.Tx) error, options ImportOptions, tx *sql.Tx) (records []ImportRecord, err error) {
+	_, err = tx.Exec(savepointImportBatchSQL)
+	if err != nil {
+		return nil, queryError(savepointImportBatchSQL, err)
+	}
+
+	failed := 0
+	records = make([]ImportRecord, len(items))
+	for index, item := range items {
+		records[index] = ImportRecord{Index: item.index, Status: ImportAccepted}
+		err = item.err
+		if err == nil {
+			err = importRecord(item.value, options, tx)
+		}
+		if err == errRecordSkipped {
+			records[index] = ImportRecord{Index: item.index, Status: ImportSkipped, Reason: err.Error()}
+			continue
+		}
+		if err != nil {
+			records[index] = ImportRecord{Index: item.index, Status: ImportFailed, Reason: err.Error()}
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		_, err = tx.Exec(rollbackImportBatchSQL)
+		if err != nil {
+			return nil, queryError(rollbackImportBatchSQL, err)
+		}
+		for index := range records {
+			if records[index].Status == ImportAccepted {
+				records[index].Status = ImportSkipped
+				records[index].Reason = fmt.Sprintf("batch rolled back, %d records failed", failed)
+			}
+		}
+	}
+	_, err = tx.Exec(releaseImportBatchSQL)
+	if err != nil {
+		return nil, queryError(releaseImportBatchSQL, err)
+	}
+	return records, nil
+}
+
+// ImportClientsWithOptions imports clients in transactional batches and reports outcome of every record
+func ImportClientsWithOptions(r io.Reader, format string, options ImportOptions, db *sql.DB) (ImportReport, error) {
+	return clientsExchange.importWithOptions(r, format, options, db)
+}
+
+func ImportAtmsWithOptions(r io.Reader, format string, options ImportOptions, db *sql.DB) (ImportReport, error) {
+	return atmsExchange.importWithOptions(r, format, options, db)
+}
+
+func ImportServicesWithOptions(r io.Reader, format string, options ImportOptions, db *sql.DB) (ImportReport, error) {
+	return servicesExchange.importWithOptions(r, format, options, db)
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const importClientsJSON = `{"Clients":[
+{"Name":"Vasya","Login":"vasya","Password":"secret","Balance":100,"BalanceNumber":1001,"PhoneNumber":992900000001},
+{"Name":"Petya","Login":"vasya","Password":"secret","Balance":100,"BalanceNumber":1002,"PhoneNumber":992900000002},
+{"Name":"Masha","Login":"masha","Password":"secret","Balance":100,"BalanceNumber":1003,"PhoneNumber":992900000003}]}`
+
+func countClients(t *testing.T, db *sql.DB) int {
+	page, err := ListClients(ListOptions{}, db)
+	if err != nil {
+		t.Fatalf("can't list clients: %v", err)
+	}
+	return len(page.Clients)
+}
+
+func TestImportClientsWithOptions_Batches(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+
+	report, err := ImportClientsWithOptions(strings.NewReader(importClientsJSON), FormatJSON, ImportOptions{}, db)
+	if err != nil {
+		t.Fatalf("can't import: %v", err)
+	}
+	if report.Accepted != 0 || report.Skipped != 2 || report.Failed != 1 || report.Records[1].Status != ImportFailed {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if count := countClients(t, db); count != 0 {
+		t.Errorf("partial import: %d clients", count)
+	}
+
+	report, err = ImportClientsWithOptions(strings.NewReader(importClientsJSON), FormatJSON, ImportOptions{BatchSize: 1, DryRun: true}, db)
+	if err != nil {
+		t.Fatalf("can't import: %v", err)
+	}
+	if report.Accepted != 2 || report.Failed != 1 {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	if count := countClients(t, db); count != 0 {
+		t.Errorf("dry run imported %d clients", count)
+	}
+
+	report, err = ImportClientsWithOptions(strings.NewReader(importClientsJSON), FormatJSON, ImportOptions{BatchSize: 1}, db)
+	if err != nil {
+		t.Fatalf("can't import: %v", err)
+	}
+	if report.Accepted != 2 || report.Failed != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if count := countClients(t, db); count != 2 {
+		t.Errorf("unexpected clients count: %d", count)
+	}
+}
+
+func TestImportAtms_CSVReportsLines(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+
+	data := "name,address,latitude,status\n" +
+		"Center,Rudaki 1,38.5,online\n" +
+		"North,Somoni 2,north,online\n" +
+		",Ismoili Somoni 3,38.6,online\n"
+	err = ImportAtms(strings.NewReader(data), FormatCSV, db)
+	var typedErr *ImportError
+	if ok := errors.As(err, &typedErr); !ok {
+		t.Fatalf("error not match ImportError: %v", err)
+	}
+	if len(typedErr.Records) != 2 || typedErr.Records[0].Index != 3 || typedErr.Records[1].Index != 4 {
+		t.Errorf("unexpected failed records: %v", err)
+	}
+	atms, err := GetAllAtms(db)
+	if err != nil || len(atms) != 0 {
+		t.Errorf("partial import: %v %v", atms, err)
+	}
+}
+
+func TestImportClientsWithOptions_Conflicts(t *testing.T) {
+	source, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(source)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	for _, client := range []Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya", Login: "petya", Password: "secret", Balance: 100, BalanceNumber: 1002, PhoneNumber: 992900000002},
+		{Name: "Masha", Login: "masha", Password: "secret", Balance: 100, BalanceNumber: 1003, PhoneNumber: 992900000003},
+	} {
+		err = AddClients(client, source)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+	err = RemoveClient(1, 1, source)
+	if err != nil {
+		t.Fatalf("can't remove client: %v", err)
+	}
+	exported := &strings.Builder{}
+	err = ExportClients(exported, FormatJSON, source)
+	if err != nil {
+		t.Fatalf("can't export: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	_, err = ImportClientsWithOptions(strings.NewReader(exported.String()), FormatJSON, ImportOptions{}, db)
+	if err != nil {
+		t.Fatalf("can't import: %v", err)
+	}
+	page, err := ListClients(ListOptions{}, db)
+	if err != nil || len(page.Clients) != 2 || page.Clients[0].Id != 2 || page.Clients[1].Id != 3 {
+		t.Errorf("ids not preserved: %v %v", page.Clients, err)
+	}
+
+	report, err := ImportClientsWithOptions(strings.NewReader(exported.String()), FormatJSON, ImportOptions{}, db)
+	if err != nil || report.Failed != 2 {
+		t.Errorf("unexpected report for ConflictFail: %+v %v", report, err)
+	}
+	report, err = ImportClientsWithOptions(strings.NewReader(exported.String()), FormatJSON, ImportOptions{Conflict: ConflictSkip}, db)
+	if err != nil || report.Skipped != 2 || report.Failed != 0 {
+		t.Errorf("unexpected report for ConflictSkip: %+v %v", report, err)
+	}
+
+	merge := `{"Clients":[{"Id":3,"Name":"Maria"}]}`
+	report, err = ImportClientsWithOptions(strings.NewReader(merge), FormatJSON, ImportOptions{Conflict: ConflictMerge, MatchBy: MatchById}, db)
+	if err != nil || report.Accepted != 1 {
+		t.Errorf("unexpected report for ConflictMerge: %+v %v", report, err)
+	}
+	overwrite := `{"Clients":[{"Name":"Pyotr","Login":"petya","Password":"new","Balance":100,"BalanceNumber":1002,"PhoneNumber":992900000002}]}`
+	report, err = ImportClientsWithOptions(strings.NewReader(overwrite), FormatJSON, ImportOptions{Conflict: ConflictOverwrite}, db)
+	if err != nil || report.Accepted != 1 {
+		t.Errorf("unexpected report for ConflictOverwrite: %+v %v", report, err)
+	}
+	page, err = ListClients(ListOptions{}, db)
+	if err != nil || page.Clients[0].Name != "Pyotr" || page.Clients[1].Name != "Maria" || page.Clients[1].Balance != 100 {
+		t.Errorf("unexpected clients: %v %v", page.Clients, err)
+	}
+
+	for _, options := range []ImportOptions{{Conflict: ConflictMerge}, {Conflict: ConflictOverwrite}} {
+		changed := strings.Replace(overwrite, `"Balance":100`, `"Balance":1`, 1)
+		report, err = ImportClientsWithOptions(strings.NewReader(changed), FormatJSON, options, db)
+		if err != nil || report.Accepted != 1 {
+			t.Errorf("unexpected report for %s with changed balance: %+v %v", options.Conflict, report, err)
+		}
+		client, err := GetClientProfile(2, db)
+		if err != nil || client.Balance != 100 {
+			t.Errorf("balance changed by %s: %+v %v", options.Conflict, client, err)
+		}
+	}
+
+	_, err = ImportClientsWithOptions(strings.NewReader(merge), FormatJSON, ImportOptions{Conflict: "replace"}, db)
+	if err != ErrInvalidImportOptions {
+		t.Errorf("Not ErrInvalidImportOptions for unknown strategy: %v", err)
+	}
+}
+
+func TestImportFromReaderTx_AllOrNothing(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	mapBytes := func(data []byte) ([]interface{}, error) {
+		return mapBytesToClients(data, json.Unmarshal)
+	}
+	insertToDB := func(iface interface{}, tx *sql.Tx) error {
+		return insertClient(iface.(Client), tx)
+	}
+
+	err := ImportFromReaderTx(db, strings.NewReader(importClientsJSON), mapBytes, insertToDB)
+	var typedErr *ImportError
+	if ok := errors.As(err, &typedErr); !ok || len(typedErr.Records) != 1 || typedErr.Records[0].Index != 2 {
+		t.Errorf("error not match ImportError for second record: %v", err)
+	}
+	if count := countClients(t, db); count != 0 {
+		t.Errorf("clients imported from failed file: %d", count)
+	}
+
+	err = ImportFromReaderTx(db, strings.NewReader(strings.Replace(importClientsJSON, `"Login":"vasya","Password":"secret","Balance":100,"BalanceNumber":1002`,
+		`"Login":"petya","Password":"secret","Balance":100,"BalanceNumber":1002`, 1)), mapBytes, insertToDB)
+	if err != nil {
+		t.Fatalf("can't import clients: %v", err)
+	}
+	if count := countClients(t, db); count != 3 {
+		t.Errorf("unexpected imported clients: %d", count)
+	}
+}
+
+func TestImportFromReader_StopsAtFailedRecord(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	mapBytes := func(data []byte) ([]interface{}, error) {
+		return mapBytesToClients(data, json.Unmarshal)
+	}
+	insertToDB := func(iface interface{}, db *sql.DB) error {
+		return insertClient(iface.(Client), db)
+	}
+
+	err := ImportFromReader(db, strings.NewReader(importClientsJSON), mapBytes, insertToDB)
+	if err == nil {
+		t.Errorf("duplicate login imported")
+	}
+	if count := countClients(t, db); count != 1 {
+		t.Errorf("unexpected imported clients: %d", count)
+	}
+}
@@ -0,0 +1,355 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultListLimit = 50
+const maxListLimit = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidSortField = errors.New("invalid sort field")
+
+// ListOptions describes one page of keyset pagination, Cursor is NextCursor of previous page
+type ListOptions struct {
+	Limit      int
+	Cursor     string
+	SortField  string
+	Descending bool
+	Search     string
+}
+
+type AtmPage struct {
+	Atms       []Atm
+	NextCursor string
+}
+
+type ServicePage struct {
+	Services   []Services
+	NextCursor string
+}
+
+type ClientPage struct {
+	Clients    []Client
+	NextCursor string
+}
+
+type TransactionPage struct {
+	Transactions []Transaction
+	NextCursor   string
+}
+
+type cursor struct {
+	Value string `json:"v"`
+	Id    int64  `json:"id"`
+}
+
+type listSpec struct {
+	table         string
+	columns       string
+	condition     string
+	sortFields    map[string]string
+	searchColumns []string
+	mapRow        MapperRowTo
+	position      func(item interface{}, column string) cursor
+}
+
+func encodeCursor(position cursor) string {
+	data, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (position cursor, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	err = json.Unmarshal(data, &position)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return position, nil
+}
+
+func escapeLike(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `%`, `\%`, -1)
+	return strings.Replace(value, `_`, `\_`, -1)
+}
+
+func list(spec listSpec, options ListOptions, conditions []string, args []interface{}, db *sql.DB) (items []interface{}, next string, err error) {
+	column := "id"
+	if options.SortField != "" {
+		var ok bool
+		column, ok = spec.sortFields[options.SortField]
+		if !ok {
+			return nil, "", ErrInvalidSortField
+		}
+	}
+
+	limit := options.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	where := append([]string{spec.condition}, conditions...)
+	if options.Search != "" && len(spec.searchColumns) != 0 {
+		pattern := "%" + escapeLike(options.Search) + "%"
+		likes := make([]string, len(spec.searchColumns))
+		for index, searchColumn := range spec.searchColumns {
+			likes[index] = searchColumn + ` like ? escape '\'`
+			args = append(args, pattern)
+		}
+		where = append(where, "("+strings.Join(likes, " or ")+")")
+	}
+
+	direction, compare := "asc", ">"
+	if options.Descending {
+		direction, compare = "desc", "<"
+	}
+	if options.Cursor != "" {
+		position, err := decodeCursor(options.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if column == "id" {
+			where = append(where, "id "+compare+" ?")
+			args = append(args, position.Id)
+		} else {
+			where = append(where, fmt.Sprintf("(%s %s ? or (%s = ? and id %s ?))", column, compare, column, compare))
+			args = append(args, position.Value, position.Value, position.Id)
+		}
+	}
+
+	query := fmt.Sprintf("select %s from %s where %s order by %s %s, id %s limit ?",
+		spec.columns, spec.table, strings.Join(where, " and "), column, direction, direction)
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", queryError(query, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			items, next, err = nil, "", dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		item, err := spec.mapRow(rows)
+		if err != nil {
+			return nil, "", dbError(err)
+		}
+		items = append(items, item)
+	}
+	if rows.Err() != nil {
+		return nil, "", dbError(rows.Err())
+	}
+
+	if len(items) > limit {
+		items = items[:limit]
+		next = encodeCursor(spec.position(items[limit-1], column))
+	}
+	return items, next, nil
+}
+
+func scanService(row rowScanner) (service Services, err error) {
+	err = row.Scan(&service.Id, &service.Name, &service.Balance, &service.ReferencePattern,
+		&service.MinAmount, &service.MaxAmount, &service.CategoryId, &service.Description,
+		&service.Icon, &service.Disabled, &service.Position, &service.SettlementPeriod, &service.Version)
+	if err != nil {
+		return Services{}, err
+	}
+	return service, nil
+}
+
+// scanClient reads client without password
+func scanClient(row rowScanner) (client Client, err error) {
+	err = row.Scan(&client.Id, &client.Name, &client.Login, &client.Balance,
+		&client.BalanceNumber, &client.PhoneNumber, &client.Version)
+	if err != nil {
+		return Client{}, err
+	}
+	return client, nil
+}
+
+var atmListSpec = listSpec{
+	table:     "atm",
+	columns:   "id, name, street, latitude, longitude, status, open_time, close_time, operations, version",
+	condition: "removed = 0",
+	sortFields: map[string]string{
+		"id":      "id",
+		"name":    "name",
+		"address": "street",
+		"status":  "status",
+	},
+	searchColumns: []string{"name", "street"},
+	mapRow: func(rows *sql.Rows) (interface{}, error) {
+		return scanAtm(rows)
+	},
+	position: func(item interface{}, column string) cursor {
+		atm := item.(Atm)
+		switch column {
+		case "name":
+			return cursor{Value: atm.Name, Id: atm.Id}
+		case "street":
+			return cursor{Value: atm.Address, Id: atm.Id}
+		case "status":
+			return cursor{Value: atm.Status, Id: atm.Id}
+		}
+		return cursor{Id: atm.Id}
+	},
+}
+
+var serviceListSpec = listSpec{
+	table:     "services",
+	columns:   serviceColumns,
+	condition: "removed = 0",
+	sortFields: map[string]string{
+		"id":      "id",
+		"name":    "name",
+		"balance": "balance",
+	},
+	searchColumns: []string{"name"},
+	mapRow: func(rows *sql.Rows) (interface{}, error) {
+		return scanService(rows)
+	},
+	position: func(item interface{}, column string) cursor {
+		service := item.(Services)
+		switch column {
+		case "name":
+			return cursor{Value: service.Name, Id: service.Id}
+		case "balance":
+			return cursor{Value: strconv.FormatUint(service.Balance, 10), Id: service.Id}
+		}
+		return cursor{Id: service.Id}
+	},
+}
+
+var clientListSpec = listSpec{
+	table:     "client",
+	columns:   "id, name, login, balance, balance_number, phone_number, version",
+	condition: "removed = 0",
+	sortFields: map[string]string{
+		"id":             "id",
+		"name":           "name",
+		"login":          "login",
+		"balance":        "balance",
+		"balance_number": "balance_number",
+		"phone_number":   "phone_number",
+	},
+	searchColumns: []string{"name", "login"},
+	mapRow: func(rows *sql.Rows) (interface{}, error) {
+		return scanClient(rows)
+	},
+	position: func(item interface{}, column string) cursor {
+		client := item.(Client)
+		switch column {
+		case "name":
+			return cursor{Value: client.Name, Id: client.Id}
+		case "login":
+			return cursor{Value: client.Login, Id: client.Id}
+		case "balance":
+			return cursor{Value: strconv.FormatUint(client.Balance, 10), Id: client.Id}
+		case "balance_number":
+			return cursor{Value: strconv.FormatUint(client.BalanceNumber, 10), Id: client.Id}
+		case "phone_number":
+			return cursor{Value: strconv.FormatInt(client.PhoneNumber, 10), Id: client.Id}
+		}
+		return cursor{Id: client.Id}
+	},
+}
+
+var transactionListSpec = listSpec{
+	table:     "transactions",
+	columns:   "id, kind, payer_balance_number, payee_balance_number, service_id, amount, reference, created_at",
+	condition: "1 = 1",
+	sortFields: map[string]string{
+		"id":         "id",
+		"created_at": "created_at",
+		"amount":     "amount",
+	},
+	searchColumns: []string{"kind", "reference"},
+	mapRow: func(rows *sql.Rows) (interface{}, error) {
+		return scanTransaction(rows)
+	},
+	position: func(item interface{}, column string) cursor {
+		transaction := item.(Transaction)
+		switch column {
+		case "created_at":
+			return cursor{Value: strconv.FormatInt(transaction.CreatedAt, 10), Id: transaction.Id}
+		case "amount":
+			return cursor{Value: strconv.FormatUint(transaction.Amount, 10), Id: transaction.Id}
+		}
+		return cursor{Id: transaction.Id}
+	},
+}
+
+func ListAtms(options ListOptions, db *sql.DB) (page AtmPage, err error) {
+	items, next, err := list(atmListSpec, options, nil, nil, db)
+	if err != nil {
+		return AtmPage{}, err
+	}
+	page.Atms = make([]Atm, len(items))
+	for index := range items {
+		page.Atms[index] = items[index].(Atm)
+	}
+	page.NextCursor = next
+	return page, nil
+}
+
+func ListServices(options ListOptions, db *sql.DB) (page ServicePage, err error) {
+	items, next, err := list(serviceListSpec, options, nil, nil, db)
+	if err != nil {
+		return ServicePage{}, err
+	}
+	page.Services = make([]Services, len(items))
+	for index := range items {
+		page.Services[index] = items[index].(Services)
+	}
+	page.NextCursor = next
+	return page, nil
+}
+
+func ListClients(options ListOptions, db *sql.DB) (page ClientPage, err error) {
+	items, next, err := list(clientListSpec, options, nil, nil, db)
+	if err != nil {
+		return ClientPage{}, err
+	}
+	page.Clients = make([]Client, len(items))
+	for index := range items {
+		page.Clients[index] = items[index].(Client)
+	}
+	page.NextCursor = next
+	return page, nil
+}
+
+// ListTransactions lists movements of one account, zero balanceNumber lists all of them
+func ListTransactions(balanceNumber uint64, options ListOptions, db *sql.DB) (page TransactionPage, err error) {
+	var conditions []string
+	var args []interface{}
+	if balanceNumber != 0 {
+		conditions = append(conditions, "(payer_balance_number = ? or payee_balance_number = ?)")
+		args = append(args, balanceNumber, balanceNumber)
+	}
+
+	items, next, err := list(transactionListSpec, options, conditions, args, db)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	page.Transactions = make([]Transaction, len(items))
+	for index := range items {
+		page.Transactions[index] = items[index].(Transaction)
+	}
+	page.NextCursor = next
+	return page, nil
+}
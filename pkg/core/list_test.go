@@ -0,0 +1,107 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestListClients_PagesBySortField(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	// balances 100, 90, 90, 80, 80 to check ties are handled by id
+	balances := []uint64{100, 90, 90, 80, 80}
+	for index, balance := range balances {
+		err = AddClients(Client{
+			Name:          fmt.Sprintf("client%d", index),
+			Login:         fmt.Sprintf("login%d", index),
+			Password:      "secret",
+			Balance:       balance,
+			BalanceNumber: uint64(1000 + index),
+			PhoneNumber:   int64(992900000000 + index),
+		}, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+
+	var logins []string
+	options := ListOptions{Limit: 2, SortField: "balance", Descending: true}
+	for {
+		page, err := ListClients(options, db)
+		if err != nil {
+			t.Fatalf("can't list clients: %v", err)
+		}
+		for _, client := range page.Clients {
+			if client.Password != "" {
+				t.Error("password must not be listed")
+			}
+			logins = append(logins, client.Login)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+
+	expected := "[login0 login2 login1 login4 login3]"
+	if fmt.Sprint(logins) != expected {
+		t.Errorf("unexpected order: %v, expected %v", logins, expected)
+	}
+}
+
+func TestListTransactions_RecordsTransfers(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddClients(Client{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	_, err = TransferByPhoneNumber(1001, 30, Client{PhoneNumber: 992900000002, Balance: 30}, db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+
+	page, err := ListTransactions(1002, ListOptions{}, db)
+	if err != nil {
+		t.Fatalf("can't list transactions: %v", err)
+	}
+	if len(page.Transactions) != 1 {
+		t.Fatalf("unexpected transactions: %v", page.Transactions)
+	}
+	transaction := page.Transactions[0]
+	if transaction.PayerBalanceNumber != 1001 || transaction.PayeeBalanceNumber != 1002 || transaction.Amount != 30 {
+		t.Errorf("unexpected transaction: %v", transaction)
+	}
+}
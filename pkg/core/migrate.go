@@ -0,0 +1,160 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrDatabaseTooNew = errors.New("database was created by newer version")
+
+// migration upgrades table created by older version of Init, migrations with version
+// greater than user_version of database run in order and then SchemaVersion is stored
+type migration struct {
+	version int
+	table   string
+	// ddl creates table again and values of columns existing in both tables are copied,
+	// so new columns may have any default
+	ddl string
+}
+
+var migrations = []migration{
+	{version: 1, table: "atm", ddl: atmDDL},
+	{version: 1, table: "client", ddl: clientDDL},
+	{version: 1, table: "services", ddl: servicesDDL},
+	{version: 1, table: "transactions", ddl: transactionsDDL},
+}
+
+var ddls = []string{managersDDL, atmDDL, clientDDL, servicesDDL, transactionsDDL, categoriesDDL, settlementsDDL, receiptsDDL, webhooksDDL}
+
+// migrate creates missing tables and upgrades existing ones in one transaction,
+// new database gets SchemaVersion without migrations and up to date database is not touched
+func migrate(db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			err = dbError(err)
+		}
+	}()
+
+	var version int
+	err = tx.QueryRow(getSchemaVersionSQL).Scan(&version)
+	if err != nil {
+		return queryError(getSchemaVersionSQL, err)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: schema version %d", ErrDatabaseTooNew, version)
+	}
+	var tables int
+	err = tx.QueryRow(countTablesSQL).Scan(&tables)
+	if err != nil {
+		return queryError(countTablesSQL, err)
+	}
+	for _, ddl := range ddls {
+		_, err = tx.Exec(ddl)
+		if err != nil {
+			return queryError(ddl, err)
+		}
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+
+	for _, step := range migrations {
+		if tables == 0 || step.version <= version {
+			continue
+		}
+		err = step.apply(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := fmt.Sprintf(setSchemaVersionSQL, SchemaVersion)
+	_, err = tx.Exec(query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+func (receiver migration) apply(tx *sql.Tx) (err error) {
+	existing, err := tableColumns(receiver.table, tx)
+	if err != nil {
+		return err
+	}
+	return rebuildTable(receiver.table, receiver.ddl, existing, tx)
+}
+
+// rebuildTable keeps old rows under temporary name until they are copied into new table,
+// indexes and triggers of old table are dropped with it and created again by Init
+func rebuildTable(table string, ddl string, existing map[string]bool, tx *sql.Tx) (err error) {
+	migrated := table + "_migrated"
+	query := fmt.Sprintf(renameTableSQL, table, migrated)
+	_, err = tx.Exec(query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	_, err = tx.Exec(ddl)
+	if err != nil {
+		return queryError(ddl, err)
+	}
+
+	columns, err := tableColumns(table, tx)
+	if err != nil {
+		return err
+	}
+	var copied []string
+	for column := range columns {
+		if existing[column] {
+			copied = append(copied, `"`+column+`"`)
+		}
+	}
+	query = fmt.Sprintf(copyRowsSQL, table, strings.Join(copied, ", "), strings.Join(copied, ", "), migrated)
+	_, err = tx.Exec(query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	query = fmt.Sprintf(dropTableSQL, migrated)
+	_, err = tx.Exec(query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+func tableColumns(table string, tx *sql.Tx) (columns map[string]bool, err error) {
+	query := fmt.Sprintf(tableColumnsSQL, table)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			columns, err = nil, dbError(innerErr)
+		}
+	}()
+
+	columns = map[string]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		columns[name] = true
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+	return columns, nil
+}
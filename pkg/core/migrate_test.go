@@ -0,0 +1,155 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestInit_MigratesBaselineAtms(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = db.Exec(`create table atm (id integer primary key autoincrement, name text not null, street text not null);
+insert into atm (name, street) values ('Center', 'Rudaki 1');`)
+	if err != nil {
+		t.Fatalf("can't create baseline table: %v", err)
+	}
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddAtm(Atm{Name: "Airport", Address: "Airport 1", Latitude: 38.5}, db)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+	atms, err := GetAllAtms(db)
+	if err != nil || len(atms) != 2 {
+		t.Fatalf("can't get atms: %v %v", atms, err)
+	}
+	if atms[0].Address != "Rudaki 1" || atms[0].Status != AtmOnline || atms[0].Version != 1 {
+		t.Errorf("unexpected migrated atm: %+v", atms[0])
+	}
+
+	var version int
+	err = db.QueryRow(getSchemaVersionSQL).Scan(&version)
+	if err != nil || version != SchemaVersion {
+		t.Errorf("unexpected schema version: %d %v", version, err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Errorf("can't init migrated db again: %v", err)
+	}
+}
+
+func TestInit_DatabaseTooNew(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = db.Exec(`pragma user_version = 1000;`)
+	if err != nil {
+		t.Fatalf("can't set version: %v", err)
+	}
+
+	err = Init(db)
+	if !errors.Is(err, ErrDatabaseTooNew) {
+		t.Errorf("Not ErrDatabaseTooNew for newer database: %v", err)
+	}
+}
+
+func TestInit_MigratesBaselineClients(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = db.Exec(`create table client (id integer primary key autoincrement, name text not null, login text not null unique,
+password text not null, balance integer not null check(balance>=0), balance_number integer not null unique,
+phone_number integer not null unique);
+insert into client (name, login, password, balance, balance_number, phone_number) values ('Vasya', 'vasya', 'secret', 100, 1001, 992900000001);`)
+	if err != nil {
+		t.Fatalf("can't create baseline table: %v", err)
+	}
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	id, result, err := Login("vasya", "secret", db)
+	if err != nil || !result || id != 1 {
+		t.Errorf("can't login migrated client: %d %v %v", id, result, err)
+	}
+	err = RemoveClient(1, 1, db)
+	if err != nil {
+		t.Errorf("can't remove migrated client: %v", err)
+	}
+}
+
+func TestInit_MigratesBaselineDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = db.Exec(`create table managers (id integer primary key autoincrement, name text not null, login text not null unique, password text not null);
+create table client (id integer primary key autoincrement, name text not null, login text not null unique,
+password text not null, balance integer not null check(balance>=0), balance_number integer not null unique,
+phone_number integer not null unique);
+create table atm (id integer primary key autoincrement, name text not null, street text not null);
+create table services (id integer primary key autoincrement, name text not null, balance integer not null);
+insert into client (name, login, password, balance, balance_number, phone_number) values ('Vasya', 'vasya', 'secret', 100, 1001, 992900000001);
+insert into atm (name, street) values ('Center', 'Rudaki 1');
+insert into services (name, balance) values ('Tcell', 50);`)
+	if err != nil {
+		t.Fatalf("can't create baseline tables: %v", err)
+	}
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	services, err := GetServices(db)
+	if err != nil || len(services) != 1 {
+		t.Fatalf("can't get services: %v %v", services, err)
+	}
+	if services[0].Name != "Tcell" || services[0].Balance != 50 || services[0].SettlementPeriod != 1 || services[0].Version != 1 {
+		t.Errorf("unexpected migrated service: %+v", services[0])
+	}
+	_, err = TransferByBalanceNumber(1001, 10, Client{BalanceNumber: 1001, Balance: 10}, db)
+	if err != nil {
+		t.Errorf("can't record transfer in migrated db: %v", err)
+	}
+
+	var count int
+	err = db.QueryRow(`select count(*) from sqlite_master where name like '%_change_seq' or name like '%_change' or name like '%_touch';`).Scan(&count)
+	if err != nil || count != 12 {
+		t.Errorf("unexpected change indexes and triggers: %d %v", count, err)
+	}
+	err = db.QueryRow(`select count(*) from client join atm join services where client.updated_at > 0 and atm.updated_at > 0 and services.updated_at > 0;`).Scan(&count)
+	if err != nil || count != 1 {
+		t.Errorf("rows have no timestamps: %d %v", count, err)
+	}
+}
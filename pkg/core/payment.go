@@ -0,0 +1,80 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+)
+
+var ErrInvalidReference = errors.New("payer reference does not match service format")
+var ErrInvalidReferencePattern = errors.New("invalid payer reference pattern")
+var ErrInvalidAmountLimits = errors.New("min amount is greater than max amount")
+var ErrAmountTooSmall = errors.New("amount is less than service minimum")
+var ErrAmountTooLarge = errors.New("amount is greater than service maximum")
+var ErrServiceDisabled = errors.New("service is disabled")
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func checkServiceLimits(service Services) error {
+	if _, err := compileReferencePattern(service.ReferencePattern); err != nil {
+		return ErrInvalidReferencePattern
+	}
+	if service.MaxAmount != 0 && service.MinAmount > service.MaxAmount {
+		return ErrInvalidAmountLimits
+	}
+	if service.SettlementPeriod < 0 {
+		return ErrInvalidSettlementPeriod
+	}
+	return nil
+}
+
+// compileReferencePattern anchors pattern, so whole reference has to match it
+func compileReferencePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// CheckPayment validates reference and amount against service rules, zero limit means no limit
+func (receiver Services) CheckPayment(reference string, amount uint64) error {
+	if reference == "" {
+		return ErrInvalidReference
+	}
+	if receiver.ReferencePattern != "" {
+		pattern, err := compileReferencePattern(receiver.ReferencePattern)
+		if err != nil {
+			return ErrInvalidReferencePattern
+		}
+		if !pattern.MatchString(reference) {
+			return ErrInvalidReference
+		}
+	}
+	if amount == 0 || amount < receiver.MinAmount {
+		return ErrAmountTooSmall
+	}
+	if receiver.MaxAmount != 0 && amount > receiver.MaxAmount {
+		return ErrAmountTooLarge
+	}
+	return nil
+}
+
+func validatePayment(serviceId int64, reference string, amount uint64, db queryRower) (err error) {
+	service := Services{Id: serviceId}
+	err = db.QueryRow(getServicePaymentRulesSQL, serviceId).Scan(
+		&service.ReferencePattern, &service.MinAmount, &service.MaxAmount, &service.Disabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return queryError(getServicePaymentRulesSQL, err)
+	}
+	if service.Disabled {
+		return ErrServiceDisabled
+	}
+	return service.CheckPayment(reference, amount)
+}
+
+// ValidatePayment lets terminals check payment before asking client for confirmation
+func ValidatePayment(serviceId int64, reference string, amount uint64, db *sql.DB) (err error) {
+	return validatePayment(serviceId, reference, amount, db)
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestServices_CheckPayment(t *testing.T) {
+	service := Services{ReferencePattern: `^\d{9}$`, MinAmount: 1, MaxAmount: 1000}
+
+	if err := service.CheckPayment("900123456", 10); err != nil {
+		t.Errorf("valid payment rejected: %v", err)
+	}
+	if err := service.CheckPayment("90012", 10); !errors.Is(err, ErrInvalidReference) {
+		t.Errorf("Not ErrInvalidReference for short reference: %v", err)
+	}
+	if err := service.CheckPayment("900123456", 1001); !errors.Is(err, ErrAmountTooLarge) {
+		t.Errorf("Not ErrAmountTooLarge for big amount: %v", err)
+	}
+}
+
+func TestPayForServices_InvalidReferenceKeepsBalance(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddServices(Services{Name: "Tcell", ReferencePattern: `^\d{9}$`}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+
+	_, err = PayForServices(1001, 50, "abc", Services{Id: 1, Balance: 50}, db)
+	if !errors.Is(err, ErrInvalidReference) {
+		t.Errorf("Not ErrInvalidReference for invalid reference: %v", err)
+	}
+	_, err = PayForServices(1001, 50, "900123456", Services{Id: 1, Balance: 50}, db)
+	if err != nil {
+		t.Fatalf("can't pay: %v", err)
+	}
+
+	balances, err := GetBalanceList(db, 1)
+	if err != nil {
+		t.Fatalf("can't get balance: %v", err)
+	}
+	if balances[0].Balance != 50 {
+		t.Errorf("unexpected balance: %v", balances[0].Balance)
+	}
+	page, err := ListTransactions(1001, ListOptions{}, db)
+	if err != nil {
+		t.Fatalf("can't list transactions: %v", err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].Reference != "900123456" {
+		t.Errorf("unexpected transactions: %v", page.Transactions)
+	}
+}
+
+func TestServices_CheckPaymentMatchesWholeReference(t *testing.T) {
+	service := Services{ReferencePattern: `\d{9}|test`}
+
+	if err := service.CheckPayment("900123456", 10); err != nil {
+		t.Errorf("valid payment rejected: %v", err)
+	}
+	if err := service.CheckPayment("test", 10); err != nil {
+		t.Errorf("valid alternative rejected: %v", err)
+	}
+	for _, reference := range []string{"x900123456", "9001234567", "testing", "900123456\n"} {
+		if err := service.CheckPayment(reference, 10); !errors.Is(err, ErrInvalidReference) {
+			t.Errorf("Not ErrInvalidReference for %q: %v", reference, err)
+		}
+	}
+}
+
+func TestPayForServices_CreditsValidatedAmount(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddServices(Services{Name: "Tcell", MaxAmount: 100}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+
+	_, err = PayForServices(1001, 50, "900123456", Services{Id: 1, Balance: 100000}, db)
+	if err != nil {
+		t.Fatalf("can't pay: %v", err)
+	}
+	services, err := GetServices(db)
+	if err != nil || len(services) != 1 {
+		t.Fatalf("can't get services: %v", err)
+	}
+	if services[0].Balance != 50 {
+		t.Errorf("service credited with unvalidated amount: %v", services[0].Balance)
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"database/sql"
+	"strings"
+)
+
+const (
+	ClientActive  = "active"
+	ClientRemoved = "removed"
+)
+
+const findClientsLimit = 100
+const recentTransactionsLimit = 10
+
+type Account struct {
+	BalanceNumber uint64
+	Balance       uint64
+}
+
+// ClientProfile is manager's view of client, Password is never filled
+type ClientProfile struct {
+	Client
+	Status             string
+	Accounts           []Account
+	RecentTransactions []Transaction
+}
+
+// FindClients searches not removed clients by part of name, login, phone number or balance number
+func FindClients(query string, db *sql.DB) (clients []Client, err error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+
+	rows, err := db.Query(
+		findClientsSQL,
+		sql.Named("query", "%"+escapeLike(query)+"%"),
+		sql.Named("limit", findClientsLimit),
+	)
+	if err != nil {
+		return nil, queryError(findClientsSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			clients, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		clients = append(clients, client)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+
+	return clients, nil
+}
+
+func GetClientProfile(id int64, db *sql.DB) (profile ClientProfile, err error) {
+	var removed bool
+	err = db.QueryRow(getClientProfileSQL, id).Scan(&profile.Id, &profile.Name, &profile.Login,
+		&profile.Balance, &profile.BalanceNumber, &profile.PhoneNumber, &profile.Version, &removed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ClientProfile{}, ErrNotFound
+		}
+		return ClientProfile{}, queryError(getClientProfileSQL, err)
+	}
+
+	profile.Status = ClientActive
+	if removed {
+		profile.Status = ClientRemoved
+	}
+	profile.Accounts = []Account{{BalanceNumber: profile.BalanceNumber, Balance: profile.Balance}}
+
+	page, err := ListTransactions(profile.BalanceNumber, ListOptions{Limit: recentTransactionsLimit, Descending: true}, db)
+	if err != nil {
+		return ClientProfile{}, err
+	}
+	profile.RecentTransactions = page.Transactions
+
+	return profile, nil
+}
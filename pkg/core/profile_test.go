@@ -0,0 +1,123 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFindClients_MatchesNameLoginAndNumbers(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	clients := []Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya", Login: "petya_100%", Password: "secret", BalanceNumber: 2002, PhoneNumber: 992900000002},
+	}
+	for _, client := range clients {
+		err := AddClients(client, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+
+	queries := map[string]string{
+		"asy":       "vasya",
+		" VASYA ":   "vasya",
+		"2002":      "petya_100%",
+		"900000002": "petya_100%",
+		"100%":      "petya_100%",
+	}
+	for query, login := range queries {
+		found, err := FindClients(query, db)
+		if err != nil {
+			t.Errorf("can't find clients by %q: %v", query, err)
+			continue
+		}
+		if len(found) != 1 || found[0].Login != login {
+			t.Errorf("unexpected clients for %q: %v", query, found)
+		}
+	}
+
+	found, err := FindClients("99290000000", db)
+	if err != nil || len(found) != 2 || found[0].Name != "Petya" {
+		t.Errorf("unexpected clients for common phone prefix: %v %v", found, err)
+	}
+	found, err = FindClients("_", db)
+	if err != nil || len(found) != 1 {
+		t.Errorf("underscore is not literal: %v %v", found, err)
+	}
+	found, err = FindClients("  ", db)
+	if err != nil || len(found) != 0 {
+		t.Errorf("blank query found clients: %v %v", found, err)
+	}
+	found, err = FindClients("kolya", db)
+	if err != nil || len(found) != 0 {
+		t.Errorf("unexpected clients for unknown name: %v %v", found, err)
+	}
+}
+
+func TestFindClients_SkipsRemoved(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = RemoveClient(1, 1, db)
+	if err != nil {
+		t.Fatalf("can't remove client: %v", err)
+	}
+
+	found, err := FindClients("vasya", db)
+	if err != nil || len(found) != 0 {
+		t.Errorf("removed client found: %v %v", found, err)
+	}
+}
+
+func TestGetClientProfile_Status(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 500, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = UpdateBalance(Client{Login: "vasya", Balance: 100}, db)
+	if err != nil {
+		t.Fatalf("can't top up: %v", err)
+	}
+
+	profile, err := GetClientProfile(1, db)
+	if err != nil {
+		t.Fatalf("can't get profile: %v", err)
+	}
+	if profile.Status != ClientActive || profile.Balance != 600 || len(profile.Accounts) != 1 || len(profile.RecentTransactions) != 1 {
+		t.Errorf("unexpected profile: %+v", profile)
+	}
+
+	err = RemoveClient(1, profile.Version, db)
+	if err != nil {
+		t.Fatalf("can't remove client: %v", err)
+	}
+	profile, err = GetClientProfile(1, db)
+	if err != nil || profile.Status != ClientRemoved {
+		t.Errorf("unexpected profile of removed client: %+v %v", profile, err)
+	}
+
+	_, err = GetClientProfile(2, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for unknown client: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+const receiptTimeLayout = "02.01.2006 15:04:05 MST"
+
+// Receipt confirms successful transfer or service payment, amounts are in minor units
+// and text and HTML show them in currency units. Fee is 0 when none applies.
+type Receipt struct {
+	Number             string `json:"number"`
+	Kind               string `json:"kind"`
+	PayerBalanceNumber uint64 `json:"payer_balance_number"`
+	PayeeBalanceNumber uint64 `json:"payee_balance_number,omitempty"`
+	ServiceId          int64  `json:"service_id,omitempty"`
+	ServiceName        string `json:"service_name,omitempty"`
+	Reference          string `json:"reference,omitempty"`
+	Amount             uint64 `json:"amount"`
+	Fee                uint64 `json:"fee"`
+	BalanceAfter       uint64 `json:"balance_after"`
+	CreatedAt          int64  `json:"created_at"`
+}
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{"money": formatMoney}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Receipt {{.Number}}</title>
+<style>
+body { font-family: monospace; width: 58mm; margin: 0; }
+h1 { font-size: 14px; text-align: center; }
+table { width: 100%; font-size: 12px; }
+td:last-child { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><td>Receipt</td><td>{{.Number}}</td></tr>
+<tr><td>Date</td><td>{{.Time}}</td></tr>
+<tr><td>From</td><td>{{.PayerBalanceNumber}}</td></tr>
+{{if .PayeeBalanceNumber}}<tr><td>To</td><td>{{.PayeeBalanceNumber}}</td></tr>{{end}}
+{{if .ServiceName}}<tr><td>Service</td><td>{{.ServiceName}}</td></tr>{{end}}
+{{if .Reference}}<tr><td>Reference</td><td>{{.Reference}}</td></tr>{{end}}
+<tr><td>Amount</td><td>{{money .Amount}}</td></tr>
+<tr><td>Fee</td><td>{{money .Fee}}</td></tr>
+<tr><td>Balance</td><td>{{money .BalanceAfter}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+func formatMoney(amount uint64) string {
+	return formatMinorUnits(int64(amount)) + " " + Currency
+}
+
+func receiptNumber(transactionId int64, createdAt int64) string {
+	return fmt.Sprintf("R%s-%09d", time.Unix(createdAt, 0).UTC().Format("20060102"), transactionId)
+}
+
+// issueReceipt builds receipt of just recorded transaction and persists it in the same transaction
+func issueReceipt(transactionId int64, tx *sql.Tx) (receipt Receipt, err error) {
+	err = tx.QueryRow(getReceiptDataSQL, transactionId).Scan(&receipt.Kind, &receipt.PayerBalanceNumber,
+		&receipt.PayeeBalanceNumber, &receipt.ServiceId, &receipt.ServiceName, &receipt.Reference,
+		&receipt.Amount, &receipt.CreatedAt, &receipt.BalanceAfter)
+	if err != nil {
+		return Receipt{}, queryError(getReceiptDataSQL, err)
+	}
+	receipt.Number = receiptNumber(transactionId, receipt.CreatedAt)
+
+	_, err = tx.Exec(
+		insertReceiptSQL,
+		sql.Named("number", receipt.Number),
+		sql.Named("transaction_id", transactionId),
+		sql.Named("kind", receipt.Kind),
+		sql.Named("payer_balance_number", receipt.PayerBalanceNumber),
+		sql.Named("payee_balance_number", receipt.PayeeBalanceNumber),
+		sql.Named("service_id", receipt.ServiceId),
+		sql.Named("service_name", receipt.ServiceName),
+		sql.Named("reference", receipt.Reference),
+		sql.Named("amount", receipt.Amount),
+		sql.Named("fee", receipt.Fee),
+		sql.Named("balance_after", receipt.BalanceAfter),
+		sql.Named("created_at", receipt.CreatedAt),
+	)
+	if err != nil {
+		return Receipt{}, queryError(insertReceiptSQL, err)
+	}
+
+	return receipt, nil
+}
+
+func GetReceipt(number string, db *sql.DB) (receipt Receipt, err error) {
+	err = db.QueryRow(getReceiptSQL, number).Scan(&receipt.Number, &receipt.Kind, &receipt.PayerBalanceNumber,
+		&receipt.PayeeBalanceNumber, &receipt.ServiceId, &receipt.ServiceName, &receipt.Reference,
+		&receipt.Amount, &receipt.Fee, &receipt.BalanceAfter, &receipt.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Receipt{}, ErrNotFound
+		}
+		return Receipt{}, queryError(getReceiptSQL, err)
+	}
+	return receipt, nil
+}
+
+func (receiver Receipt) Title() string {
+	switch receiver.Kind {
+	case TransactionPayment:
+		return "Payment for services"
+	case TransactionTransfer:
+		return "Money transfer"
+	}
+	return "Receipt"
+}
+
+// Time is in UTC like date in receipt number
+func (receiver Receipt) Time() string {
+	return time.Unix(receiver.CreatedAt, 0).UTC().Format(receiptTimeLayout)
+}
+
+func (receiver Receipt) WriteText(w io.Writer) (err error) {
+	lines := []string{
+		receiver.Title(),
+		fmt.Sprintf("Receipt:   %s", receiver.Number),
+		fmt.Sprintf("Date:      %s", receiver.Time()),
+		fmt.Sprintf("From:      %d", receiver.PayerBalanceNumber),
+	}
+	if receiver.PayeeBalanceNumber != 0 {
+		lines = append(lines, fmt.Sprintf("To:        %d", receiver.PayeeBalanceNumber))
+	}
+	if receiver.ServiceName != "" {
+		lines = append(lines, fmt.Sprintf("Service:   %s", receiver.ServiceName))
+	}
+	if receiver.Reference != "" {
+		lines = append(lines, fmt.Sprintf("Reference: %s", receiver.Reference))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Amount:    %s", formatMoney(receiver.Amount)),
+		fmt.Sprintf("Fee:       %s", formatMoney(receiver.Fee)),
+		fmt.Sprintf("Balance:   %s", formatMoney(receiver.BalanceAfter)),
+	)
+
+	for _, line := range lines {
+		_, err = fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (receiver Receipt) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(receiver)
+}
+
+func (receiver Receipt) WriteHTML(w io.Writer) error {
+	return receiptTemplate.Execute(w, receiver)
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTransferByBalanceNumber_ReceiptPersisted(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddClients(Client{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	receipt, err := TransferByBalanceNumber(1001, 40, Client{BalanceNumber: 1002, Balance: 40}, db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+	if receipt.Amount != 40 || receipt.BalanceAfter != 60 || receipt.PayeeBalanceNumber != 1002 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	stored, err := GetReceipt(receipt.Number, db)
+	if err != nil {
+		t.Fatalf("can't get receipt: %v", err)
+	}
+	if stored != receipt {
+		t.Errorf("stored receipt %+v differs from issued %+v", stored, receipt)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = stored.WriteHTML(buffer)
+	if err != nil {
+		t.Fatalf("can't render receipt: %v", err)
+	}
+	if !strings.Contains(buffer.String(), receipt.Number) || !strings.Contains(buffer.String(), "0.40 TJS") {
+		t.Errorf("receipt number not rendered: %s", buffer.String())
+	}
+}
+
+func TestTransferByBalanceNumber_UnknownPayeeKeepsMoney(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	_, err = TransferByBalanceNumber(1001, 40, Client{BalanceNumber: 9999, Balance: 40}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for unknown payee: %v", err)
+	}
+	balances, err := GetBalanceList(db, 1)
+	if err != nil {
+		t.Fatalf("can't get balance: %v", err)
+	}
+	if balances[0].Balance != 100 {
+		t.Errorf("money lost on failed transfer: %v", balances[0].Balance)
+	}
+
+	_, err = TransferByPhoneNumber(1001, 101, Client{PhoneNumber: 992900000001, Balance: 101}, db)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("Not ErrInsufficientFunds for transfer over balance: %v", err)
+	}
+	_, err = TransferByBalanceNumber(9999, 1, Client{BalanceNumber: 1001, Balance: 1}, db)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Not ErrNotFound for transfer from unknown account: %v", err)
+	}
+}
+
+func TestReceipt_TimeInUTC(t *testing.T) {
+	receipt := Receipt{Number: receiptNumber(7, 1577833200), Kind: TransactionTransfer, CreatedAt: 1577833200}
+	if receipt.Number != "R20191231-000000007" {
+		t.Errorf("unexpected receipt number: %s", receipt.Number)
+	}
+	if receipt.Time() != "31.12.2019 23:00:00 UTC" {
+		t.Errorf("unexpected receipt time: %s", receipt.Time())
+	}
+
+	buffer := &bytes.Buffer{}
+	err := receipt.WriteText(buffer)
+	if err != nil {
+		t.Fatalf("can't write receipt: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "31.12.2019 23:00:00 UTC") || !strings.Contains(buffer.String(), "Fee:       "+formatMoney(0)) {
+		t.Errorf("unexpected receipt text: %s", buffer.String())
+	}
+}
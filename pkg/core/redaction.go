@@ -0,0 +1,273 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+const (
+	MaskNone = ""
+	// MaskLast4 keeps last 4 characters, e.g. 992900001234 becomes ********1234,
+	// values shorter than 8 characters keep at most half of them, e.g. 1234 becomes **34
+	MaskLast4 = "last4"
+	// MaskHash replaces value with stable pseudonym keyed by profile Secret so records can still be joined,
+	// values can't be guessed back without the secret
+	MaskHash = "hash"
+	// MaskRedact replaces value with empty string
+	MaskRedact = "redact"
+)
+
+var ErrUnknownField = errors.New("unknown export field")
+var ErrUnknownMask = errors.New("unknown mask")
+var ErrMissingMaskSecret = errors.New("profile secret required for hash mask")
+
+// ExportField is field of entity named as in CSV header, e.g. "phone_number"
+type ExportField struct {
+	Name string
+	Mask string
+}
+
+// ExportProfile chooses fields per entity, nil list exports all fields as is.
+// Statements lists masks of owner, counterparty and reference in statements and account history,
+// other fields of statements are required by formats and exported as is. Secret keys MaskHash.
+type ExportProfile struct {
+	Name       string
+	Clients    []ExportField
+	Atms       []ExportField
+	Services   []ExportField
+	Statements []ExportField
+	Secret     []byte
+}
+
+// ProfileFull exports every field of CSV including passwords, use it only for backups.
+// Unlike exports without profile it omits version of records
+var ProfileFull = ExportProfile{
+	Name: "full",
+	Clients: []ExportField{
+		{Name: "id"},
+		{Name: "name"},
+		{Name: "login"},
+		{Name: "password"},
+		{Name: "balance"},
+		{Name: "balance_number"},
+		{Name: "phone_number"},
+	},
+}
+
+// ProfileAnalytics has no names and credentials, account numbers are masked,
+// copy it with Secret set before use
+var ProfileAnalytics = ExportProfile{
+	Name: "analytics",
+	Clients: []ExportField{
+		{Name: "id", Mask: MaskHash},
+		{Name: "balance"},
+		{Name: "balance_number", Mask: MaskLast4},
+		{Name: "phone_number", Mask: MaskLast4},
+	},
+	Statements: []ExportField{
+		{Name: "owner", Mask: MaskRedact},
+		{Name: "counterparty", Mask: MaskRedact},
+		{Name: "reference", Mask: MaskRedact},
+	},
+}
+
+// ProfileRegulator has identity and accounts of clients without credentials
+var ProfileRegulator = ExportProfile{
+	Name: "regulator",
+	Clients: []ExportField{
+		{Name: "id"},
+		{Name: "name"},
+		{Name: "balance"},
+		{Name: "balance_number"},
+		{Name: "phone_number"},
+	},
+}
+
+func (receiver ExportProfile) mask(value interface{}, rule string) (interface{}, error) {
+	switch rule {
+	case MaskNone:
+		return value, nil
+	case MaskRedact:
+		return "", nil
+	case MaskHash:
+		if len(receiver.Secret) == 0 {
+			return nil, ErrMissingMaskSecret
+		}
+		mac := hmac.New(sha256.New, receiver.Secret)
+		mac.Write([]byte(formatCSVValue(value)))
+		return hex.EncodeToString(mac.Sum(nil)[:8]), nil
+	case MaskLast4:
+		runes := []rune(formatCSVValue(value))
+		keep := 4
+		if len(runes)/2 < keep {
+			keep = len(runes) / 2
+		}
+		for index := 0; index < len(runes)-keep; index++ {
+			runes[index] = '*'
+		}
+		return string(runes), nil
+	}
+	return nil, ErrUnknownMask
+}
+
+// statementMask returns rule of statement field, fields are checked by checkStatementFields
+func (receiver ExportProfile) statementMask(field string) string {
+	for _, candidate := range receiver.Statements {
+		if candidate.Name == field {
+			return candidate.Mask
+		}
+	}
+	return MaskNone
+}
+
+// checkStatementFields allows owner, counterparty and reference, counterparty is account number
+// which can only be redacted
+func (receiver ExportProfile) checkStatementFields() error {
+	for _, field := range receiver.Statements {
+		switch field.Name {
+		case "owner", "reference":
+		case "counterparty":
+			if field.Mask != MaskNone && field.Mask != MaskRedact {
+				return fmt.Errorf("%w: %s for counterparty", ErrUnknownMask, field.Mask)
+			}
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownField, field.Name)
+		}
+		if _, err := receiver.mask("", field.Mask); err != nil {
+			return fmt.Errorf("%w: %s", err, field.Mask)
+		}
+	}
+	return nil
+}
+
+// maskString masks text field of statement
+func (receiver ExportProfile) maskString(value string, field string) (string, error) {
+	masked, err := receiver.mask(value, receiver.statementMask(field))
+	if err != nil {
+		return "", err
+	}
+	return masked.(string), nil
+}
+
+// exportRecord keeps chosen fields in profile order in every format
+type exportRecord struct {
+	fields []string
+	values []interface{}
+}
+
+func (receiver exportRecord) MarshalJSON() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte('{')
+	for index, field := range receiver.fields {
+		if index > 0 {
+			buffer.WriteByte(',')
+		}
+		name, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(receiver.values[index])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func (receiver exportRecord) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	err := encoder.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	for index, field := range receiver.fields {
+		err = encoder.EncodeElement(receiver.values[index], xml.StartElement{Name: xml.Name{Local: field}})
+		if err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// withProfile returns entity which maps rows to records with fields chosen by profile,
+// credentials are exported only when profile chooses them
+func (receiver exchangeEntity) withProfile(profile *ExportProfile) (exchangeEntity, error) {
+	var fields []ExportField
+	if profile != nil {
+		switch receiver.field {
+		case clientsExchange.field:
+			fields = profile.Clients
+		case atmsExchange.field:
+			fields = profile.Atms
+		case servicesExchange.field:
+			fields = profile.Services
+		}
+	}
+	if fields == nil {
+		if receiver.redact == nil {
+			return receiver, nil
+		}
+		mapRow, redact := receiver.mapRow, receiver.redact
+		receiver.mapRow = func(rows *sql.Rows) (interface{}, error) {
+			item, err := mapRow(rows)
+			if err != nil {
+				return nil, err
+			}
+			return redact(item), nil
+		}
+		return receiver, nil
+	}
+
+	spec := receiver.csv
+	positions := make([]int, len(fields))
+	names := make([]string, len(fields))
+	for index, field := range fields {
+		positions[index] = -1
+		for position, name := range spec.fields {
+			if name == field.Name {
+				positions[index] = position
+			}
+		}
+		if positions[index] < 0 {
+			return exchangeEntity{}, fmt.Errorf("%w: %s", ErrUnknownField, field.Name)
+		}
+		if _, err := profile.mask("", field.Mask); err != nil {
+			return exchangeEntity{}, fmt.Errorf("%w: %s", err, field.Mask)
+		}
+		names[index] = field.Name
+	}
+
+	mapRow := receiver.mapRow
+	receiver.mapRow = func(rows *sql.Rows) (interface{}, error) {
+		item, err := mapRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		all := spec.values(item)
+		record := exportRecord{fields: names, values: make([]interface{}, len(fields))}
+		for index, field := range fields {
+			record.values[index], err = profile.mask(all[positions[index]], field.Mask)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return record, nil
+	}
+	receiver.csv = csvSpec{
+		fields: names,
+		values: func(item interface{}) []interface{} {
+			return item.(exportRecord).values
+		},
+	}
+	return receiver, nil
+}
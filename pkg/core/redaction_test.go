@@ -0,0 +1,234 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestExportClientsWithOptions_Profiles(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 20001001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	analytics := ProfileAnalytics
+	analytics.Secret = []byte("profile secret")
+	buffer := &bytes.Buffer{}
+	err = ExportClientsWithOptions(buffer, FormatJSON, ExportOptions{Profile: &analytics}, db)
+	if err != nil {
+		t.Fatalf("can't export json: %v", err)
+	}
+	if !strings.Contains(buffer.String(), `"balance":100,"balance_number":"****1001","phone_number":"********0001"}]}`) {
+		t.Errorf("unexpected json: %s", buffer)
+	}
+
+	buffer.Reset()
+	err = ExportClientsWithOptions(buffer, FormatXML, ExportOptions{Profile: &ProfileRegulator}, db)
+	if err != nil {
+		t.Fatalf("can't export xml: %v", err)
+	}
+	expected := `<clients version="1"><client><id>1</id><name>Vasya</name><balance>100</balance>` +
+		`<balance_number>20001001</balance_number><phone_number>992900000001</phone_number></client></clients>`
+	if buffer.String() != expected {
+		t.Errorf("unexpected xml: %s", buffer)
+	}
+
+	buffer.Reset()
+	err = ExportClientsWithOptions(buffer, FormatCSV, ExportOptions{Profile: &analytics}, db)
+	if err != nil {
+		t.Fatalf("can't export csv: %v", err)
+	}
+	if !strings.HasPrefix(buffer.String(), "id,balance,balance_number,phone_number\n") || strings.Contains(buffer.String(), "secret") {
+		t.Errorf("unexpected csv: %s", buffer)
+	}
+
+	profile := ExportProfile{Clients: []ExportField{{Name: "pin"}}}
+	err = ExportClientsWithOptions(buffer, FormatJSON, ExportOptions{Profile: &profile}, db)
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Not ErrUnknownField for pin: %v", err)
+	}
+	err = ExportClientsWithOptions(buffer, FormatJSON, ExportOptions{Profile: &ProfileAnalytics}, db)
+	if !errors.Is(err, ErrMissingMaskSecret) {
+		t.Errorf("Not ErrMissingMaskSecret for hash without secret: %v", err)
+	}
+}
+
+func TestExportProfile_Mask(t *testing.T) {
+	profile := ExportProfile{Secret: []byte("profile secret")}
+	values := map[string]string{"20001001": "****1001", "1234": "**34", "123": "**3", "7": "*", "": ""}
+	for value, expected := range values {
+		masked, err := profile.mask(value, MaskLast4)
+		if err != nil || masked != expected {
+			t.Errorf("unexpected last4 of %q: %v %v", value, masked, err)
+		}
+	}
+
+	first, err := profile.mask(int64(1), MaskHash)
+	if err != nil {
+		t.Fatalf("can't hash: %v", err)
+	}
+	again, _ := profile.mask(int64(1), MaskHash)
+	other, _ := ExportProfile{Secret: []byte("other secret")}.mask(int64(1), MaskHash)
+	if first != again || first == other {
+		t.Errorf("hash is not keyed by secret: %v %v %v", first, again, other)
+	}
+	_, err = ExportProfile{}.mask(int64(1), MaskHash)
+	if err != ErrMissingMaskSecret {
+		t.Errorf("Not ErrMissingMaskSecret: %v", err)
+	}
+}
+
+func TestGenerateStatementWithProfile_MasksStatement(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	analytics := ProfileAnalytics
+	analytics.Secret = []byte("profile secret")
+
+	buffer := &bytes.Buffer{}
+	err := GenerateStatementWithProfile(1001, time.Unix(0, 0), time.Now().Add(time.Hour), FormatJSON, &analytics, buffer, db)
+	if err != nil {
+		t.Fatalf("can't generate statement: %v", err)
+	}
+	statement := Statement{}
+	err = json.Unmarshal(buffer.Bytes(), &statement)
+	if err != nil {
+		t.Fatalf("invalid statement: %v", err)
+	}
+	if statement.Owner != "" || len(statement.Entries) != 2 {
+		t.Fatalf("unexpected statement: %s", buffer)
+	}
+	for _, entry := range statement.Entries {
+		if entry.Counterparty != 0 || entry.Reference != "" {
+			t.Errorf("entry not masked: %+v", entry)
+		}
+	}
+
+	data := readFinanceExport(t, func(balanceNumber uint64, filename string, db *sql.DB) error {
+		return ExportTransactionsToQIFWithProfile(balanceNumber, filename, &analytics, db)
+	}, db)
+	if strings.Contains(data, "1002") || strings.Contains(data, "9001") || !strings.Contains(data, "PTransfer out\n") {
+		t.Errorf("unexpected qif: %q", data)
+	}
+
+	profile := ExportProfile{Statements: []ExportField{{Name: "counterparty", Mask: MaskLast4}}}
+	err = GenerateStatementWithProfile(1001, time.Unix(0, 0), time.Now(), FormatJSON, &profile, buffer, db)
+	if !errors.Is(err, ErrUnknownMask) {
+		t.Errorf("Not ErrUnknownMask for last4 of counterparty: %v", err)
+	}
+}
+
+func TestBackupWithProfile_MasksAndCantBeRestored(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	analytics := ProfileAnalytics
+	analytics.Secret = []byte("profile secret")
+
+	buffer := &bytes.Buffer{}
+	err := BackupWithProfile(buffer, SensitiveRedact, &analytics, db)
+	if err != nil {
+		t.Fatalf("can't backup: %v", err)
+	}
+	manifest, err := ReadBackupManifest(bytes.NewReader(buffer.Bytes()))
+	if err != nil || manifest.Profile != "analytics" {
+		t.Errorf("unexpected manifest: %+v %v", manifest, err)
+	}
+
+	decompressor, err := gzip.NewReader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("can't read backup: %v", err)
+	}
+	archive := tar.NewReader(decompressor)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatalf("can't read backup: %v", err)
+		}
+		switch header.Name {
+		case "client.json":
+			if strings.Contains(string(data), "Vasya") || !strings.Contains(string(data), `"**01"`) {
+				t.Errorf("clients not masked: %s", data)
+			}
+		case "transactions.json":
+			if strings.Contains(string(data), ",1002,") || strings.Contains(string(data), "Type:Cat") {
+				t.Errorf("transactions not masked: %s", data)
+			}
+		}
+	}
+
+	target := openInitDB(t)
+	defer func() {
+		if err := target.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Restore(bytes.NewReader(buffer.Bytes()), target)
+	if err != ErrMaskedBackup {
+		t.Errorf("Not ErrMaskedBackup: %v", err)
+	}
+}
+
+func TestBackupWithProfile_RedactsSecretsAndReferences(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddWebhook(Webhook{URL: "https://example.com/hook", Secret: "hook-key-42", Events: []string{EventLoginLocked}}, db)
+	if err != nil {
+		t.Fatalf("can't add webhook: %v", err)
+	}
+	_, err = PayForServices(1001, 10, "900123456", Services{Id: 1}, db)
+	if err != nil {
+		t.Fatalf("can't pay: %v", err)
+	}
+	analytics := ProfileAnalytics
+	analytics.Secret = []byte("profile secret")
+
+	buffer := &bytes.Buffer{}
+	err = BackupWithProfile(buffer, SensitiveInclude, &analytics, db)
+	if err != nil {
+		t.Fatalf("can't backup: %v", err)
+	}
+	decompressor, err := gzip.NewReader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("can't read backup: %v", err)
+	}
+	archive, err := ioutil.ReadAll(decompressor)
+	if err != nil {
+		t.Fatalf("can't read backup: %v", err)
+	}
+	for _, plain := range []string{`"1212"`, `"1313"`, "hook-key-42", "900123456", "Type:Cat"} {
+		if strings.Contains(string(archive), plain) {
+			t.Errorf("backup contains %s", plain)
+		}
+	}
+}
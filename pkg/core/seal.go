@@ -0,0 +1,417 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math"
+)
+
+const (
+	sealVersion    = 1
+	sealEncrypted  = 1
+	sealHMAC       = 2
+	sealEd25519    = 4
+	sealPassphrase = 1
+	sealSaltLength = 16
+	// sealIterations of PBKDF2-HMAC-SHA256 for passphrase keys, stored in file so it can grow later
+	sealIterations = 200000
+	// sealMaxIterations bounds work done for iteration count read from file
+	sealMaxIterations = 10 * sealIterations
+	sealFlags         = sealEncrypted | sealHMAC | sealEd25519
+	// sealChunkSize of plain text is encrypted at once, so sealing needs memory for one chunk
+	sealChunkSize = 64 * 1024
+	// sealNonceSuffix is sequence number and last chunk flag at the end of nonce
+	sealNonceSuffix = 5
+)
+
+var sealMagic = []byte("MCSEAL")
+
+var ErrNotSealed = errors.New("file is not sealed")
+var ErrMissingKey = errors.New("key required to open sealed file")
+var ErrInvalidSignature = errors.New("invalid signature")
+var ErrDecryptionFailed = errors.New("can't decrypt file: wrong key or damaged file")
+var ErrUnsupportedSeal = errors.New("unsupported sealed file parameters")
+
+// SealOptions protects export files. Key (16, 24 or 32 bytes) or Passphrase encrypts with AES-GCM,
+// HMACKey and SigningKey sign on export, HMACKey and VerifyKey check signatures on import.
+// Files are encrypted first and then signed, zero options leave data as is. Exports are sealed
+// while they are written and Ed25519 signs SHA-512 of file, Open needs whole file in memory.
+type SealOptions struct {
+	Key        []byte
+	Passphrase string
+	HMACKey    []byte
+	SigningKey ed25519.PrivateKey
+	VerifyKey  ed25519.PublicKey
+}
+
+func (receiver SealOptions) encrypts() bool {
+	return receiver.Key != nil || receiver.Passphrase != ""
+}
+
+func (receiver SealOptions) empty() bool {
+	return !receiver.encrypts() && receiver.HMACKey == nil && receiver.SigningKey == nil && receiver.VerifyKey == nil
+}
+
+// pbkdf2SHA256 derives key as in RFC 8018, standard library of Go 1.13 has no PBKDF2
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLength + prf.Size() - 1) / prf.Size()
+	key := make([]byte, 0, blocks*prf.Size())
+	sum := make([]byte, 0, prf.Size())
+	for block := uint32(1); block <= uint32(blocks); block++ {
+		prf.Reset()
+		prf.Write(salt)
+		_ = binary.Write(prf, binary.BigEndian, block)
+		sum = prf.Sum(sum[:0])
+		result := append([]byte{}, sum...)
+		for iteration := 1; iteration < iterations; iteration++ {
+			prf.Reset()
+			prf.Write(sum)
+			sum = prf.Sum(sum[:0])
+			for index := range result {
+				result[index] ^= sum[index]
+			}
+		}
+		key = append(key, result...)
+	}
+	return key[:keyLength]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and signs data according to options
+func Seal(data []byte, options SealOptions) ([]byte, error) {
+	if options.empty() {
+		return data, nil
+	}
+
+	sealed := bytes.NewBuffer(nil)
+	writer, err := newSealWriter(sealed, options)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// sealWriter seals data written to it without keeping it: plain text is encrypted in chunks
+// of sealChunkSize, nonce of chunk is random prefix from header, chunk sequence number and
+// flag of last chunk, so chunks can't be reordered, dropped or truncated. HMAC covers
+// everything written and Ed25519 signs SHA-512 of it, Close writes last chunk and signatures.
+type sealWriter struct {
+	w          io.Writer
+	gcm        cipher.AEAD
+	header     []byte
+	prefix     []byte
+	sequence   uint32
+	chunk      []byte
+	mac        hash.Hash
+	digest     hash.Hash
+	signingKey ed25519.PrivateKey
+}
+
+func newSealWriter(w io.Writer, options SealOptions) (*sealWriter, error) {
+	receiver := &sealWriter{w: w, signingKey: options.SigningKey}
+	var flags byte
+	if options.encrypts() {
+		flags |= sealEncrypted
+	}
+	if options.HMACKey != nil {
+		flags |= sealHMAC
+		receiver.mac = hmac.New(sha256.New, options.HMACKey)
+	}
+	if options.SigningKey != nil {
+		flags |= sealEd25519
+		receiver.digest = sha512.New()
+	}
+	header := bytes.NewBuffer(nil)
+	header.Write(sealMagic)
+	header.WriteByte(sealVersion)
+	header.WriteByte(flags)
+
+	if options.encrypts() {
+		key := options.Key
+		if key == nil {
+			salt := make([]byte, sealSaltLength)
+			_, err := io.ReadFull(rand.Reader, salt)
+			if err != nil {
+				return nil, err
+			}
+			key = pbkdf2SHA256([]byte(options.Passphrase), salt, sealIterations, 32)
+			header.WriteByte(sealPassphrase)
+			header.Write(salt)
+			_ = binary.Write(header, binary.BigEndian, uint32(sealIterations))
+		} else {
+			header.WriteByte(0)
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		receiver.gcm = gcm
+		receiver.prefix = make([]byte, gcm.NonceSize()-sealNonceSuffix)
+		_, err = io.ReadFull(rand.Reader, receiver.prefix)
+		if err != nil {
+			return nil, err
+		}
+		header.Write(receiver.prefix)
+		receiver.header = header.Bytes()
+	}
+	err := receiver.write(header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return receiver, nil
+}
+
+// sealNonce is prefix, big endian sequence number and 1 for last chunk
+func sealNonce(prefix []byte, sequence uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+sealNonceSuffix)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], sequence)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func (receiver *sealWriter) write(data []byte) error {
+	_, err := receiver.w.Write(data)
+	if err != nil {
+		return err
+	}
+	if receiver.mac != nil {
+		receiver.mac.Write(data)
+	}
+	if receiver.digest != nil {
+		receiver.digest.Write(data)
+	}
+	return nil
+}
+
+func (receiver *sealWriter) sealChunk(last bool) error {
+	size := len(receiver.chunk)
+	if !last {
+		size = sealChunkSize
+	}
+	if receiver.sequence == math.MaxUint32 {
+		return ErrUnsupportedSeal
+	}
+	nonce := sealNonce(receiver.prefix, receiver.sequence, last)
+	receiver.sequence++
+	err := receiver.write(receiver.gcm.Seal(nil, nonce, receiver.chunk[:size], receiver.header))
+	if err != nil {
+		return err
+	}
+	receiver.chunk = append(receiver.chunk[:0], receiver.chunk[size:]...)
+	return nil
+}
+
+// Write keeps at most one chunk, chunk is sealed when more data follows it
+func (receiver *sealWriter) Write(p []byte) (n int, err error) {
+	if receiver.gcm == nil {
+		err = receiver.write(p)
+		if err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	receiver.chunk = append(receiver.chunk, p...)
+	for len(receiver.chunk) > sealChunkSize {
+		err = receiver.sealChunk(false)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes last chunk and signatures, it doesn't close underlying writer
+func (receiver *sealWriter) Close() error {
+	if receiver.gcm != nil {
+		err := receiver.sealChunk(true)
+		if err != nil {
+			return err
+		}
+	}
+	if receiver.mac != nil {
+		sum := receiver.mac.Sum(nil)
+		receiver.mac = nil
+		err := receiver.write(sum)
+		if err != nil {
+			return err
+		}
+	}
+	if receiver.signingKey != nil {
+		_, err := receiver.w.Write(ed25519.Sign(receiver.signingKey, receiver.digest.Sum(nil)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open checks signatures and decrypts data sealed by Seal, every key in options
+// is required, so unsigned or unencrypted file is rejected when key is given
+func Open(data []byte, options SealOptions) ([]byte, error) {
+	if !bytes.HasPrefix(data, sealMagic) {
+		if options.empty() {
+			return data, nil
+		}
+		return nil, ErrNotSealed
+	}
+	if len(data) < len(sealMagic)+2 || data[len(sealMagic)] != sealVersion {
+		return nil, ErrNotSealed
+	}
+	flags := data[len(sealMagic)+1]
+	if flags&^sealFlags != 0 {
+		return nil, ErrUnsupportedSeal
+	}
+
+	if flags&sealEd25519 != 0 || options.VerifyKey != nil {
+		if flags&sealEd25519 == 0 || options.VerifyKey == nil {
+			return nil, ErrMissingKey
+		}
+		if len(data) < ed25519.SignatureSize {
+			return nil, ErrInvalidSignature
+		}
+		signed, signature := data[:len(data)-ed25519.SignatureSize], data[len(data)-ed25519.SignatureSize:]
+		digest := sha512.Sum512(signed)
+		if !ed25519.Verify(options.VerifyKey, digest[:], signature) {
+			return nil, ErrInvalidSignature
+		}
+		data = signed
+	}
+	if flags&sealHMAC != 0 || options.HMACKey != nil {
+		if flags&sealHMAC == 0 || options.HMACKey == nil {
+			return nil, ErrMissingKey
+		}
+		if len(data) < sha256.Size {
+			return nil, ErrInvalidSignature
+		}
+		signed, signature := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+		mac := hmac.New(sha256.New, options.HMACKey)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, ErrInvalidSignature
+		}
+		data = signed
+	}
+
+	body := data[len(sealMagic)+2:]
+	if flags&sealEncrypted == 0 && !options.encrypts() {
+		return body, nil
+	}
+	if flags&sealEncrypted == 0 || !options.encrypts() {
+		return nil, ErrMissingKey
+	}
+	if len(body) < 1 {
+		return nil, ErrDecryptionFailed
+	}
+	key := options.Key
+	keySource, body := body[0], body[1:]
+	if keySource == sealPassphrase {
+		if len(body) < sealSaltLength+4 {
+			return nil, ErrDecryptionFailed
+		}
+		salt := body[:sealSaltLength]
+		iterations := binary.BigEndian.Uint32(body[sealSaltLength:])
+		body = body[sealSaltLength+4:]
+		if options.Passphrase == "" {
+			return nil, ErrMissingKey
+		}
+		if iterations < sealIterations || iterations > sealMaxIterations {
+			return nil, ErrUnsupportedSeal
+		}
+		key = pbkdf2SHA256([]byte(options.Passphrase), salt, int(iterations), 32)
+	}
+	if key == nil {
+		return nil, ErrMissingKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefixSize := gcm.NonceSize() - sealNonceSuffix
+	if len(body) < prefixSize {
+		return nil, ErrDecryptionFailed
+	}
+	header := data[:len(data)-len(body)+prefixSize]
+	prefix, body := body[:prefixSize], body[prefixSize:]
+	chunkSize := sealChunkSize + gcm.Overhead()
+	plain := make([]byte, 0, len(body))
+	for sequence := uint32(0); ; sequence++ {
+		last := len(body) <= chunkSize
+		size := chunkSize
+		if last {
+			size = len(body)
+		}
+		plain, err = gcm.Open(plain, sealNonce(prefix, sequence, last), body[:size], header)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		body = body[size:]
+		if last {
+			return plain, nil
+		}
+	}
+}
+
+// SealMarshaller seals output of marshal, used with ExportToFile
+func SealMarshaller(marshal Marshaller, options SealOptions) Marshaller {
+	return func(v interface{}) ([]byte, error) {
+		data, err := marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return Seal(data, options)
+	}
+}
+
+// OpenMapper opens sealed file before mapBytes, used with ImportFromFile and ImportFromFileTx
+func OpenMapper(mapBytes MapperBytesTo, options SealOptions) MapperBytesTo {
+	return func(data []byte) ([]interface{}, error) {
+		data, err := Open(data, options)
+		if err != nil {
+			return nil, err
+		}
+		return mapBytes(data)
+	}
+}
+
+// ExportClientsSealed writes clients encrypted and signed, read it back with ImportOptions.Seal
+func ExportClientsSealed(w io.Writer, format string, options SealOptions, db *sql.DB) error {
+	return clientsExchange.export(w, format, ExportOptions{Seal: options}, db)
+}
+
+func ExportAtmsSealed(w io.Writer, format string, options SealOptions, db *sql.DB) error {
+	return atmsExchange.export(w, format, ExportOptions{Seal: options}, db)
+}
+
+func ExportServicesSealed(w io.Writer, format string, options SealOptions, db *sql.DB) error {
+	return servicesExchange.export(w, format, ExportOptions{Seal: options}, db)
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestPbkdf2SHA256(t *testing.T) {
+	vectors := []struct {
+		iterations int
+		expected   string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, vector := range vectors {
+		key := pbkdf2SHA256([]byte("password"), []byte("salt"), vector.iterations, 32)
+		if hex.EncodeToString(key) != vector.expected {
+			t.Errorf("unexpected key for %d iterations: %x", vector.iterations, key)
+		}
+	}
+}
+
+func TestSeal_OpenRejectsTampering(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	options := SealOptions{Passphrase: "correct horse", HMACKey: []byte("hmac key"), SigningKey: private, VerifyKey: public}
+	plain := []byte(`{"Clients":[{"Login":"vasya","Password":"secret"}]}`)
+
+	sealed, err := Seal(plain, options)
+	if err != nil {
+		t.Fatalf("can't seal: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("sealed data is not encrypted")
+	}
+	opened, err := Open(sealed, options)
+	if err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("can't open: %s %v", opened, err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(sealMagic)+10] ^= 1
+	_, err = Open(tampered, options)
+	if err != ErrInvalidSignature {
+		t.Errorf("Not ErrInvalidSignature for tampered data: %v", err)
+	}
+	_, err = Open(sealed, SealOptions{Passphrase: "wrong", HMACKey: options.HMACKey, VerifyKey: public})
+	if err != ErrDecryptionFailed {
+		t.Errorf("Not ErrDecryptionFailed for wrong passphrase: %v", err)
+	}
+	_, err = Open(sealed, SealOptions{})
+	if err != ErrMissingKey {
+		t.Errorf("Not ErrMissingKey without keys: %v", err)
+	}
+	_, err = Open(plain, SealOptions{HMACKey: options.HMACKey})
+	if err != ErrNotSealed {
+		t.Errorf("Not ErrNotSealed for plain data: %v", err)
+	}
+}
+
+func TestOpen_RejectsUnsupportedParameters(t *testing.T) {
+	options := SealOptions{Passphrase: "correct horse"}
+	sealed, err := Seal([]byte("data"), options)
+	if err != nil {
+		t.Fatalf("can't seal: %v", err)
+	}
+
+	offset := len(sealMagic) + 3 + sealSaltLength
+	for _, iterations := range []uint32{0, sealIterations - 1, sealMaxIterations + 1, 1<<32 - 1} {
+		changed := append([]byte{}, sealed...)
+		binary.BigEndian.PutUint32(changed[offset:], iterations)
+		_, err = Open(changed, options)
+		if err != ErrUnsupportedSeal {
+			t.Errorf("Not ErrUnsupportedSeal for %d iterations: %v", iterations, err)
+		}
+	}
+	changed := append([]byte{}, sealed...)
+	changed[len(sealMagic)+1] |= 8
+	_, err = Open(changed, options)
+	if err != ErrUnsupportedSeal {
+		t.Errorf("Not ErrUnsupportedSeal for unknown flag: %v", err)
+	}
+}
+
+func TestSeal_ChunksCantBeDroppedOrReordered(t *testing.T) {
+	options := SealOptions{Key: bytes.Repeat([]byte{7}, 32)}
+	plain := bytes.Repeat([]byte("0123456789"), sealChunkSize/4)
+	buffer := &bytes.Buffer{}
+	writer, err := newSealWriter(buffer, options)
+	if err != nil {
+		t.Fatalf("can't create writer: %v", err)
+	}
+	for start := 0; start < len(plain); start += 1000 {
+		end := start + 1000
+		if end > len(plain) {
+			end = len(plain)
+		}
+		_, err = writer.Write(plain[start:end])
+		if err != nil {
+			t.Fatalf("can't write: %v", err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("can't close writer: %v", err)
+	}
+	sealed := buffer.Bytes()
+	opened, err := Open(sealed, options)
+	if err != nil || !bytes.Equal(opened, plain) {
+		t.Fatalf("can't open chunks: %d %v", len(opened), err)
+	}
+
+	// magic, version, flags, key source and nonce prefix come before 3 chunks
+	header := len(sealMagic) + 3 + 7
+	chunk := sealChunkSize + 16
+	first, second, last := sealed[header:header+chunk], sealed[header+chunk:header+2*chunk], sealed[header+2*chunk:]
+	reordered := append([]byte{}, sealed[:header]...)
+	reordered = append(append(append(reordered, second...), first...), last...)
+	for name, changed := range map[string][]byte{
+		"dropped last chunk": sealed[:header+2*chunk],
+		"reordered chunks":   reordered,
+	} {
+		_, err = Open(changed, options)
+		if err != ErrDecryptionFailed {
+			t.Errorf("Not ErrDecryptionFailed for %s: %v", name, err)
+		}
+	}
+}
+
+func TestExportClientsWithOptions_SealedImport(t *testing.T) {
+	source := openInitDB(t)
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, source)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+
+	options := SealOptions{Key: bytes.Repeat([]byte{7}, 32), HMACKey: []byte("hmac key")}
+	buffer := &bytes.Buffer{}
+	err = ExportClientsWithOptions(buffer, FormatXML, ExportOptions{Profile: &ProfileFull, Seal: options}, source)
+	if err != nil {
+		t.Fatalf("can't export: %v", err)
+	}
+
+	target := openInitDB(t)
+	defer func() {
+		if err := target.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	_, err = ImportClientsWithOptions(bytes.NewReader(buffer.Bytes()), FormatXML, ImportOptions{}, target)
+	if err != ErrMissingKey {
+		t.Errorf("Not ErrMissingKey for sealed file: %v", err)
+	}
+	report, err := ImportClientsWithOptions(bytes.NewReader(buffer.Bytes()), FormatXML, ImportOptions{Seal: options}, target)
+	if err != nil || report.Accepted != 1 {
+		t.Errorf("can't import sealed file: %+v %v", report, err)
+	}
+}
@@ -0,0 +1,358 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SettlementPending = "pending"
+	SettlementPaid    = "paid"
+	SettlementFailed  = "failed"
+)
+
+var ErrInvalidSettlementPeriod = errors.New("invalid settlement period")
+var ErrSettlementClosed = errors.New("settlement is already paid or failed")
+var ErrSettlementMismatch = errors.New("service balance is less than unsettled payments")
+
+// Settlement is batch of service payments transferred to provider, periods are unix time
+type Settlement struct {
+	Id          int64
+	ServiceId   int64
+	Amount      uint64
+	PeriodStart int64
+	PeriodEnd   int64
+	Status      string
+	CreatedAt   int64
+	Payments    []Transaction
+}
+
+type dueService struct {
+	id           int64
+	settledUntil int64
+}
+
+// SettlementError lists services skipped by CreateSettlements, settlements of other services are committed
+type SettlementError struct {
+	ServiceIds []int64
+}
+
+func (receiver *SettlementError) Error() string {
+	ids := make([]string, len(receiver.ServiceIds))
+	for index, id := range receiver.ServiceIds {
+		ids[index] = strconv.FormatInt(id, 10)
+	}
+	return fmt.Sprintf("%v: services %s", ErrSettlementMismatch, strings.Join(ids, ", "))
+}
+
+func (receiver *SettlementError) Unwrap() error {
+	return ErrSettlementMismatch
+}
+
+// CreateSettlements moves unsettled payments of every service whose settlement period
+// (in days) has passed into pending batches and takes batch amounts off service balances.
+// Payments credited service balance with the same amounts, so opening balance stays and
+// balance smaller than batch means they don't match: such service is skipped and reported
+// by SettlementError, which is returned together with settlements of other services.
+func CreateSettlements(now time.Time, db *sql.DB) (settlements []Settlement, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var skipped []int64
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			settlements = nil
+			return
+		}
+		if len(skipped) != 0 {
+			err = &SettlementError{ServiceIds: skipped}
+		}
+	}()
+
+	due, err := getDueServices(now.Unix(), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range due {
+		settlement, ok, err := createSettlement(service, now.Unix(), tx)
+		if errors.Is(err, ErrSettlementMismatch) {
+			skipped = append(skipped, service.id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			settlements = append(settlements, settlement)
+		}
+	}
+
+	return settlements, nil
+}
+
+func getDueServices(now int64, tx *sql.Tx) (due []dueService, err error) {
+	rows, err := tx.Query(getDueServicesSQL, now)
+	if err != nil {
+		return nil, queryError(getDueServicesSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			due, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		service := dueService{}
+		err = rows.Scan(&service.id, &service.settledUntil)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		due = append(due, service)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+
+	return due, nil
+}
+
+func createSettlement(service dueService, now int64, tx *sql.Tx) (settlement Settlement, ok bool, err error) {
+	var count int64
+	var amount uint64
+	err = tx.QueryRow(sumUnsettledPaymentsSQL, service.id, now).Scan(&count, &amount)
+	if err != nil {
+		return Settlement{}, false, queryError(sumUnsettledPaymentsSQL, err)
+	}
+	if count == 0 {
+		return Settlement{}, false, nil
+	}
+
+	settlement = Settlement{
+		ServiceId:   service.id,
+		Amount:      amount,
+		PeriodStart: service.settledUntil,
+		PeriodEnd:   now,
+		Status:      SettlementPending,
+		CreatedAt:   now,
+	}
+	// balance is taken off first, so skipped service has no batch
+	result, err := tx.Exec(
+		settleServiceBalanceSQL,
+		sql.Named("id", settlement.ServiceId),
+		sql.Named("amount", settlement.Amount),
+		sql.Named("period_end", settlement.PeriodEnd),
+	)
+	if err != nil {
+		return Settlement{}, false, queryError(settleServiceBalanceSQL, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Settlement{}, false, dbError(err)
+	}
+	if affected == 0 {
+		return Settlement{}, false, ErrSettlementMismatch
+	}
+
+	result, err = tx.Exec(
+		insertSettlementSQL,
+		sql.Named("service_id", settlement.ServiceId),
+		sql.Named("amount", settlement.Amount),
+		sql.Named("period_start", settlement.PeriodStart),
+		sql.Named("period_end", settlement.PeriodEnd),
+		sql.Named("created_at", settlement.CreatedAt),
+	)
+	if err != nil {
+		return Settlement{}, false, queryError(insertSettlementSQL, err)
+	}
+	settlement.Id, err = result.LastInsertId()
+	if err != nil {
+		return Settlement{}, false, dbError(err)
+	}
+
+	_, err = tx.Exec(
+		attachPaymentsToSettlementSQL,
+		sql.Named("settlement_id", settlement.Id),
+		sql.Named("service_id", settlement.ServiceId),
+		sql.Named("period_end", settlement.PeriodEnd),
+	)
+	if err != nil {
+		return Settlement{}, false, queryError(attachPaymentsToSettlementSQL, err)
+	}
+
+	return settlement, true, nil
+}
+
+func GetSettlement(id int64, db *sql.DB) (settlement Settlement, err error) {
+	err = db.QueryRow(getSettlementSQL, id).Scan(&settlement.Id, &settlement.ServiceId, &settlement.Amount,
+		&settlement.PeriodStart, &settlement.PeriodEnd, &settlement.Status, &settlement.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Settlement{}, ErrNotFound
+		}
+		return Settlement{}, queryError(getSettlementSQL, err)
+	}
+
+	rows, err := db.Query(getSettlementPaymentsSQL, id)
+	if err != nil {
+		return Settlement{}, queryError(getSettlementPaymentsSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			settlement, err = Settlement{}, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		payment, err := scanTransaction(rows)
+		if err != nil {
+			return Settlement{}, dbError(err)
+		}
+		settlement.Payments = append(settlement.Payments, payment)
+	}
+	if rows.Err() != nil {
+		return Settlement{}, dbError(rows.Err())
+	}
+
+	return settlement, nil
+}
+
+// ExportSettlement writes payout file: header (H), one detail per payment (D) and trailer (T) records
+func ExportSettlement(id int64, w io.Writer, db *sql.DB) (err error) {
+	settlement, err := GetSettlement(id, db)
+	if err != nil {
+		return err
+	}
+	var serviceName string
+	err = db.QueryRow(getServiceNameSQL, settlement.ServiceId).Scan(&serviceName)
+	if err != nil {
+		return queryError(getServiceNameSQL, err)
+	}
+
+	writer := csv.NewWriter(w)
+	records := [][]string{{
+		"H",
+		strconv.FormatInt(settlement.Id, 10),
+		strconv.FormatInt(settlement.ServiceId, 10),
+		serviceName,
+		time.Unix(settlement.PeriodStart, 0).UTC().Format(time.RFC3339),
+		time.Unix(settlement.PeriodEnd, 0).UTC().Format(time.RFC3339),
+	}}
+	for _, payment := range settlement.Payments {
+		records = append(records, []string{
+			"D",
+			strconv.FormatInt(payment.Id, 10),
+			payment.Reference,
+			strconv.FormatUint(payment.Amount, 10),
+			time.Unix(payment.CreatedAt, 0).UTC().Format(time.RFC3339),
+		})
+	}
+	records = append(records, []string{
+		"T",
+		strconv.Itoa(len(settlement.Payments)),
+		strconv.FormatUint(settlement.Amount, 10),
+	})
+
+	err = writer.WriteAll(records)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func MarkSettlementPaid(id int64, db *sql.DB) (err error) {
+	result, err := db.Exec(
+		closeSettlementSQL,
+		sql.Named("id", id),
+		sql.Named("status", SettlementPaid),
+	)
+	if err != nil {
+		return queryError(closeSettlementSQL, err)
+	}
+	return checkSettlementClosed(result, id, db)
+}
+
+// MarkSettlementFailed returns batch amount to service balance and releases payments for next batch,
+// which starts at period start of failed one
+func MarkSettlementFailed(id int64, db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	var settlement Settlement
+	err = tx.QueryRow(getSettlementSQL, id).Scan(&settlement.Id, &settlement.ServiceId, &settlement.Amount,
+		&settlement.PeriodStart, &settlement.PeriodEnd, &settlement.Status, &settlement.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return queryError(getSettlementSQL, err)
+	}
+	if settlement.Status != SettlementPending {
+		return ErrSettlementClosed
+	}
+
+	_, err = tx.Exec(
+		closeSettlementSQL,
+		sql.Named("id", id),
+		sql.Named("status", SettlementFailed),
+	)
+	if err != nil {
+		return queryError(closeSettlementSQL, err)
+	}
+	_, err = tx.Exec(detachPaymentsFromSettlementSQL, id)
+	if err != nil {
+		return queryError(detachPaymentsFromSettlementSQL, err)
+	}
+	_, err = tx.Exec(
+		restoreServiceBalanceSQL,
+		sql.Named("id", settlement.ServiceId),
+		sql.Named("amount", settlement.Amount),
+		sql.Named("period_start", settlement.PeriodStart),
+	)
+	if err != nil {
+		return queryError(restoreServiceBalanceSQL, err)
+	}
+
+	return nil
+}
+
+func checkSettlementClosed(result sql.Result, id int64, db *sql.DB) (err error) {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if affected != 0 {
+		return nil
+	}
+
+	var status string
+	err = db.QueryRow(getSettlementStatusSQL, id).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return queryError(getSettlementStatusSQL, err)
+	}
+	return ErrSettlementClosed
+}
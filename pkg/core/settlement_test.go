@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSettlement_CreateExportAndFail(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddServices(Services{Name: "Tcell"}, db)
+	if err != nil {
+		t.Fatalf("can't add service: %v", err)
+	}
+	for _, amount := range []uint64{10, 20} {
+		_, err = PayForServices(1001, amount, "900123456", Services{Id: 1, Balance: amount}, db)
+		if err != nil {
+			t.Fatalf("can't pay: %v", err)
+		}
+	}
+
+	settlements, err := CreateSettlements(time.Now().Add(time.Minute), db)
+	if err != nil {
+		t.Fatalf("can't create settlements: %v", err)
+	}
+	if len(settlements) != 1 || settlements[0].Amount != 30 {
+		t.Fatalf("unexpected settlements: %v", settlements)
+	}
+	services, err := GetServices(db)
+	if err != nil {
+		t.Fatalf("can't get services: %v", err)
+	}
+	if services[0].Balance != 0 {
+		t.Errorf("service balance not settled: %v", services[0].Balance)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = ExportSettlement(settlements[0].Id, buffer, db)
+	if err != nil {
+		t.Fatalf("can't export settlement: %v", err)
+	}
+	if lines := strings.Count(buffer.String(), "\n"); lines != 4 {
+		t.Errorf("unexpected payout file: %s", buffer.String())
+	}
+
+	err = MarkSettlementFailed(settlements[0].Id, db)
+	if err != nil {
+		t.Fatalf("can't fail settlement: %v", err)
+	}
+	err = MarkSettlementPaid(settlements[0].Id, db)
+	if !errors.Is(err, ErrSettlementClosed) {
+		t.Errorf("Not ErrSettlementClosed for failed settlement: %v", err)
+	}
+	services, err = GetServices(db)
+	if err != nil {
+		t.Fatalf("can't get services: %v", err)
+	}
+	if services[0].Balance != 30 {
+		t.Errorf("service balance not restored: %v", services[0].Balance)
+	}
+
+	retried, err := CreateSettlements(time.Now().Add(2*time.Minute), db)
+	if err != nil || len(retried) != 1 || retried[0].Amount != 30 || retried[0].PeriodStart != settlements[0].PeriodStart {
+		t.Errorf("failed payments not settled again: %v %v", retried, err)
+	}
+}
+
+func TestSettlement_KeepsOpeningBalanceAndRejectsMismatch(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	for _, service := range []Services{{Name: "Tcell", Balance: 500}, {Name: "Babilon"}} {
+		err = AddServices(service, db)
+		if err != nil {
+			t.Fatalf("can't add service: %v", err)
+		}
+	}
+	_, err = PayForServices(1001, 30, "900123456", Services{Id: 1}, db)
+	if err != nil {
+		t.Fatalf("can't pay: %v", err)
+	}
+
+	settlements, err := CreateSettlements(time.Now().Add(time.Minute), db)
+	if err != nil || len(settlements) != 1 || settlements[0].Amount != 30 {
+		t.Fatalf("unexpected settlements: %v %v", settlements, err)
+	}
+	services, err := GetServices(db)
+	if err != nil || services[0].Balance != 500 {
+		t.Fatalf("opening balance not kept: %v %v", services, err)
+	}
+
+	for id := int64(1); id <= 2; id++ {
+		_, err = PayForServices(1001, 20, "900123456", Services{Id: id}, db)
+		if err != nil {
+			t.Fatalf("can't pay: %v", err)
+		}
+	}
+	_, err = db.Exec(`update services set balance = 5 where id = 2;`)
+	if err != nil {
+		t.Fatalf("can't change balance: %v", err)
+	}
+	settlements, err = CreateSettlements(time.Now().Add(3*24*time.Hour), db)
+	var settlementErr *SettlementError
+	if !errors.Is(err, ErrSettlementMismatch) || !errors.As(err, &settlementErr) || len(settlementErr.ServiceIds) != 1 || settlementErr.ServiceIds[0] != 2 {
+		t.Errorf("Not ErrSettlementMismatch for balance less than payments: %v", err)
+	}
+	if len(settlements) != 1 || settlements[0].ServiceId != 1 || settlements[0].Amount != 20 {
+		t.Errorf("other service not settled: %v", settlements)
+	}
+	services, err = GetServices(db)
+	if err != nil || services[0].Balance != 500 || services[1].Balance != 5 {
+		t.Errorf("balance changed by failed settlement: %v %v", services, err)
+	}
+
+	_, err = db.Exec(`update services set balance = -1 where id = 2;`)
+	if err == nil {
+		t.Errorf("negative service balance accepted")
+	}
+}
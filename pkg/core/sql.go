@@ -27,38 +27,391 @@ login text not null unique,
 password text not null,
 balance integer not null check(balance>=0),
 balance_number integer not null unique,
-phone_number integer not null unique
+phone_number integer not null unique,
+version integer not null default 1,
+removed integer not null default 0,
+locked_until integer not null default 0,
+created_at integer not null default (strftime('%s', 'now')),
+updated_at integer not null default (strftime('%s', 'now')),
+change_seq integer not null default 0
 );`
 
 const atmDDL = `
 create table if not exists atm (
 id  integer primary key autoincrement,
 name text not null,
-street text not null
+street text not null,
+latitude real not null default 0,
+longitude real not null default 0,
+status text not null default 'online',
+open_time text not null default '00:00',
+close_time text not null default '24:00',
+operations text not null default '',
+version integer not null default 1,
+removed integer not null default 0,
+created_at integer not null default (strftime('%s', 'now')),
+updated_at integer not null default (strftime('%s', 'now')),
+change_seq integer not null default 0
 );`
 
 const servicesDDL = `
 create table if not exists services(
 id integer primary key autoincrement,
 name text not null,
-balance integer not null  
+balance integer not null check(balance>=0),
+reference_pattern text not null default '',
+min_amount integer not null default 0,
+max_amount integer not null default 0,
+category_id integer not null default 0,
+description text not null default '',
+icon text not null default '',
+disabled integer not null default 0,
+position integer not null default 0,
+settlement_period integer not null default 1,
+settled_until integer not null default 0,
+version integer not null default 1,
+removed integer not null default 0,
+created_at integer not null default (strftime('%s', 'now')),
+updated_at integer not null default (strftime('%s', 'now')),
+change_seq integer not null default 0
+);`
+
+const categoriesDDL = `
+create table if not exists categories (
+id integer primary key autoincrement,
+parent_id integer not null default 0,
+name text not null,
+description text not null default '',
+icon text not null default '',
+disabled integer not null default 0,
+position integer not null default 0
+);`
+
+const transactionsDDL = `
+create table if not exists transactions (
+id integer primary key autoincrement,
+kind text not null,
+payer_balance_number integer not null default 0,
+payee_balance_number integer not null default 0,
+service_id integer not null default 0,
+amount integer not null,
+reference text not null default '',
+settlement_id integer not null default 0,
+created_at integer not null default (strftime('%s', 'now')),
+updated_at integer not null default (strftime('%s', 'now')),
+change_seq integer not null default 0
 );`
 
-const getAllAtmSql = `select id,name,street from atm;`
+// changesDDL numbers changed rows for incremental exports, soft removal is a change too. Writes of
+// SQLite are serialized, so change_seq grows in commit order and a reader never sees a smaller
+// number committed after a bigger one, unlike updated_at of long transactions.
+const changesDDL = `
+create table if not exists change_sequence (
+id integer primary key check(id = 1),
+value integer not null
+);
+insert or ignore into change_sequence (id, value) values (1, 0);
+create index if not exists client_change_seq on client (change_seq);
+create index if not exists atm_change_seq on atm (change_seq);
+create index if not exists services_change_seq on services (change_seq);
+create index if not exists transactions_change_seq on transactions (change_seq);
+create trigger if not exists client_change after insert on client for each row
+begin
+    update change_sequence set value = value + 1;
+    update client set change_seq = (select value from change_sequence) where id = new.id;
+end;
+create trigger if not exists client_touch after update on client for each row
+when new.change_seq = old.change_seq
+begin
+    update change_sequence set value = value + 1;
+    update client set change_seq = (select value from change_sequence),
+        updated_at = case when new.updated_at = old.updated_at then strftime('%s', 'now') else new.updated_at end
+    where id = new.id;
+end;
+create trigger if not exists atm_change after insert on atm for each row
+begin
+    update change_sequence set value = value + 1;
+    update atm set change_seq = (select value from change_sequence) where id = new.id;
+end;
+create trigger if not exists atm_touch after update on atm for each row
+when new.change_seq = old.change_seq
+begin
+    update change_sequence set value = value + 1;
+    update atm set change_seq = (select value from change_sequence),
+        updated_at = case when new.updated_at = old.updated_at then strftime('%s', 'now') else new.updated_at end
+    where id = new.id;
+end;
+create trigger if not exists services_change after insert on services for each row
+begin
+    update change_sequence set value = value + 1;
+    update services set change_seq = (select value from change_sequence) where id = new.id;
+end;
+create trigger if not exists services_touch after update on services for each row
+when new.change_seq = old.change_seq
+begin
+    update change_sequence set value = value + 1;
+    update services set change_seq = (select value from change_sequence),
+        updated_at = case when new.updated_at = old.updated_at then strftime('%s', 'now') else new.updated_at end
+    where id = new.id;
+end;
+create trigger if not exists transactions_change after insert on transactions for each row
+begin
+    update change_sequence set value = value + 1;
+    update transactions set change_seq = (select value from change_sequence) where id = new.id;
+end;
+create trigger if not exists transactions_touch after update on transactions for each row
+when new.change_seq = old.change_seq
+begin
+    update change_sequence set value = value + 1;
+    update transactions set change_seq = (select value from change_sequence),
+        updated_at = case when new.updated_at = old.updated_at then strftime('%s', 'now') else new.updated_at end
+    where id = new.id;
+end;`
+
+// webhooksDDL keeps subscriptions of managers and outbox of events written together with money movements
+const webhooksDDL = `
+create table if not exists webhooks (
+id integer primary key autoincrement,
+url text not null,
+secret text not null,
+events text not null,
+disabled integer not null default 0,
+created_at integer not null default (strftime('%s', 'now'))
+);
+create table if not exists outbox (
+id integer primary key autoincrement,
+webhook_id integer not null references webhooks (id) on delete cascade,
+event text not null,
+payload text not null,
+status text not null default 'pending',
+attempts integer not null default 0,
+next_attempt_at integer not null default (strftime('%s', 'now')),
+last_error text not null default '',
+created_at integer not null default (strftime('%s', 'now'))
+);
+create index if not exists outbox_status on outbox (status, next_attempt_at);`
+
+const settlementsDDL = `
+create table if not exists settlements (
+id integer primary key autoincrement,
+service_id integer not null,
+amount integer not null,
+period_start integer not null,
+period_end integer not null,
+status text not null default 'pending',
+created_at integer not null default (strftime('%s', 'now'))
+);`
+
+const receiptsDDL = `
+create table if not exists receipts (
+number text primary key,
+transaction_id integer not null unique,
+kind text not null,
+payer_balance_number integer not null,
+payee_balance_number integer not null,
+service_id integer not null,
+service_name text not null,
+reference text not null,
+amount integer not null,
+fee integer not null,
+balance_after integer not null,
+created_at integer not null
+);`
+
+const getAllAtmSql = `select id, name, street, latitude, longitude, status, open_time, close_time, operations, version from atm where removed = 0;`
 const loginSQL = `SELECT login, password FROM managers WHERE login = ?`
 const insertClientSQL = `INSERT INTO client(name, login, password, balance, balance_number, phone_number) values (:name, :login, :password, :balance, :balance_number, :phone_number);`
-const LoginForClient = `select id, login,password from client where login = ?`
-const insertAtmSql = `insert into atm (name,street) values (:name, :street);`
-const insertServices = `insert into services(name, balance) values(:name, :balance);`
-const getAllServices = `select id,name from services;`
-const getListBalanceSql = `select id, name, balance_number, balance from client where id = ?;`
-const updateCardBalanceSQL = ` UPDATE client SET balance = balance + :balance WHERE login = :login;`
-const updateTransactionWithPhoneNumberMinus = `UPDATE client SET balance = balance - :balance WHERE balance_number = :balance_number;`
-const updateTransactionWithPhoneNumberPlus = `UPDATE client SET balance = balance + :balance where phone_number = :phone_number;`
-const updateTransactionWithBalanceNumberMinus = `UPDATE client SET balance = balance - :balance WHERE balance_number = :balance_number;`
-const updateTransactionWithBalanceNumberPlus = `UPDATE client SET balance = balance + :balance where balance_number = :balance_number;`
+const LoginForClient = `select id, login,password, locked_until from client where login = ? and removed = 0`
+const lockLoginSQL = `update client set locked_until = :locked_until where login = :login and removed = 0`
+const insertAtmSql = `insert into atm (name, street, latitude, longitude, status, open_time, close_time, operations) values (:name, :street, :latitude, :longitude, :status, :open_time, :close_time, :operations);`
+const insertServices = `insert into services(name, balance, reference_pattern, min_amount, max_amount, category_id, description, icon, disabled, position, settlement_period)
+values(:name, :balance, :reference_pattern, :min_amount, :max_amount, :category_id, :description, :icon, :disabled, :position, :settlement_period);`
+const serviceColumns = `id, name, balance, reference_pattern, min_amount, max_amount, category_id, description, icon, disabled, position,
+settlement_period, version`
+const getAllServices = `select ` + serviceColumns + ` from services where removed = 0;`
+const getListBalanceSql = `select id, name, balance_number, balance from client where id = ? and removed = 0;`
+const updateCardBalanceSQL = ` UPDATE client SET balance = balance + :balance WHERE login = :login and removed = 0;`
+const updateTransactionWithPhoneNumberMinus = `UPDATE client SET balance = balance - :balance WHERE balance_number = :balance_number and removed = 0 and balance >= :balance;`
+const updateTransactionWithPhoneNumberPlus = `UPDATE client SET balance = balance + :balance where phone_number = :phone_number and removed = 0;`
+const updateTransactionWithBalanceNumberMinus = `UPDATE client SET balance = balance - :balance WHERE balance_number = :balance_number and removed = 0 and balance >= :balance;`
+const updateTransactionWithBalanceNumberPlus = `UPDATE client SET balance = balance + :balance where balance_number = :balance_number and removed = 0;`
 const updateServices  = `update services set balance = balance + :balance where id = :id;`
-const payServices  =`update client set balance = balance - :balance where balance_number = :balance_number;`
+const payServices  =`update client set balance = balance - :balance where balance_number = :balance_number and removed = 0 and balance >= :balance;`
+const checkDebitedAccountSQL = `select id from client where balance_number = ? and removed = 0;`
+
+const getAllAtmDataSQL = `SELECT id, name, street, latitude, longitude, status, open_time, close_time, operations, version FROM atm WHERE removed = 0;`
+const getAllClientsDataSQL = `SELECT id, login, password, name, phone_number, balance, balance_number, version FROM client WHERE removed = 0;`
+const getAtmsInLatitudeRangeSQL = `select id, name, street, latitude, longitude, status, open_time, close_time, operations, version from atm where removed = 0 and latitude between ? and ?;`
+
+const updateAtmSQL = `update atm set name = :name, street = :street, latitude = :latitude, longitude = :longitude, status = :status,
+open_time = :open_time, close_time = :close_time, operations = :operations, version = version + 1
+where id = :id and version = :version and removed = 0;`
+const updateServiceSQL = `update services set name = :name, reference_pattern = :reference_pattern, min_amount = :min_amount, max_amount = :max_amount,
+category_id = :category_id, description = :description, icon = :icon, disabled = :disabled, position = :position,
+settlement_period = :settlement_period, version = version + 1 where id = :id and version = :version and removed = 0;`
+const updateClientSQL = `update client set name = :name, login = :login, password = coalesce(nullif(:password, ''), password),
+phone_number = :phone_number, version = version + 1
+where id = :id and version = :version and removed = 0;`
+const removeAtmSQL = `update atm set removed = 1, version = version + 1 where id = :id and version = :version and removed = 0;`
+const removeServiceSQL = `update services set removed = 1, version = version + 1 where id = :id and version = :version and removed = 0;`
+const removeClientSQL = `update client set removed = 1, version = version + 1 where id = :id and version = :version and removed = 0;`
+const checkAtmExistsSQL = `select id from atm where id = ? and removed = 0;`
+const checkServiceExistsSQL = `select id from services where id = ? and removed = 0;`
+const checkClientExistsSQL = `select id from client where id = ? and removed = 0;`
+const checkServiceNameSQL = `select id from services where name = ? and id != ? and removed = 0 limit 1;`
+const checkClientLoginSQL = `select id from client where login = ? and id != ? limit 1;`
+const checkClientBalanceNumberSQL = `select id from client where balance_number = ? and id != ? limit 1;`
+const checkClientPhoneNumberSQL = `select id from client where phone_number = ? and id != ? limit 1;`
+
+const insertTransactionSQL = `insert into transactions (kind, payer_balance_number, payee_balance_number, service_id, amount, reference)
+values (:kind, :payer_balance_number, :payee_balance_number, :service_id, :amount, :reference);`
+const insertTransferByPhoneNumberSQL = `insert into transactions (kind, payer_balance_number, payee_balance_number, amount)
+select :kind, :payer_balance_number, balance_number, :amount from client where phone_number = :phone_number and removed = 0;`
+const insertTopUpSQL = `insert into transactions (kind, payee_balance_number, amount)
+select :kind, balance_number, :amount from client where login = :login and removed = 0;`
+const findClientsSQL = `select id, name, login, balance, balance_number, phone_number, version from client
+where removed = 0 and (name like :query escape '\' or login like :query escape '\'
+or cast(phone_number as text) like :query escape '\' or cast(balance_number as text) like :query escape '\')
+order by name, id limit :limit;`
+const getClientProfileSQL = `select id, name, login, balance, balance_number, phone_number, version, removed from client where id = ?;`
+// disabledCategoriesSQL lists disabled categories with all their subcategories
+const disabledCategoriesSQL = `with recursive disabled_categories(id) as (
+select id from categories where disabled = 1
+union
+select categories.id from categories join disabled_categories on categories.parent_id = disabled_categories.id
+) `
+const getServicePaymentRulesSQL = disabledCategoriesSQL + `select reference_pattern, min_amount, max_amount,
+disabled or category_id in (select id from disabled_categories) from services where id = ? and removed = 0;`
+const insertCategorySQL = `insert into categories (parent_id, name, description, icon, disabled, position)
+values (:parent_id, :name, :description, :icon, :disabled, :position);`
+const checkCategoryExistsSQL = `select id from categories where id = ?;`
+const getCategoriesSQL = disabledCategoriesSQL + `select id, parent_id, name, description, icon, disabled, position from categories
+where parent_id = ? and id not in (select id from disabled_categories) order by position, name, id;`
+const getServicesByCategorySQL = disabledCategoriesSQL + `select ` + serviceColumns + ` from services
+where category_id = ? and category_id not in (select id from disabled_categories) and disabled = 0 and removed = 0
+order by position, name, id;`
+const updateCategorySQL = `update categories set parent_id = :parent_id, name = :name, description = :description, icon = :icon,
+position = :position where id = :id;`
+const checkCategoryCycleSQL = `with recursive ancestors(id) as (
+select :parent_id
+union
+select categories.parent_id from categories join ancestors on categories.id = ancestors.id where categories.parent_id != 0
+) select count(*) from ancestors where id = :id;`
+const setCategoryDisabledSQL = `update categories set disabled = :disabled where id = :id;`
+const setServiceDisabledSQL = `update services set disabled = :disabled, version = version + 1 where id = :id and removed = 0;`
+const getDueServicesSQL = `select id, settled_until from services
+where removed = 0 and settled_until + max(settlement_period, 1) * 86400 <= ?;`
+const sumUnsettledPaymentsSQL = `select count(*), coalesce(sum(amount), 0) from transactions
+where kind = 'payment' and service_id = ? and settlement_id = 0 and created_at < ?;`
+const insertSettlementSQL = `insert into settlements (service_id, amount, period_start, period_end, status, created_at)
+values (:service_id, :amount, :period_start, :period_end, 'pending', :created_at);`
+const attachPaymentsToSettlementSQL = `update transactions set settlement_id = :settlement_id
+where kind = 'payment' and service_id = :service_id and settlement_id = 0 and created_at < :period_end;`
+const settleServiceBalanceSQL = `update services set balance = balance - :amount, settled_until = :period_end
+where id = :id and balance >= :amount;`
+const getSettlementSQL = `select id, service_id, amount, period_start, period_end, status, created_at from settlements where id = ?;`
+const getSettlementPaymentsSQL = `select id, kind, payer_balance_number, payee_balance_number, service_id, amount, reference, created_at
+from transactions where settlement_id = ? order by id;`
+const getServiceNameSQL = `select name from services where id = ?;`
+const closeSettlementSQL = `update settlements set status = :status where id = :id and status = 'pending';`
+const detachPaymentsFromSettlementSQL = `update transactions set settlement_id = 0 where settlement_id = ?;`
+const restoreServiceBalanceSQL = `update services set balance = balance + :amount, settled_until = min(settled_until, :period_start)
+where id = :id;`
+const getSettlementStatusSQL = `select status from settlements where id = ?;`
+const getReceiptDataSQL = `select t.kind, t.payer_balance_number, t.payee_balance_number, t.service_id, coalesce(s.name, ''),
+t.reference, t.amount, t.created_at, c.balance
+from transactions t left join services s on s.id = t.service_id join client c on c.balance_number = t.payer_balance_number
+where t.id = ?;`
+const insertReceiptSQL = `insert into receipts (number, transaction_id, kind, payer_balance_number, payee_balance_number, service_id,
+service_name, reference, amount, fee, balance_after, created_at)
+values (:number, :transaction_id, :kind, :payer_balance_number, :payee_balance_number, :service_id,
+:service_name, :reference, :amount, :fee, :balance_after, :created_at);`
+const getReceiptSQL = `select number, kind, payer_balance_number, payee_balance_number, service_id, service_name, reference,
+amount, fee, balance_after, created_at from receipts where number = ?;`
+const getStatementAccountSQL = `select name, balance from client where balance_number = ?;`
+const getAccountNetSinceSQL = `select coalesce(sum(case when payee_balance_number = :balance_number then amount else 0 end), 0)
+- coalesce(sum(case when payer_balance_number = :balance_number then amount else 0 end), 0)
+from transactions where (payer_balance_number = :balance_number or payee_balance_number = :balance_number) and created_at >= :since;`
+const getStatementEntriesSQL = `select id, kind, payer_balance_number, payee_balance_number, service_id, amount, reference, created_at
+from transactions where (payer_balance_number = :balance_number or payee_balance_number = :balance_number)
+and created_at >= :from and created_at < :to order by created_at, id;`
+const getAccountTransactionsSQL = `select id, kind, payer_balance_number, payee_balance_number, service_id, amount, reference, created_at
+from transactions where payer_balance_number = ? or payee_balance_number = ? order by created_at, id;`
+
+const savepointImportBatchSQL = `savepoint import_batch;`
+
+const rollbackImportBatchSQL = `rollback to import_batch;`
+
+const releaseImportBatchSQL = `release import_batch;`
+
+const exportedClientColumns = `id, login, password, name, phone_number, balance, balance_number, version`
+const exportedAtmColumns = `id, name, street, latitude, longitude, status, open_time, close_time, operations, version`
+const checkClientIdSQL = `select id from client where id = ?;`
+const checkAtmIdSQL = `select id from atm where id = ?;`
+const checkServiceIdSQL = `select id from services where id = ?;`
+const findClientByIdSQL = `select ` + exportedClientColumns + ` from client where id = ?;`
+const findClientByLoginSQL = `select ` + exportedClientColumns + ` from client where login = ?;`
+const findAtmByIdSQL = `select ` + exportedAtmColumns + ` from atm where id = ?;`
+const findAtmByAddressSQL = `select ` + exportedAtmColumns + ` from atm where name = ? and street = ? order by removed, id limit 1;`
+const findServiceByIdSQL = `select ` + serviceColumns + ` from services where id = ?;`
+const findServiceByNameSQL = `select ` + serviceColumns + ` from services where name = ? order by removed, id limit 1;`
+const importClientSQL = `insert into client(id, name, login, password, balance, balance_number, phone_number)
+values (:id, :name, :login, :password, :balance, :balance_number, :phone_number);`
+const importAtmSQL = `insert into atm (id, name, street, latitude, longitude, status, open_time, close_time, operations)
+values (:id, :name, :street, :latitude, :longitude, :status, :open_time, :close_time, :operations);`
+const importServiceSQL = `insert into services(id, name, balance, reference_pattern, min_amount, max_amount, category_id, description, icon,
+disabled, position, settlement_period)
+values(:id, :name, :balance, :reference_pattern, :min_amount, :max_amount, :category_id, :description, :icon,
+:disabled, :position, :settlement_period);`
+const importUpdateClientSQL = `update client set name = :name, login = :login, password = :password,
+balance_number = :balance_number, phone_number = :phone_number, version = version + 1, removed = 0 where id = :id;`
+const importUpdateAtmSQL = `update atm set name = :name, street = :street, latitude = :latitude, longitude = :longitude, status = :status,
+open_time = :open_time, close_time = :close_time, operations = :operations, version = version + 1, removed = 0 where id = :id;`
+const importUpdateServiceSQL = `update services set name = :name, reference_pattern = :reference_pattern,
+min_amount = :min_amount, max_amount = :max_amount, category_id = :category_id, description = :description, icon = :icon,
+disabled = :disabled, position = :position, settlement_period = :settlement_period, version = version + 1, removed = 0
+where id = :id;`
+
+const getSchemaVersionSQL = `pragma user_version;`
+const setSchemaVersionSQL = `pragma user_version = %d;`
+const deleteManagersSQL = `delete from managers;`
+const backupSelectSQL = `select * from %s order by rowid;`
+const backupColumnsSQL = `select * from %s limit 0;`
+const countTableRowsSQL = `select count(*) from %s;`
+const restoreRowSQL = `insert into %s (%s) values (%s);`
+
+const changedClientsSQL = `select removed, change_seq, created_at, updated_at, id, name, login, balance, balance_number, phone_number, version
+from client where change_seq >= :since and change_seq < :until order by change_seq;`
+const changedAtmsSQL = `select removed, change_seq, created_at, updated_at, ` + exportedAtmColumns + `
+from atm where change_seq >= :since and change_seq < :until order by change_seq;`
+const changedServicesSQL = `select removed, change_seq, created_at, updated_at, ` + serviceColumns + `
+from services where change_seq >= :since and change_seq < :until order by change_seq;`
+const changedTransactionsSQL = `select 0, change_seq, created_at, updated_at, id, kind, payer_balance_number, payee_balance_number, service_id, amount, reference, created_at
+from transactions where change_seq >= :since and change_seq < :until order by change_seq;`
+const getChangeSequenceSQL = `select value from change_sequence;`
+
+const insertWebhookSQL = `insert into webhooks (url, secret, events) values (:url, :secret, :events);`
+const getWebhooksSQL = `select id, url, secret, events, disabled, created_at from webhooks order by id;`
+const setWebhookDisabledSQL = `update webhooks set disabled = :disabled where id = :id;`
+const disableWebhooksWithoutSecretSQL = `update webhooks set disabled = 1 where secret = '';`
+const removeWebhookOutboxSQL = `delete from outbox where webhook_id = ?;`
+const removeWebhookSQL = `delete from webhooks where id = ?;`
+const publishEventSQL = `insert into outbox (webhook_id, event, payload)
+select id, :event, :payload from webhooks where disabled = 0 and secret != '' and instr(',' || events || ',', ',' || :event || ',') > 0;`
+const outboxColumns = `outbox.id, outbox.webhook_id, outbox.event, outbox.payload, outbox.status, outbox.attempts,
+outbox.next_attempt_at, outbox.last_error, outbox.created_at`
+const getDueEventsSQL = `select ` + outboxColumns + `, webhooks.url, webhooks.secret from outbox
+join webhooks on webhooks.id = outbox.webhook_id
+where outbox.status = 'pending' and outbox.next_attempt_at <= :now and webhooks.disabled = 0 and webhooks.secret != ''
+order by outbox.id limit :limit;`
+const getEventsByStatusSQL = `select ` + outboxColumns + ` from outbox where status = ? order by id;`
+const markEventDeliveredSQL = `update outbox set status = 'delivered', attempts = attempts + 1, last_error = '' where id = :id;`
+const markEventFailedSQL = `update outbox set status = :status, attempts = attempts + 1, next_attempt_at = :next_attempt_at,
+last_error = :last_error where id = :id;`
+const retryDeadEventSQL = `update outbox set status = 'pending', attempts = 0, next_attempt_at = :now where id = :id and status = 'dead';`
 
-const getAllAtmDataSQL = `SELECT * FROM atm;`
-const getAllClientsDataSQL = `SELECT * FROM client;`
+const countTablesSQL = `select count(*) from sqlite_master where type = 'table';`
+const tableColumnsSQL = `select name from pragma_table_info('%s');`
+const renameTableSQL = `alter table %s rename to %s;`
+const copyRowsSQL = `insert into %s (%s) select %s from %s;`
+const dropTableSQL = `drop table %s;`
@@ -0,0 +1,361 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSON    = "json"
+	FormatXML     = "xml"
+	FormatCamt053 = "camt.053"
+)
+
+// Currency of all accounts, amounts are stored in minor units (1/100)
+const Currency = "TJS"
+
+const camt053Namespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+var ErrUnknownFormat = errors.New("unknown format")
+
+// Statement amounts are in minor units in JSON and XML, CSV and camt.053 show currency units
+type Statement struct {
+	XMLName        xml.Name         `json:"-" xml:"statement"`
+	BalanceNumber  uint64           `json:"balance_number" xml:"balance_number"`
+	Owner          string           `json:"owner" xml:"owner"`
+	From           int64            `json:"from" xml:"from"`
+	To             int64            `json:"to" xml:"to"`
+	OpeningBalance int64            `json:"opening_balance" xml:"opening_balance"`
+	ClosingBalance int64            `json:"closing_balance" xml:"closing_balance"`
+	Entries        []StatementEntry `json:"entries" xml:"entries>entry"`
+}
+
+// StatementEntry is transaction seen from statement account, Amount is negative for debit
+type StatementEntry struct {
+	TransactionId int64  `json:"transaction_id" xml:"transaction_id"`
+	Kind          string `json:"kind" xml:"kind"`
+	Counterparty  uint64 `json:"counterparty,omitempty" xml:"counterparty,omitempty"`
+	ServiceId     int64  `json:"service_id,omitempty" xml:"service_id,omitempty"`
+	Reference     string `json:"reference,omitempty" xml:"reference,omitempty"`
+	Amount        int64  `json:"amount" xml:"amount"`
+	BalanceAfter  int64  `json:"balance_after" xml:"balance_after"`
+	BookedAt      int64  `json:"booked_at" xml:"booked_at"`
+}
+
+// GenerateStatement writes entries of account booked in [from, to) with opening and closing balances
+func GenerateStatement(balanceNumber uint64, from, to time.Time, format string, w io.Writer, db *sql.DB) (err error) {
+	return GenerateStatementWithProfile(balanceNumber, from, to, format, nil, w, db)
+}
+
+// GenerateStatementWithProfile masks owner, counterparties and references as profile Statements tell
+func GenerateStatementWithProfile(balanceNumber uint64, from, to time.Time, format string, profile *ExportProfile,
+	w io.Writer, db *sql.DB) (err error) {
+	statement, err := GetStatement(balanceNumber, from, to, db)
+	if err != nil {
+		return err
+	}
+	if profile != nil {
+		statement, err = maskStatement(statement, *profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case FormatCSV:
+		return writeStatementCSV(statement, w)
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(statement)
+	case FormatXML:
+		return writeXMLDocument(statement, w)
+	case FormatCamt053:
+		return writeStatementCamt053(statement, w)
+	}
+	return ErrUnknownFormat
+}
+
+// GetStatement reads balance and entries in one transaction, so transfers made meanwhile can't break balances
+func GetStatement(balanceNumber uint64, from, to time.Time, db *sql.DB) (statement Statement, err error) {
+	statement = Statement{BalanceNumber: balanceNumber, From: from.Unix(), To: to.Unix()}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Statement{}, dbError(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var current int64
+	err = tx.QueryRow(getStatementAccountSQL, balanceNumber).Scan(&statement.Owner, &current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Statement{}, ErrNotFound
+		}
+		return Statement{}, queryError(getStatementAccountSQL, err)
+	}
+
+	netSinceFrom, err := accountNetSince(balanceNumber, statement.From, tx)
+	if err != nil {
+		return Statement{}, err
+	}
+	netSinceTo, err := accountNetSince(balanceNumber, statement.To, tx)
+	if err != nil {
+		return Statement{}, err
+	}
+	statement.OpeningBalance = current - netSinceFrom
+	statement.ClosingBalance = current - netSinceTo
+
+	rows, err := tx.Query(
+		getStatementEntriesSQL,
+		sql.Named("balance_number", balanceNumber),
+		sql.Named("from", statement.From),
+		sql.Named("to", statement.To),
+	)
+	if err != nil {
+		return Statement{}, queryError(getStatementEntriesSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			statement, err = Statement{}, dbError(innerErr)
+		}
+	}()
+
+	balance := statement.OpeningBalance
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return Statement{}, dbError(err)
+		}
+		entry := StatementEntry{
+			TransactionId: transaction.Id,
+			Kind:          transaction.Kind,
+			ServiceId:     transaction.ServiceId,
+			Reference:     transaction.Reference,
+			BookedAt:      transaction.CreatedAt,
+		}
+		if transaction.PayerBalanceNumber == balanceNumber {
+			entry.Amount -= int64(transaction.Amount)
+			entry.Counterparty = transaction.PayeeBalanceNumber
+		}
+		if transaction.PayeeBalanceNumber == balanceNumber {
+			entry.Amount += int64(transaction.Amount)
+			entry.Counterparty = transaction.PayerBalanceNumber
+		}
+		balance += entry.Amount
+		entry.BalanceAfter = balance
+		statement.Entries = append(statement.Entries, entry)
+	}
+	if rows.Err() != nil {
+		return Statement{}, dbError(rows.Err())
+	}
+
+	return statement, nil
+}
+
+func maskStatement(statement Statement, profile ExportProfile) (Statement, error) {
+	err := profile.checkStatementFields()
+	if err != nil {
+		return Statement{}, err
+	}
+	statement.Owner, err = profile.maskString(statement.Owner, "owner")
+	if err != nil {
+		return Statement{}, err
+	}
+	entries := make([]StatementEntry, len(statement.Entries))
+	for index, entry := range statement.Entries {
+		entry.Reference, err = profile.maskString(entry.Reference, "reference")
+		if err != nil {
+			return Statement{}, err
+		}
+		if profile.statementMask("counterparty") == MaskRedact {
+			entry.Counterparty = 0
+		}
+		entries[index] = entry
+	}
+	statement.Entries = entries
+	return statement, nil
+}
+
+func accountNetSince(balanceNumber uint64, since int64, tx *sql.Tx) (net int64, err error) {
+	err = tx.QueryRow(
+		getAccountNetSinceSQL,
+		sql.Named("balance_number", balanceNumber),
+		sql.Named("since", since),
+	).Scan(&net)
+	if err != nil {
+		return 0, queryError(getAccountNetSinceSQL, err)
+	}
+	return net, nil
+}
+
+func formatMinorUnits(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+func writeStatementCSV(statement Statement, w io.Writer) (err error) {
+	writer := csv.NewWriter(w)
+	records := [][]string{
+		{"date", "transaction_id", "kind", "counterparty", "reference", "amount", "balance"},
+		{time.Unix(statement.From, 0).UTC().Format(time.RFC3339), "", "opening_balance", "", "", "",
+			formatMinorUnits(statement.OpeningBalance)},
+	}
+	for _, entry := range statement.Entries {
+		records = append(records, []string{
+			time.Unix(entry.BookedAt, 0).UTC().Format(time.RFC3339),
+			strconv.FormatInt(entry.TransactionId, 10),
+			entry.Kind,
+			strconv.FormatUint(entry.Counterparty, 10),
+			entry.Reference,
+			formatMinorUnits(entry.Amount),
+			formatMinorUnits(entry.BalanceAfter),
+		})
+	}
+	records = append(records, []string{
+		time.Unix(statement.To, 0).UTC().Format(time.RFC3339), "", "closing_balance", "", "", "",
+		formatMinorUnits(statement.ClosingBalance),
+	})
+	return writer.WriteAll(records)
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+	Date      string     `xml:"Dt>Dt"`
+}
+
+type camtEntry struct {
+	Reference       string          `xml:"NtryRef"`
+	Amount          camtAmount      `xml:"Amt"`
+	Indicator       string          `xml:"CdtDbtInd"`
+	Status          string          `xml:"Sts"`
+	BookingDate     string          `xml:"BookgDt>DtTm"`
+	ValueDate       string          `xml:"ValDt>Dt"`
+	ServicerRef     string          `xml:"AcctSvcrRef"`
+	TransactionCode string          `xml:"BkTxCd>Prtry>Cd"`
+	EndToEndId      string          `xml:"NtryDtls>TxDtls>Refs>EndToEndId"`
+	Remittance      *camtRemittance `xml:"NtryDtls>TxDtls>RmtInf,omitempty"`
+}
+
+type camtRemittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+type camtStatement struct {
+	Id        string        `xml:"Id"`
+	CreatedAt string        `xml:"CreDtTm"`
+	From      string        `xml:"FrToDt>FrDtTm"`
+	To        string        `xml:"FrToDt>ToDtTm"`
+	Account   string        `xml:"Acct>Id>Othr>Id"`
+	Currency  string        `xml:"Acct>Ccy"`
+	Owner     string        `xml:"Acct>Ownr>Nm"`
+	Balances  []camtBalance `xml:"Bal"`
+	Entries   []camtEntry   `xml:"Ntry"`
+}
+
+type camtDocument struct {
+	XMLName   xml.Name      `xml:"Document"`
+	Namespace string        `xml:"xmlns,attr"`
+	MessageId string        `xml:"BkToCstmrStmt>GrpHdr>MsgId"`
+	CreatedAt string        `xml:"BkToCstmrStmt>GrpHdr>CreDtTm"`
+	Statement camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+func camtIndicator(amount int64) (string, string) {
+	if amount < 0 {
+		return "DBIT", formatMinorUnits(-amount)
+	}
+	return "CRDT", formatMinorUnits(amount)
+}
+
+func camtBalanceOf(code string, amount int64, date int64) camtBalance {
+	indicator, value := camtIndicator(amount)
+	return camtBalance{
+		Code:      code,
+		Amount:    camtAmount{Currency: Currency, Value: value},
+		Indicator: indicator,
+		Date:      time.Unix(date, 0).UTC().Format("2006-01-02"),
+	}
+}
+
+func writeStatementCamt053(statement Statement, w io.Writer) (err error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	id := fmt.Sprintf("%d-%d-%d", statement.BalanceNumber, statement.From, statement.To)
+	document := camtDocument{
+		Namespace: camt053Namespace,
+		MessageId: id,
+		CreatedAt: now,
+		Statement: camtStatement{
+			Id:        id,
+			CreatedAt: now,
+			From:      time.Unix(statement.From, 0).UTC().Format(time.RFC3339),
+			To:        time.Unix(statement.To, 0).UTC().Format(time.RFC3339),
+			Account:   strconv.FormatUint(statement.BalanceNumber, 10),
+			Currency:  Currency,
+			Owner:     statement.Owner,
+			Balances: []camtBalance{
+				camtBalanceOf("OPBD", statement.OpeningBalance, statement.From),
+				camtBalanceOf("CLBD", statement.ClosingBalance, statement.To),
+			},
+		},
+	}
+	for _, entry := range statement.Entries {
+		indicator, value := camtIndicator(entry.Amount)
+		transactionId := strconv.FormatInt(entry.TransactionId, 10)
+		var remittance *camtRemittance
+		if entry.Reference != "" {
+			remittance = &camtRemittance{Unstructured: entry.Reference}
+		}
+		document.Statement.Entries = append(document.Statement.Entries, camtEntry{
+			Reference:       transactionId,
+			Amount:          camtAmount{Currency: Currency, Value: value},
+			Indicator:       indicator,
+			Status:          "BOOK",
+			BookingDate:     time.Unix(entry.BookedAt, 0).UTC().Format(time.RFC3339),
+			ValueDate:       time.Unix(entry.BookedAt, 0).UTC().Format("2006-01-02"),
+			ServicerRef:     transactionId,
+			TransactionCode: entry.Kind,
+			EndToEndId:      transactionId,
+			Remittance:      remittance,
+		})
+	}
+
+	return writeXMLDocument(document, w)
+}
+
+func writeXMLDocument(document interface{}, w io.Writer) (err error) {
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return err
+	}
+	err = encoder.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
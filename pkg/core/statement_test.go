@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestGetStatement_Balances(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddClients(Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = AddClients(Client{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = UpdateBalance(Client{Login: "vasya", Balance: 50}, db)
+	if err != nil {
+		t.Fatalf("can't top up: %v", err)
+	}
+	_, err = TransferByBalanceNumber(1001, 30, Client{BalanceNumber: 1002, Balance: 30}, db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+
+	january := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2020, 2, 10, 0, 0, 0, 0, time.UTC)
+	_, err = db.Exec(`update transactions set created_at = case kind when 'top_up' then ? else ? end`, january.Unix(), february.Unix())
+	if err != nil {
+		t.Fatalf("can't move transactions: %v", err)
+	}
+
+	statement, err := GetStatement(1001, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), db)
+	if err != nil {
+		t.Fatalf("can't get statement: %v", err)
+	}
+	if statement.OpeningBalance != 150 || statement.ClosingBalance != 120 {
+		t.Errorf("unexpected balances: %d, %d", statement.OpeningBalance, statement.ClosingBalance)
+	}
+	if len(statement.Entries) != 1 || statement.Entries[0].Amount != -30 || statement.Entries[0].Counterparty != 1002 {
+		t.Errorf("unexpected entries: %v", statement.Entries)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = GenerateStatement(1001, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), FormatCamt053, buffer, db)
+	if err != nil {
+		t.Fatalf("can't generate statement: %v", err)
+	}
+	document := camtDocument{}
+	err = xml.Unmarshal(buffer.Bytes(), &document)
+	if err != nil {
+		t.Fatalf("invalid camt.053 xml: %v", err)
+	}
+	if !strings.Contains(buffer.String(), `<CdtDbtInd>DBIT</CdtDbtInd>`) || len(document.Statement.Entries) != 1 {
+		t.Errorf("unexpected camt.053: %s", buffer.String())
+	}
+
+	buffer.Reset()
+	err = GenerateStatement(1001, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), FormatXML, buffer, db)
+	if err != nil {
+		t.Fatalf("can't generate xml statement: %v", err)
+	}
+	decoded := Statement{}
+	err = xml.Unmarshal(buffer.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("invalid statement xml: %v", err)
+	}
+	if decoded.OpeningBalance != 150 || len(decoded.Entries) != 1 || decoded.Entries[0] != statement.Entries[0] {
+		t.Errorf("unexpected xml statement: %s", buffer.String())
+	}
+}
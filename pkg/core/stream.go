@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ExportProgress is called after every written row with number of rows written so far
+type ExportProgress func(rows int64)
+
+// rowEncoder writes export one row at a time, output matches marshalling of whole export struct
+type rowEncoder interface {
+	begin() error
+	encode(item interface{}) error
+	end() error
+}
+
+// streamQueryToWriter is exportQueryToWriter that keeps only current row in memory
+func streamQueryToWriter(
+	db *sql.DB,
+	getDataFromDbSQL string,
+	encoder rowEncoder,
+	progress ExportProgress,
+	mapRow MapperRowTo,
+	args ...interface{}) (err error) {
+
+	rows, err := db.Query(getDataFromDbSQL, args...)
+	if err != nil {
+		return queryError(getDataFromDbSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil && err == nil {
+			err = dbError(innerErr)
+		}
+	}()
+
+	err = encoder.begin()
+	if err != nil {
+		return err
+	}
+	var written int64
+	for rows.Next() {
+		item, err := mapRow(rows)
+		if err != nil {
+			return dbError(err)
+		}
+		err = encoder.encode(item)
+		if err != nil {
+			return err
+		}
+		written++
+		if progress != nil {
+			progress(written)
+		}
+	}
+	if rows.Err() != nil {
+		return dbError(rows.Err())
+	}
+	return encoder.end()
+}
+
+// jsonRowEncoder writes {"version":1,"<root>":[row,row,...]}
+type jsonRowEncoder struct {
+	w     io.Writer
+	root  string
+	count int
+}
+
+func (receiver *jsonRowEncoder) begin() error {
+	root, err := json.Marshal(receiver.root)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(receiver.w, `{"version":%d,%s:[`, ExchangeFormatVersion, root)
+	return err
+}
+
+func (receiver *jsonRowEncoder) encode(item interface{}) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if receiver.count > 0 {
+		data = append([]byte{','}, data...)
+	}
+	receiver.count++
+	_, err = receiver.w.Write(data)
+	return err
+}
+
+func (receiver *jsonRowEncoder) end() error {
+	_, err := io.WriteString(receiver.w, "]}")
+	return err
+}
+
+// xmlRowEncoder writes <root version="1"><field>row</field>...</root>
+type xmlRowEncoder struct {
+	encoder *xml.Encoder
+	root    string
+	field   string
+}
+
+func (receiver *xmlRowEncoder) begin() error {
+	return receiver.encoder.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: receiver.root},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "version"}, Value: strconv.Itoa(ExchangeFormatVersion)}},
+	})
+}
+
+func (receiver *xmlRowEncoder) encode(item interface{}) error {
+	return receiver.encoder.EncodeElement(item, xml.StartElement{Name: xml.Name{Local: receiver.field}})
+}
+
+func (receiver *xmlRowEncoder) end() error {
+	err := receiver.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: receiver.root}})
+	if err != nil {
+		return err
+	}
+	return receiver.encoder.Flush()
+}
+
+// csvRowEncoder encodes every record separately so multibyte runes are never split between chunks
+type csvRowEncoder struct {
+	w       io.Writer
+	spec    csvSpec
+	options CSVOptions
+	buffer  bytes.Buffer
+	writer  *csv.Writer
+}
+
+func newCSVRowEncoder(w io.Writer, spec csvSpec, options CSVOptions) *csvRowEncoder {
+	encoder := &csvRowEncoder{w: w, spec: spec, options: options}
+	encoder.writer = csv.NewWriter(&encoder.buffer)
+	encoder.writer.Comma = options.delimiter()
+	return encoder
+}
+
+func (receiver *csvRowEncoder) begin() error {
+	if receiver.options.Encoding == EncodingUTF8BOM {
+		_, err := receiver.w.Write(utf8BOM)
+		if err != nil {
+			return err
+		}
+	}
+	header := make([]string, len(receiver.spec.fields))
+	for index, field := range receiver.spec.fields {
+		header[index] = receiver.options.column(field)
+	}
+	return receiver.write(header)
+}
+
+func (receiver *csvRowEncoder) encode(item interface{}) error {
+	return receiver.write(receiver.spec.toRecord(item))
+}
+
+func (receiver *csvRowEncoder) end() error {
+	return nil
+}
+
+func (receiver *csvRowEncoder) write(record []string) error {
+	receiver.buffer.Reset()
+	err := receiver.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	receiver.writer.Flush()
+	err = receiver.writer.Error()
+	if err != nil {
+		return err
+	}
+
+	encoding := receiver.options.Encoding
+	if encoding == EncodingUTF8BOM {
+		encoding = EncodingUTF8
+	}
+	data, err := encodeText(receiver.buffer.Bytes(), encoding)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.w.Write(data)
+	return err
+}
+
+func (receiver exchangeEntity) encoder(w io.Writer, format string, options CSVOptions) (rowEncoder, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonRowEncoder{w: w, root: receiver.root}, nil
+	case FormatXML:
+		return &xmlRowEncoder{encoder: xml.NewEncoder(w), root: receiver.root, field: receiver.field}, nil
+	case FormatCSV:
+		_, err := encodeText(nil, options.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVRowEncoder(w, receiver.csv, options), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+func (receiver exchangeEntity) stream(w io.Writer, format string, options ExportOptions, db *sql.DB) error {
+	entity, err := receiver.withProfile(options.Profile)
+	if err != nil {
+		return err
+	}
+	encoder, err := entity.encoder(w, format, options.CSV)
+	if err != nil {
+		return err
+	}
+	return streamQueryToWriter(db, entity.query, encoder, options.Progress, entity.mapRow)
+}
+
+// StreamClients writes clients row by row, progress may be nil
+func StreamClients(w io.Writer, format string, progress ExportProgress, db *sql.DB) error {
+	return clientsExchange.stream(w, format, ExportOptions{Progress: progress}, db)
+}
+
+func StreamAtms(w io.Writer, format string, progress ExportProgress, db *sql.DB) error {
+	return atmsExchange.stream(w, format, ExportOptions{Progress: progress}, db)
+}
+
+func StreamServices(w io.Writer, format string, progress ExportProgress, db *sql.DB) error {
+	return servicesExchange.stream(w, format, ExportOptions{Progress: progress}, db)
+}
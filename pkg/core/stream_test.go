@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestStreamClients_MatchesMarshal(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	clients := []Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya <&>", Login: "petya", Password: "secret", Balance: 200, BalanceNumber: 1002, PhoneNumber: 992900000002},
+		{Name: "Masha", Login: "masha", Password: "secret", Balance: 300, BalanceNumber: 1003, PhoneNumber: 992900000003},
+	}
+	for _, client := range clients {
+		err = AddClients(client, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+	exported := ClientsExport{Version: ExchangeFormatVersion}
+	for index, client := range clients {
+		client.Id, client.Password, client.Version = int64(index+1), "", 1
+		exported.Clients = append(exported.Clients, client)
+	}
+
+	for format, marshal := range map[string]Marshaller{FormatJSON: json.Marshal, FormatXML: xml.Marshal} {
+		var progress []int64
+		buffer := &bytes.Buffer{}
+		err = StreamClients(buffer, format, func(rows int64) {
+			progress = append(progress, rows)
+		}, db)
+		if err != nil {
+			t.Fatalf("can't stream %s: %v", format, err)
+		}
+		if len(progress) != 3 || progress[2] != 3 {
+			t.Errorf("unexpected progress for %s: %v", format, progress)
+		}
+
+		expected, err := marshal(exported)
+		if err != nil {
+			t.Fatalf("can't marshal %s: %v", format, err)
+		}
+		if buffer.String() != string(expected) {
+			t.Errorf("stream differs from %s marshal:\n%s\n%s", format, buffer, expected)
+		}
+	}
+}
+
+func TestStreamAtms_CSVWithBOM(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Errorf("can't open db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = AddAtm(Atm{Name: "Банкомат", Address: "Рудаки 1"}, db)
+	if err != nil {
+		t.Fatalf("can't add atm: %v", err)
+	}
+
+	options := CSVOptions{Encoding: EncodingUTF8BOM}
+	buffer := &bytes.Buffer{}
+	err = atmsExchange.stream(buffer, FormatCSV, ExportOptions{CSV: options}, db)
+	if err != nil {
+		t.Fatalf("can't stream csv: %v", err)
+	}
+	if bytes.Count(buffer.Bytes(), utf8BOM) != 1 {
+		t.Errorf("expected single BOM: %q", buffer)
+	}
+	records, err := mapBytesToCSVRecords(buffer.Bytes(), atmCSVSpec, options)
+	if err != nil || len(records) != 1 || records[0].(csvRecord).value.(Atm).Name != "Банкомат" {
+		t.Errorf("unexpected records: %v %v", records, err)
+	}
+
+	err = atmsExchange.stream(buffer, FormatCSV, ExportOptions{CSV: CSVOptions{Encoding: "koi8-r"}}, db)
+	if err != ErrUnknownEncoding {
+		t.Errorf("Not ErrUnknownEncoding for koi8-r: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrInsufficientFunds = errors.New("not enough money on account")
+
+const (
+	TransactionTransfer = "transfer"
+	TransactionPayment  = "payment"
+	TransactionTopUp    = "top_up"
+)
+
+// Transaction is one balance movement, zero balance number means money came from or went to outside
+type Transaction struct {
+	Id                 int64
+	Kind               string
+	PayerBalanceNumber uint64
+	PayeeBalanceNumber uint64
+	ServiceId          int64
+	Amount             uint64
+	Reference          string
+	CreatedAt          int64
+}
+
+// checkAffected reports missing account when balance update touched nothing
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// checkDebited tells missing account from account with too little money when debit touched nothing
+func checkDebited(result sql.Result, balanceNumber uint64, tx *sql.Tx) error {
+	err := checkAffected(result)
+	if err != ErrNotFound {
+		return err
+	}
+	var id int64
+	err = tx.QueryRow(checkDebitedAccountSQL, balanceNumber).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return queryError(checkDebitedAccountSQL, err)
+	}
+	return ErrInsufficientFunds
+}
+
+func scanTransaction(row rowScanner) (transaction Transaction, err error) {
+	err = row.Scan(&transaction.Id, &transaction.Kind, &transaction.PayerBalanceNumber,
+		&transaction.PayeeBalanceNumber, &transaction.ServiceId, &transaction.Amount, &transaction.Reference, &transaction.CreatedAt)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+func recordTransfer(payer uint64, payee uint64, amount uint64, tx *sql.Tx) (id int64, err error) {
+	result, err := tx.Exec(
+		insertTransactionSQL,
+		sql.Named("kind", TransactionTransfer),
+		sql.Named("payer_balance_number", payer),
+		sql.Named("payee_balance_number", payee),
+		sql.Named("service_id", 0),
+		sql.Named("amount", amount),
+		sql.Named("reference", ""),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func recordTransferByPhoneNumber(payer uint64, phoneNumber int64, amount uint64, tx *sql.Tx) (id int64, err error) {
+	result, err := tx.Exec(
+		insertTransferByPhoneNumberSQL,
+		sql.Named("kind", TransactionTransfer),
+		sql.Named("payer_balance_number", payer),
+		sql.Named("phone_number", phoneNumber),
+		sql.Named("amount", amount),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func recordPayment(payer uint64, serviceId int64, amount uint64, reference string, tx *sql.Tx) (id int64, err error) {
+	result, err := tx.Exec(
+		insertTransactionSQL,
+		sql.Named("kind", TransactionPayment),
+		sql.Named("payer_balance_number", payer),
+		sql.Named("payee_balance_number", 0),
+		sql.Named("service_id", serviceId),
+		sql.Named("amount", amount),
+		sql.Named("reference", reference),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func recordTopUp(login string, amount uint64, tx *sql.Tx) (id int64, err error) {
+	result, err := tx.Exec(
+		insertTopUpSQL,
+		sql.Named("kind", TransactionTopUp),
+		sql.Named("login", login),
+		sql.Named("amount", amount),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
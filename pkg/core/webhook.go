@@ -0,0 +1,453 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types managers can subscribe to
+const (
+	EventTransferCompleted = "transfer.completed"
+	EventPaymentCompleted  = "payment.completed"
+	EventBalanceToppedUp   = "balance.topped_up"
+	EventLoginLocked       = "login.locked"
+)
+
+var eventTypes = []string{EventTransferCompleted, EventPaymentCompleted, EventBalanceToppedUp, EventLoginLocked}
+
+// Statuses of outbox events, dead events are not retried until RetryDeadEvent
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+// Headers of webhook requests, signature is "sha256=" and hex HMAC-SHA256 of timestamp, "." and body
+const (
+	WebhookIdHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var ErrInvalidWebhookURL = errors.New("webhook url must be absolute http or https url")
+var ErrUnknownEvent = errors.New("unknown event type")
+
+type Webhook struct {
+	Id        int64
+	URL       string
+	Secret    string
+	Events    []string
+	Disabled  bool
+	CreatedAt int64
+}
+
+// Event is body of webhook request, Data is Receipt for transfers and payments
+type Event struct {
+	Type      string      `json:"type"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// TopUp is data of balance.topped_up event
+type TopUp struct {
+	Login  string `json:"login"`
+	Amount uint64 `json:"amount"`
+}
+
+// LoginLock is data of login.locked event
+type LoginLock struct {
+	Login       string `json:"login"`
+	LockedUntil int64  `json:"locked_until"`
+}
+
+// OutboxEvent is event waiting for delivery to one webhook
+type OutboxEvent struct {
+	Id            int64
+	WebhookId     int64
+	Event         string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt int64
+	LastError     string
+	CreatedAt     int64
+}
+
+func checkWebhook(webhook Webhook) error {
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	if webhook.Secret == "" {
+		return fmt.Errorf("%w: secret", ErrEmptyField)
+	}
+	if len(webhook.Events) == 0 {
+		return fmt.Errorf("%w: events", ErrEmptyField)
+	}
+	for _, event := range webhook.Events {
+		known := false
+		for _, eventType := range eventTypes {
+			known = known || event == eventType
+		}
+		if !known {
+			return fmt.Errorf("%w: %s", ErrUnknownEvent, event)
+		}
+	}
+	return nil
+}
+
+// AddWebhook subscribes url to events, only events published after it are delivered
+func AddWebhook(webhook Webhook, db *sql.DB) (err error) {
+	err = checkWebhook(webhook)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		insertWebhookSQL,
+		sql.Named("url", webhook.URL),
+		sql.Named("secret", webhook.Secret),
+		sql.Named("events", strings.Join(webhook.Events, ",")),
+	)
+	if err != nil {
+		return queryError(insertWebhookSQL, err)
+	}
+	return nil
+}
+
+func GetWebhooks(db *sql.DB) (webhooks []Webhook, err error) {
+	rows, err := db.Query(getWebhooksSQL)
+	if err != nil {
+		return nil, queryError(getWebhooksSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			webhooks, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		webhook := Webhook{}
+		var events string
+		err = rows.Scan(&webhook.Id, &webhook.URL, &webhook.Secret, &events, &webhook.Disabled, &webhook.CreatedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		webhook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, webhook)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+	return webhooks, nil
+}
+
+// SetWebhookDisabled pauses webhook, events published meanwhile are not collected for it
+// and collected ones wait until it is enabled again. Webhook without secret, e.g. restored
+// from redacted backup, gets no events even when enabled.
+func SetWebhookDisabled(id int64, disabled bool, db *sql.DB) (err error) {
+	return setDisabled(setWebhookDisabledSQL, id, disabled, db)
+}
+
+// RemoveWebhook deletes webhook with its undelivered events
+func RemoveWebhook(id int64, db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	_, err = tx.Exec(removeWebhookOutboxSQL, id)
+	if err != nil {
+		return queryError(removeWebhookOutboxSQL, err)
+	}
+	result, err := tx.Exec(removeWebhookSQL, id)
+	if err != nil {
+		return queryError(removeWebhookSQL, err)
+	}
+	return checkAffected(result)
+}
+
+// publishEvent writes event to outbox of every subscribed webhook in transaction of money movement,
+// so event is delivered if and only if movement is committed
+func publishEvent(eventType string, data interface{}, tx execer) error {
+	payload, err := json.Marshal(Event{Type: eventType, CreatedAt: time.Now().Unix(), Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		publishEventSQL,
+		sql.Named("event", eventType),
+		sql.Named("payload", string(payload)),
+	)
+	if err != nil {
+		return queryError(publishEventSQL, err)
+	}
+	return nil
+}
+
+// issueReceiptAndPublish issues receipt of transaction and publishes it as event in the same transaction
+func issueReceiptAndPublish(eventType string, transactionId int64, tx *sql.Tx) (Receipt, error) {
+	receipt, err := issueReceipt(transactionId, tx)
+	if err != nil {
+		return Receipt{}, err
+	}
+	err = publishEvent(eventType, receipt, tx)
+	if err != nil {
+		return Receipt{}, err
+	}
+	return receipt, nil
+}
+
+// LockLogin is for authentication layers which lock login after failed attempts, e.g. terminal.
+// Login refuses locked login with ErrLoginLocked until given time, login.locked event is published
+// in the same transaction
+func LockLogin(login string, until time.Time, db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	_, err = tx.Exec(
+		lockLoginSQL,
+		sql.Named("locked_until", until.Unix()),
+		sql.Named("login", login),
+	)
+	if err != nil {
+		return queryError(lockLoginSQL, err)
+	}
+	return publishEvent(EventLoginLocked, LoginLock{Login: login, LockedUntil: until.Unix()}, tx)
+}
+
+// GetEventsByStatus lists outbox events, e.g. OutboxDead for dead letters
+func GetEventsByStatus(status string, db *sql.DB) (events []OutboxEvent, err error) {
+	rows, err := db.Query(getEventsByStatusSQL, status)
+	if err != nil {
+		return nil, queryError(getEventsByStatusSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			events, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		event, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		events = append(events, event)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+	return events, nil
+}
+
+// RetryDeadEvent returns dead event to delivery with fresh attempts
+func RetryDeadEvent(id int64, db *sql.DB) (err error) {
+	result, err := db.Exec(retryDeadEventSQL, sql.Named("id", id), sql.Named("now", time.Now().Unix()))
+	if err != nil {
+		return queryError(retryDeadEventSQL, err)
+	}
+	return checkAffected(result)
+}
+
+func scanOutboxEvent(row rowScanner, extra ...interface{}) (event OutboxEvent, err error) {
+	err = row.Scan(append([]interface{}{&event.Id, &event.WebhookId, &event.Event, &event.Payload, &event.Status,
+		&event.Attempts, &event.NextAttemptAt, &event.LastError, &event.CreatedAt}, extra...)...)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	return event, nil
+}
+
+// SignWebhook returns value of signature header, receivers compare it with hmac.Equal
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers outbox events, run only one dispatcher per database
+type Dispatcher struct {
+	DB          *sql.DB
+	Client      *http.Client
+	MaxAttempts int
+	BatchSize   int
+	// Backoff returns delay before next attempt after given number of failed attempts
+	Backoff func(attempts int) time.Duration
+	Now     func() time.Time
+}
+
+// NewDispatcher delivers up to 100 events per pass and gives up after 8 attempts,
+// delays grow from 30 seconds to 1 hour
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8,
+		BatchSize:   100,
+		Backoff:     ExponentialBackoff(30*time.Second, time.Hour),
+		Now:         time.Now,
+	}
+}
+
+// ExponentialBackoff doubles delay after every failed attempt up to max
+func ExponentialBackoff(initial time.Duration, max time.Duration) func(attempts int) time.Duration {
+	return func(attempts int) time.Duration {
+		delay := initial
+		for attempt := 1; attempt < attempts && delay < max; attempt++ {
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+type dueEvent struct {
+	OutboxEvent
+	url    string
+	secret string
+}
+
+// DispatchPending makes one delivery attempt for every due event and returns number of delivered events
+func (receiver *Dispatcher) DispatchPending(ctx context.Context) (delivered int, err error) {
+	events, err := receiver.dueEvents()
+	if err != nil {
+		return 0, err
+	}
+	for _, event := range events {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		deliveryErr := receiver.deliver(ctx, event)
+		if deliveryErr == nil {
+			_, err = receiver.DB.Exec(markEventDeliveredSQL, sql.Named("id", event.Id))
+			if err != nil {
+				return delivered, queryError(markEventDeliveredSQL, err)
+			}
+			delivered++
+			continue
+		}
+
+		attempts := event.Attempts + 1
+		status := OutboxPending
+		if attempts >= receiver.MaxAttempts {
+			status = OutboxDead
+		}
+		_, err = receiver.DB.Exec(
+			markEventFailedSQL,
+			sql.Named("id", event.Id),
+			sql.Named("status", status),
+			sql.Named("next_attempt_at", receiver.Now().Add(receiver.Backoff(attempts)).Unix()),
+			sql.Named("last_error", deliveryErr.Error()),
+		)
+		if err != nil {
+			return delivered, queryError(markEventFailedSQL, err)
+		}
+	}
+	return delivered, nil
+}
+
+// Run dispatches pending events every interval until ctx is done
+func (receiver *Dispatcher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := receiver.DispatchPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (receiver *Dispatcher) dueEvents() (events []dueEvent, err error) {
+	rows, err := receiver.DB.Query(getDueEventsSQL, sql.Named("now", receiver.Now().Unix()), sql.Named("limit", receiver.BatchSize))
+	if err != nil {
+		return nil, queryError(getDueEventsSQL, err)
+	}
+	defer func() {
+		if innerErr := rows.Close(); innerErr != nil {
+			events, err = nil, dbError(innerErr)
+		}
+	}()
+
+	for rows.Next() {
+		event := dueEvent{}
+		event.OutboxEvent, err = scanOutboxEvent(rows, &event.url, &event.secret)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		events = append(events, event)
+	}
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+	return events, nil
+}
+
+// deliver posts event, any response except 2xx is failed attempt
+func (receiver *Dispatcher) deliver(ctx context.Context, event dueEvent) error {
+	body := []byte(event.Payload)
+	request, err := http.NewRequest(http.MethodPost, event.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	timestamp := strconv.FormatInt(receiver.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookIdHeader, strconv.FormatInt(event.Id, 10))
+	request.Header.Set(WebhookEventHeader, event.Event)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(event.secret, timestamp, body))
+
+	response, err := receiver.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<16))
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
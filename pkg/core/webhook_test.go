@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	var bodies []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhook("key", r.Header.Get(WebhookTimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies = append(bodies, r.Header.Get(WebhookEventHeader)+" "+string(body))
+	}))
+	defer receiver.Close()
+
+	err := AddWebhook(Webhook{URL: receiver.URL, Secret: "key", Events: []string{EventTransferCompleted, EventBalanceToppedUp}}, db)
+	if err != nil {
+		t.Fatalf("can't add webhook: %v", err)
+	}
+	err = AddWebhook(Webhook{URL: "ftp://example.com", Secret: "key", Events: []string{EventTransferCompleted}}, db)
+	if err != ErrInvalidWebhookURL {
+		t.Errorf("Not ErrInvalidWebhookURL for ftp: %v", err)
+	}
+	err = AddWebhook(Webhook{URL: receiver.URL, Secret: "key", Events: []string{"transfer.started"}}, db)
+	if !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("Not ErrUnknownEvent for transfer.started: %v", err)
+	}
+
+	for _, client := range []Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya", Login: "petya", Password: "secret", BalanceNumber: 1002, PhoneNumber: 992900000002},
+	} {
+		err = AddClients(client, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+	_, err = TransferByBalanceNumber(1001, 40, Client{BalanceNumber: 1002, Balance: 40}, db)
+	if err != nil {
+		t.Fatalf("can't transfer: %v", err)
+	}
+	_, err = TransferByBalanceNumber(1001, 10, Client{BalanceNumber: 9999, Balance: 10}, db)
+	if err != ErrNotFound {
+		t.Errorf("Not ErrNotFound for unknown payee: %v", err)
+	}
+	err = UpdateBalance(Client{Login: "petya", Balance: 5}, db)
+	if err != nil {
+		t.Fatalf("can't top up: %v", err)
+	}
+
+	delivered, err := NewDispatcher(db).DispatchPending(context.Background())
+	if err != nil || delivered != 2 || len(bodies) != 2 {
+		t.Fatalf("unexpected delivery: %d %v %v", delivered, bodies, err)
+	}
+	if !strings.HasPrefix(bodies[0], EventTransferCompleted+` {"type":"transfer.completed"`) ||
+		!strings.Contains(bodies[0], `"amount":40`) || !strings.Contains(bodies[1], `"login":"petya","amount":5`) {
+		t.Errorf("unexpected bodies: %v", bodies)
+	}
+	delivered, err = NewDispatcher(db).DispatchPending(context.Background())
+	if err != nil || delivered != 0 {
+		t.Errorf("events delivered twice: %d %v", delivered, err)
+	}
+}
+
+func TestDispatcher_RetriesAndDeadLetters(t *testing.T) {
+	db := openInitDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	failing := true
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	err := AddWebhook(Webhook{URL: receiver.URL, Secret: "key", Events: []string{EventLoginLocked}}, db)
+	if err != nil {
+		t.Fatalf("can't add webhook: %v", err)
+	}
+	err = LockLogin("vasya", time.Now().Add(time.Minute), db)
+	if err != nil {
+		t.Fatalf("can't publish: %v", err)
+	}
+
+	now := time.Now()
+	dispatcher := NewDispatcher(db)
+	dispatcher.MaxAttempts = 3
+	dispatcher.Backoff = ExponentialBackoff(time.Minute, time.Hour)
+	dispatcher.Now = func() time.Time {
+		return now
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		delivered, err := dispatcher.DispatchPending(context.Background())
+		if err != nil || delivered != 0 {
+			t.Fatalf("unexpected result of attempt %d: %d %v", attempt, delivered, err)
+		}
+		delivered, err = dispatcher.DispatchPending(context.Background())
+		if err != nil || delivered != 0 {
+			t.Fatalf("unexpected result before back-off: %d %v", delivered, err)
+		}
+		now = now.Add(dispatcher.Backoff(attempt))
+	}
+
+	dead, err := GetEventsByStatus(OutboxDead, db)
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 3 || !strings.Contains(dead[0].LastError, "503") {
+		t.Fatalf("unexpected dead letters: %v %v", dead, err)
+	}
+	failing = false
+	err = RetryDeadEvent(dead[0].Id, db)
+	if err != nil {
+		t.Fatalf("can't retry: %v", err)
+	}
+	delivered, err := dispatcher.DispatchPending(context.Background())
+	if err != nil || delivered != 1 {
+		t.Errorf("dead letter not delivered after retry: %d %v", delivered, err)
+	}
+	err = RetryDeadEvent(dead[0].Id, db)
+	if err != ErrNotFound {
+		t.Errorf("Not ErrNotFound for delivered event: %v", err)
+	}
+}
+
+func TestLockLogin_LoginRefusedUntilExpired(t *testing.T) {
+	db := openFinanceDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err := LockLogin("vasya", time.Now().Add(time.Minute), db)
+	if err != nil {
+		t.Fatalf("can't lock login: %v", err)
+	}
+	_, ok, err := Login("vasya", "secret", db)
+	if ok || err != ErrLoginLocked {
+		t.Errorf("locked login is accepted: %v %v", ok, err)
+	}
+	_, ok, err = Login("petya", "secret", db)
+	if !ok || err != nil {
+		t.Errorf("other login is locked: %v %v", ok, err)
+	}
+
+	err = LockLogin("vasya", time.Now().Add(-time.Second), db)
+	if err != nil {
+		t.Fatalf("can't lock login: %v", err)
+	}
+	_, ok, err = Login("vasya", "secret", db)
+	if !ok || err != nil {
+		t.Errorf("expired lock still refuses login: %v %v", ok, err)
+	}
+}
@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"errors"
+	netrpc "net/rpc"
+	"strings"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/auth"
+)
+
+// Error codes, JSON-RPC 1.0 error is only string so it is sent as "<code>: <message>"
+const (
+	CodeInvalidRequest     = auth.CodeInvalidRequest
+	CodeInvalidCredentials = auth.CodeInvalidCredentials
+	CodeLoginLocked        = auth.CodeLoginLocked
+	CodeUnauthorized       = auth.CodeUnauthorized
+	CodeForbidden          = auth.CodeForbidden
+	CodeNotFound           = auth.CodeNotFound
+	CodeConflict           = auth.CodeConflict
+	CodeValidationFailed   = auth.CodeValidationFailed
+	CodeInsufficientFunds  = auth.CodeInsufficientFunds
+	CodeDatabaseError      = auth.CodeDatabaseError
+	CodeInternalError      = auth.CodeInternalError
+)
+
+// Error is error returned by service methods
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (receiver *Error) Error() string {
+	return receiver.Code + ": " + receiver.Message
+}
+
+var errUnauthorized = &Error{Code: CodeUnauthorized, Message: "valid token required"}
+
+// toError converts error of core or auth to Error sent to client
+func toError(err error) *Error {
+	var typedErr *Error
+	if errors.As(err, &typedErr) {
+		return typedErr
+	}
+	classified := auth.Classify(err)
+	return &Error{Code: classified.Code, Message: classified.Message}
+}
+
+// ErrorCode returns code of error received by client, empty for errors not sent by service
+func ErrorCode(err error) string {
+	serverErr, ok := err.(netrpc.ServerError)
+	if !ok {
+		return ""
+	}
+	index := strings.Index(string(serverErr), ": ")
+	if index < 0 {
+		return ""
+	}
+	return string(serverErr)[:index]
+}
@@ -0,0 +1,230 @@
+// Package rpc serves client operations of core as JSON-RPC 1.0 over TCP, every call
+// except Bank.Login carries token returned by Bank.Login:
+//
+//	{"method":"Bank.Login","params":[{"Login":"vasya","Password":"secret"}],"id":1}
+//	{"method":"Bank.Balances","params":[{"Token":"..."}],"id":2}
+package rpc
+
+import (
+	"database/sql"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/auth"
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+)
+
+// ServiceName is prefix of methods, e.g. Bank.Login
+const ServiceName = "Bank"
+
+// Service methods are called by net/rpc, so they keep its signature
+type Service struct {
+	db     *sql.DB
+	tokens *auth.Tokens
+}
+
+func NewService(db *sql.DB) *Service {
+	return &Service{db: db, tokens: auth.NewTokens(auth.TokenTTL)}
+}
+
+// NewServer returns net/rpc server with service registered as ServiceName
+func NewServer(service *Service) (*netrpc.Server, error) {
+	server := netrpc.NewServer()
+	err := server.RegisterName(ServiceName, service)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Serve accepts connections until listener is closed and serves each with JSON codec
+func Serve(listener net.Listener, db *sql.DB) error {
+	server, err := NewServer(NewService(db))
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+type LoginArgs struct {
+	Login    string
+	Password string
+}
+
+type LoginReply struct {
+	Token     string
+	ExpiresAt int64
+}
+
+type TokenArgs struct {
+	Token string
+}
+
+type Empty struct{}
+
+type Account struct {
+	Name          string
+	BalanceNumber uint64
+	Balance       uint64
+}
+
+type BalancesReply struct {
+	Accounts []Account
+}
+
+type AtmsReply struct {
+	Atms []core.Atm
+}
+
+type ServicesReply struct {
+	Services []core.Services
+}
+
+type TransferByPhoneNumberArgs struct {
+	Token       string
+	From        uint64
+	PhoneNumber int64
+	Amount      uint64
+}
+
+type TransferByBalanceNumberArgs struct {
+	Token  string
+	From   uint64
+	To     uint64
+	Amount uint64
+}
+
+type PaymentArgs struct {
+	Token     string
+	From      uint64
+	ServiceId int64
+	Reference string
+	Amount    uint64
+}
+
+func (receiver *Service) client(token string) (auth.Session, error) {
+	owner, ok := receiver.tokens.Find(token)
+	if !ok || owner.Role != auth.RoleClient {
+		return auth.Session{}, errUnauthorized
+	}
+	return owner, nil
+}
+
+func (receiver *Service) Login(args *LoginArgs, reply *LoginReply) error {
+	id, ok, err := core.Login(args.Login, args.Password, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	if !ok {
+		return toError(auth.ErrInvalidCredentials)
+	}
+	token, owner, err := receiver.tokens.Issue(auth.Session{Role: auth.RoleClient, Login: args.Login, ClientId: id})
+	if err != nil {
+		return toError(err)
+	}
+	*reply = LoginReply{Token: token, ExpiresAt: owner.Expires.Unix()}
+	return nil
+}
+
+func (receiver *Service) Logout(args *TokenArgs, _ *Empty) error {
+	receiver.tokens.Revoke(args.Token)
+	return nil
+}
+
+func (receiver *Service) Balances(args *TokenArgs, reply *BalancesReply) error {
+	owner, err := receiver.client(args.Token)
+	if err != nil {
+		return err
+	}
+	accounts, err := core.GetBalanceList(receiver.db, owner.ClientId)
+	if err != nil {
+		return toError(err)
+	}
+	reply.Accounts = make([]Account, len(accounts))
+	for index, account := range accounts {
+		reply.Accounts[index] = Account{Name: account.Name, BalanceNumber: account.BalanceNumber, Balance: account.Balance}
+	}
+	return nil
+}
+
+func (receiver *Service) Atms(args *TokenArgs, reply *AtmsReply) error {
+	_, err := receiver.client(args.Token)
+	if err != nil {
+		return err
+	}
+	reply.Atms, err = core.GetAllAtms(receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	return nil
+}
+
+func (receiver *Service) Services(args *TokenArgs, reply *ServicesReply) error {
+	_, err := receiver.client(args.Token)
+	if err != nil {
+		return err
+	}
+	reply.Services, err = core.GetServices(receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	return nil
+}
+
+func (receiver *Service) TransferByPhoneNumber(args *TransferByPhoneNumberArgs, reply *core.Receipt) error {
+	owner, err := receiver.client(args.Token)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckDebit(owner, args.From, args.Amount, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	*reply, err = core.TransferByPhoneNumber(args.From, args.Amount,
+		core.Client{PhoneNumber: args.PhoneNumber, Balance: args.Amount}, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	return nil
+}
+
+func (receiver *Service) TransferByBalanceNumber(args *TransferByBalanceNumberArgs, reply *core.Receipt) error {
+	owner, err := receiver.client(args.Token)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckDebit(owner, args.From, args.Amount, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	*reply, err = core.TransferByBalanceNumber(args.From, args.Amount,
+		core.Client{BalanceNumber: args.To, Balance: args.Amount}, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	return nil
+}
+
+func (receiver *Service) PayForService(args *PaymentArgs, reply *core.Receipt) error {
+	owner, err := receiver.client(args.Token)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckDebit(owner, args.From, args.Amount, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	*reply, err = core.PayForServices(args.From, args.Amount, args.Reference,
+		core.Services{Id: args.ServiceId}, receiver.db)
+	if err != nil {
+		return toError(err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"database/sql"
+	"net"
+	"net/rpc/jsonrpc"
+	"testing"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestService_OverTCP(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err = core.Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	for _, client := range []core.Client{
+		{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001},
+		{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002},
+	} {
+		err = core.AddClients(client, db)
+		if err != nil {
+			t.Fatalf("can't add client: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		_ = Serve(listener, db)
+	}()
+	client, err := jsonrpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("can't dial: %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	login := LoginReply{}
+	err = client.Call("Bank.Login", LoginArgs{Login: "vasya", Password: "wrong"}, &login)
+	if ErrorCode(err) != CodeInvalidCredentials {
+		t.Errorf("unexpected error for wrong password: %v", err)
+	}
+	err = client.Call("Bank.Balances", TokenArgs{Token: "forged"}, &BalancesReply{})
+	if ErrorCode(err) != CodeUnauthorized {
+		t.Errorf("unexpected error for forged token: %v", err)
+	}
+	err = client.Call("Bank.Login", LoginArgs{Login: "vasya", Password: "secret"}, &login)
+	if err != nil || login.Token == "" {
+		t.Fatalf("can't login: %v", err)
+	}
+
+	receipt := core.Receipt{}
+	err = client.Call("Bank.TransferByPhoneNumber", TransferByPhoneNumberArgs{Token: login.Token, From: 1001, PhoneNumber: 992900000002, Amount: 40}, &receipt)
+	if err != nil || receipt.Amount != 40 || receipt.BalanceAfter != 60 {
+		t.Errorf("unexpected transfer: %v %v", receipt, err)
+	}
+	err = client.Call("Bank.TransferByBalanceNumber", TransferByBalanceNumberArgs{Token: login.Token, From: 1001, To: 1002, Amount: 100}, &receipt)
+	if ErrorCode(err) != CodeInsufficientFunds {
+		t.Errorf("unexpected error for large transfer: %v", err)
+	}
+	err = client.Call("Bank.PayForService", PaymentArgs{Token: login.Token, From: 1001, ServiceId: 42, Amount: 10}, &receipt)
+	if ErrorCode(err) != CodeNotFound {
+		t.Errorf("unexpected error for unknown service: %v", err)
+	}
+
+	balances := BalancesReply{}
+	err = client.Call("Bank.Balances", TokenArgs{Token: login.Token}, &balances)
+	if err != nil || len(balances.Accounts) != 1 || balances.Accounts[0].Balance != 60 {
+		t.Errorf("unexpected balances: %v %v", balances, err)
+	}
+	err = client.Call("Bank.Logout", TokenArgs{Token: login.Token}, &Empty{})
+	if err != nil {
+		t.Errorf("can't logout: %v", err)
+	}
+	err = client.Call("Bank.Atms", TokenArgs{Token: login.Token}, &AtmsReply{})
+	if ErrorCode(err) != CodeUnauthorized {
+		t.Errorf("token works after logout: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/auth"
+)
+
+// Error codes of error JSON, clients should switch on code and show message
+const (
+	CodeInvalidRequest     = auth.CodeInvalidRequest
+	CodeInvalidCredentials = auth.CodeInvalidCredentials
+	CodeLoginLocked        = auth.CodeLoginLocked
+	CodeUnauthorized       = auth.CodeUnauthorized
+	CodeForbidden          = auth.CodeForbidden
+	CodeNotFound           = auth.CodeNotFound
+	CodeMethodNotAllowed   = auth.CodeMethodNotAllowed
+	CodeConflict           = auth.CodeConflict
+	CodeValidationFailed   = auth.CodeValidationFailed
+	CodeInsufficientFunds  = auth.CodeInsufficientFunds
+	CodeDatabaseError      = auth.CodeDatabaseError
+	CodeInternalError      = auth.CodeInternalError
+)
+
+// ErrorResponse is body of every failed request
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// codeStatuses are HTTP statuses of error codes
+var codeStatuses = map[string]int{
+	CodeInvalidRequest:     http.StatusBadRequest,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeLoginLocked:        http.StatusLocked,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	CodeConflict:           http.StatusConflict,
+	CodeValidationFailed:   http.StatusUnprocessableEntity,
+	CodeInsufficientFunds:  http.StatusUnprocessableEntity,
+	CodeDatabaseError:      http.StatusInternalServerError,
+	CodeInternalError:      http.StatusInternalServerError,
+}
+
+var errUnauthorized = &auth.Error{Code: CodeUnauthorized, Message: "valid bearer token required"}
+var errForbidden = &auth.Error{Code: CodeForbidden, Message: "operation is not allowed for this token"}
+var errMethodNotAllowed = &auth.Error{Code: CodeMethodNotAllowed, Message: "method not allowed"}
+var errNotFound = &auth.Error{Code: CodeNotFound, Message: "not found"}
+
+func invalidRequest(message string) *auth.Error {
+	return &auth.Error{Code: CodeInvalidRequest, Message: message}
+}
+
+// mapError chooses status and body for error, details of database errors are only logged
+func mapError(err error) (int, ErrorResponse) {
+	classified := auth.Classify(err)
+	return codeStatuses[classified.Code], ErrorResponse{Error: classified.Code, Message: classified.Message}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Printf("can't write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, body := mapError(err)
+	writeJSON(w, status, body)
+}
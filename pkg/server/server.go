@@ -0,0 +1,372 @@
+// Package server exposes core operations as JSON over HTTP
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/auth"
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+)
+
+// maxBodySize limits request bodies, all requests are small JSON objects
+const maxBodySize = 1 << 20
+
+type Server struct {
+	db     *sql.DB
+	tokens *auth.Tokens
+	mux    *http.ServeMux
+}
+
+// handler returns error which is written as error JSON, owner is empty for public endpoints
+type handler func(w http.ResponseWriter, r *http.Request, owner auth.Session) error
+
+func NewServer(db *sql.DB) *Server {
+	server := &Server{db: db, tokens: auth.NewTokens(auth.TokenTTL), mux: http.NewServeMux()}
+	server.routes()
+	return server
+}
+
+func (receiver *Server) routes() {
+	receiver.handle("/api/client/login", http.MethodPost, "", receiver.handleClientLogin)
+	receiver.handle("/api/manager/login", http.MethodPost, "", receiver.handleManagerLogin)
+	receiver.handle("/api/logout", http.MethodPost, "", receiver.handleLogout)
+	receiver.handle("/api/atms", http.MethodGet, "", receiver.handleAtms)
+	receiver.handle("/api/services", http.MethodGet, "", receiver.handleServices)
+
+	receiver.handle("/api/client/accounts", http.MethodGet, auth.RoleClient, receiver.handleAccounts)
+	receiver.handle("/api/client/transfers/phone", http.MethodPost, auth.RoleClient, receiver.handleTransferByPhone)
+	receiver.handle("/api/client/transfers/account", http.MethodPost, auth.RoleClient, receiver.handleTransferByAccount)
+	receiver.handle("/api/client/payments", http.MethodPost, auth.RoleClient, receiver.handlePayment)
+
+	receiver.mux.Handle("/api/manager/clients", receiver.methods(map[string]http.Handler{
+		http.MethodGet:  receiver.wrap(auth.RoleManager, receiver.handleListClients),
+		http.MethodPost: receiver.wrap(auth.RoleManager, receiver.handleAddClient),
+	}))
+	receiver.handle("/api/manager/atms", http.MethodPost, auth.RoleManager, receiver.handleAddAtm)
+	receiver.handle("/api/manager/services", http.MethodPost, auth.RoleManager, receiver.handleAddService)
+	receiver.handle("/api/manager/top-ups", http.MethodPost, auth.RoleManager, receiver.handleTopUp)
+
+	receiver.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, errNotFound)
+	})
+}
+
+func (receiver *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	receiver.mux.ServeHTTP(w, r)
+}
+
+func (receiver *Server) handle(pattern string, method string, role string, handler handler) {
+	receiver.mux.Handle(pattern, receiver.methods(map[string]http.Handler{method: receiver.wrap(role, handler)}))
+}
+
+func (receiver *Server) methods(handlers map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.Method]
+		if !ok {
+			allowed := make([]string, 0, len(handlers))
+			for method := range handlers {
+				allowed = append(allowed, method)
+			}
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, errMethodNotAllowed)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// wrap checks token when role is set and writes error returned by handler
+func (receiver *Server) wrap(role string, handler handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner := auth.Session{}
+		if role != "" {
+			var ok bool
+			owner, ok = receiver.tokens.Find(bearerToken(r))
+			if !ok {
+				writeError(w, errUnauthorized)
+				return
+			}
+			if owner.Role != role {
+				writeError(w, errForbidden)
+				return
+			}
+		}
+		err := handler(w, r, owner)
+		if err != nil {
+			writeError(w, err)
+		}
+	})
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, request interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(request)
+	if err != nil {
+		return invalidRequest("invalid JSON: " + err.Error())
+	}
+	return nil
+}
+
+func (receiver *Server) handleClientLogin(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	request := loginRequest{}
+	err := decodeRequest(w, r, &request)
+	if err != nil {
+		return err
+	}
+	id, ok, err := core.Login(request.Login, request.Password, receiver.db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return auth.ErrInvalidCredentials
+	}
+	return receiver.issueToken(w, auth.Session{Role: auth.RoleClient, Login: request.Login, ClientId: id})
+}
+
+func (receiver *Server) handleManagerLogin(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	request := loginRequest{}
+	err := decodeRequest(w, r, &request)
+	if err != nil {
+		return err
+	}
+	ok, err := core.LoginForManagers(request.Login, request.Password, receiver.db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return auth.ErrInvalidCredentials
+	}
+	return receiver.issueToken(w, auth.Session{Role: auth.RoleManager, Login: request.Login})
+}
+
+func (receiver *Server) issueToken(w http.ResponseWriter, owner auth.Session) error {
+	token, owner, err := receiver.tokens.Issue(owner)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, loginResponse{Token: token, ExpiresAt: owner.Expires.Unix()})
+	return nil
+}
+
+func (receiver *Server) handleLogout(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	receiver.tokens.Revoke(bearerToken(r))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (receiver *Server) handleAtms(w http.ResponseWriter, _ *http.Request, _ auth.Session) error {
+	atms, err := core.GetAllAtms(receiver.db)
+	if err != nil {
+		return err
+	}
+	if atms == nil {
+		atms = []core.Atm{}
+	}
+	writeJSON(w, http.StatusOK, atms)
+	return nil
+}
+
+func (receiver *Server) handleServices(w http.ResponseWriter, _ *http.Request, _ auth.Session) error {
+	services, err := core.GetServices(receiver.db)
+	if err != nil {
+		return err
+	}
+	if services == nil {
+		services = []core.Services{}
+	}
+	writeJSON(w, http.StatusOK, services)
+	return nil
+}
+
+type accountResponse struct {
+	Name          string `json:"name"`
+	BalanceNumber uint64 `json:"balance_number"`
+	Balance       uint64 `json:"balance"`
+}
+
+func (receiver *Server) handleAccounts(w http.ResponseWriter, _ *http.Request, owner auth.Session) error {
+	accounts, err := core.GetBalanceList(receiver.db, owner.ClientId)
+	if err != nil {
+		return err
+	}
+	response := make([]accountResponse, len(accounts))
+	for index, account := range accounts {
+		response[index] = accountResponse{Name: account.Name, BalanceNumber: account.BalanceNumber, Balance: account.Balance}
+	}
+	writeJSON(w, http.StatusOK, response)
+	return nil
+}
+
+type transferByPhoneRequest struct {
+	From        uint64 `json:"from_balance_number"`
+	PhoneNumber int64  `json:"phone_number"`
+	Amount      uint64 `json:"amount"`
+}
+
+func (receiver *Server) handleTransferByPhone(w http.ResponseWriter, r *http.Request, owner auth.Session) error {
+	request := transferByPhoneRequest{}
+	err := decodeRequest(w, r, &request)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckDebit(owner, request.From, request.Amount, receiver.db)
+	if err != nil {
+		return err
+	}
+	receipt, err := core.TransferByPhoneNumber(request.From, request.Amount,
+		core.Client{PhoneNumber: request.PhoneNumber, Balance: request.Amount}, receiver.db)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, receipt)
+	return nil
+}
+
+type transferByAccountRequest struct {
+	From   uint64 `json:"from_balance_number"`
+	To     uint64 `json:"to_balance_number"`
+	Amount uint64 `json:"amount"`
+}
+
+func (receiver *Server) handleTransferByAccount(w http.ResponseWriter, r *http.Request, owner auth.Session) error {
+	request := transferByAccountRequest{}
+	err := decodeRequest(w, r, &request)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckDebit(owner, request.From, request.Amount, receiver.db)
+	if err != nil {
+		return err
+	}
+	receipt, err := core.TransferByBalanceNumber(request.From, request.Amount,
+		core.Client{BalanceNumber: request.To, Balance: request.Amount}, receiver.db)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, receipt)
+	return nil
+}
+
+type paymentRequest struct {
+	From      uint64 `json:"from_balance_number"`
+	ServiceId int64  `json:"service_id"`
+	Reference string `json:"reference"`
+	Amount    uint64 `json:"amount"`
+}
+
+func (receiver *Server) handlePayment(w http.ResponseWriter, r *http.Request, owner auth.Session) error {
+	request := paymentRequest{}
+	err := decodeRequest(w, r, &request)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckDebit(owner, request.From, request.Amount, receiver.db)
+	if err != nil {
+		return err
+	}
+	receipt, err := core.PayForServices(request.From, request.Amount, request.Reference,
+		core.Services{Id: request.ServiceId}, receiver.db)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, receipt)
+	return nil
+}
+
+type clientsResponse struct {
+	Clients    []core.Client `json:"clients"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+func (receiver *Server) handleListClients(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	query := r.URL.Query()
+	options := core.ListOptions{Cursor: query.Get("cursor"), Search: query.Get("search")}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 0 {
+			return invalidRequest("limit must be positive number")
+		}
+	}
+	page, err := core.ListClients(options, receiver.db)
+	if err != nil {
+		return err
+	}
+	if page.Clients == nil {
+		page.Clients = []core.Client{}
+	}
+	writeJSON(w, http.StatusOK, clientsResponse{Clients: page.Clients, NextCursor: page.NextCursor})
+	return nil
+}
+
+func (receiver *Server) handleAddClient(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	client := core.Client{}
+	err := decodeRequest(w, r, &client)
+	if err != nil {
+		return err
+	}
+	if client.Login == "" || client.Password == "" {
+		return invalidRequest("login and password are required")
+	}
+	err = core.AddClients(client, receiver.db)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (receiver *Server) handleAddAtm(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	atm := core.Atm{}
+	err := decodeRequest(w, r, &atm)
+	if err != nil {
+		return err
+	}
+	err = core.AddAtm(atm, receiver.db)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (receiver *Server) handleAddService(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	service := core.Services{}
+	err := decodeRequest(w, r, &service)
+	if err != nil {
+		return err
+	}
+	err = core.AddServices(service, receiver.db)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+type topUpRequest struct {
+	Login  string `json:"login"`
+	Amount uint64 `json:"amount"`
+}
+
+func (receiver *Server) handleTopUp(w http.ResponseWriter, r *http.Request, _ auth.Session) error {
+	request := topUpRequest{}
+	err := decodeRequest(w, r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Amount == 0 {
+		return auth.ErrInvalidAmount
+	}
+	err = core.UpdateBalance(core.Client{Login: request.Login, Balance: request.Amount}, receiver.db)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
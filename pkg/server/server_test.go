@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openServer(t *testing.T) (*httptest.Server, *sql.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	err = core.Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = core.AddClients(core.Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = core.AddClients(core.Client{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	return httptest.NewServer(NewServer(db)), db
+}
+
+func call(t *testing.T, server *httptest.Server, method string, path string, token string, body interface{}, response interface{}) int {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("can't marshal request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request, err := http.NewRequest(method, server.URL+path, reader)
+	if err != nil {
+		t.Fatalf("can't create request: %v", err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	result, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("can't %s %s: %v", method, path, err)
+	}
+	defer func() {
+		_ = result.Body.Close()
+	}()
+	if response != nil {
+		err = json.NewDecoder(result.Body).Decode(response)
+		if err != nil {
+			t.Fatalf("can't decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return result.StatusCode
+}
+
+func login(t *testing.T, server *httptest.Server, path string, login string, password string) string {
+	response := loginResponse{}
+	status := call(t, server, http.MethodPost, path, "", loginRequest{Login: login, Password: password}, &response)
+	if status != http.StatusOK || response.Token == "" {
+		t.Fatalf("can't login %s: %d", login, status)
+	}
+	return response.Token
+}
+
+func TestServer_ClientTransfer(t *testing.T) {
+	server, db := openServer(t)
+	defer server.Close()
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	errorResponse := ErrorResponse{}
+	status := call(t, server, http.MethodPost, "/api/client/login", "", loginRequest{Login: "vasya", Password: "wrong"}, &errorResponse)
+	if status != http.StatusUnauthorized || errorResponse.Error != CodeInvalidCredentials {
+		t.Errorf("unexpected response for wrong password: %d %v", status, errorResponse)
+	}
+	status = call(t, server, http.MethodGet, "/api/client/accounts", "", nil, &errorResponse)
+	if status != http.StatusUnauthorized || errorResponse.Error != CodeUnauthorized {
+		t.Errorf("unexpected response without token: %d %v", status, errorResponse)
+	}
+
+	token := login(t, server, "/api/client/login", "vasya", "secret")
+	var accounts []accountResponse
+	status = call(t, server, http.MethodGet, "/api/client/accounts", token, nil, &accounts)
+	if status != http.StatusOK || len(accounts) != 1 || accounts[0].Balance != 100 {
+		t.Errorf("unexpected accounts: %d %v", status, accounts)
+	}
+
+	receipt := core.Receipt{}
+	status = call(t, server, http.MethodPost, "/api/client/transfers/phone", token,
+		transferByPhoneRequest{From: 1001, PhoneNumber: 992900000002, Amount: 30}, &receipt)
+	if status != http.StatusOK || receipt.Amount != 30 || receipt.Number == "" {
+		t.Errorf("unexpected transfer: %d %v", status, receipt)
+	}
+
+	status = call(t, server, http.MethodPost, "/api/client/transfers/account", token,
+		transferByAccountRequest{From: 1001, To: 1002, Amount: 500}, &errorResponse)
+	if status != http.StatusUnprocessableEntity || errorResponse.Error != CodeInsufficientFunds {
+		t.Errorf("unexpected response for large transfer: %d %v", status, errorResponse)
+	}
+	status = call(t, server, http.MethodPost, "/api/client/transfers/account", token,
+		transferByAccountRequest{From: 1002, To: 1001, Amount: 10}, &errorResponse)
+	if status != http.StatusForbidden || errorResponse.Error != CodeForbidden {
+		t.Errorf("unexpected response for foreign account: %d %v", status, errorResponse)
+	}
+	status = call(t, server, http.MethodGet, "/api/manager/clients", token, nil, &errorResponse)
+	if status != http.StatusForbidden {
+		t.Errorf("client token accepted by manager endpoint: %d", status)
+	}
+
+	status = call(t, server, http.MethodPost, "/api/logout", token, nil, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("unexpected logout status: %d", status)
+	}
+	status = call(t, server, http.MethodGet, "/api/client/accounts", token, nil, &errorResponse)
+	if status != http.StatusUnauthorized {
+		t.Errorf("token works after logout: %d", status)
+	}
+}
+
+func TestServer_Manager(t *testing.T) {
+	server, db := openServer(t)
+	defer server.Close()
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	token := login(t, server, "/api/manager/login", "vasya", "secret")
+
+	status := call(t, server, http.MethodPost, "/api/manager/atms", token, core.Atm{Name: "Central", Address: "Rudaki 1"}, nil)
+	if status != http.StatusCreated {
+		t.Errorf("can't add atm: %d", status)
+	}
+	errorResponse := ErrorResponse{}
+	status = call(t, server, http.MethodPost, "/api/manager/atms", token, core.Atm{Name: "Broken", Address: "Rudaki 2", Status: "flying"}, &errorResponse)
+	if status != http.StatusUnprocessableEntity || errorResponse.Error != CodeValidationFailed {
+		t.Errorf("unexpected response for invalid atm: %d %v", status, errorResponse)
+	}
+	var atms []core.Atm
+	status = call(t, server, http.MethodGet, "/api/atms", "", nil, &atms)
+	if status != http.StatusOK || len(atms) != 1 || atms[0].Name != "Central" {
+		t.Errorf("unexpected atms: %d %v", status, atms)
+	}
+
+	status = call(t, server, http.MethodPost, "/api/manager/top-ups", token, topUpRequest{Login: "petya", Amount: 70}, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("can't top up: %d", status)
+	}
+	clients := clientsResponse{}
+	status = call(t, server, http.MethodGet, "/api/manager/clients?limit=1", token, nil, &clients)
+	if status != http.StatusOK || len(clients.Clients) != 1 || clients.NextCursor == "" {
+		t.Errorf("unexpected clients: %d %v", status, clients)
+	}
+	status = call(t, server, http.MethodGet, "/api/manager/clients?cursor=broken", token, nil, &errorResponse)
+	if status != http.StatusUnprocessableEntity || errorResponse.Error != CodeValidationFailed {
+		t.Errorf("unexpected response for invalid cursor: %d %v", status, errorResponse)
+	}
+	client := core.Client{Name: "Petya", Login: "petya", Password: "secret", BalanceNumber: 3003, PhoneNumber: 992900000003}
+	status = call(t, server, http.MethodPost, "/api/manager/clients", token, client, &errorResponse)
+	if status != http.StatusConflict || errorResponse.Error != CodeConflict {
+		t.Errorf("unexpected response for duplicate client: %d %v", status, errorResponse)
+	}
+	status = call(t, server, http.MethodDelete, "/api/manager/clients", token, nil, &errorResponse)
+	if status != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status for delete: %d", status)
+	}
+}
+
+func TestMapError_DatabaseErrors(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	_, err = core.GetAllAtms(db)
+	_ = db.Close()
+	status, response := mapError(err)
+	if status != http.StatusInternalServerError || response.Error != CodeDatabaseError || response.Message != "database error" {
+		t.Errorf("unexpected mapping of %v: %d %v", err, status, response)
+	}
+}
+
+func TestMapError_ConflictAndEmptyField(t *testing.T) {
+	status, response := mapError(&core.ConflictError{Field: "login", Value: "vasya"})
+	if status != http.StatusConflict || response.Error != CodeConflict {
+		t.Errorf("unexpected mapping of conflict: %d %v", status, response)
+	}
+	status, response = mapError(fmt.Errorf("name: %w", core.ErrEmptyField))
+	if status != http.StatusUnprocessableEntity || response.Error != CodeValidationFailed {
+		t.Errorf("unexpected mapping of empty field: %d %v", status, response)
+	}
+}
@@ -0,0 +1,334 @@
+// Package terminal is menu driven banking client for customers which reads commands line by line,
+// so whole session can be scripted
+package terminal
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+)
+
+// MaxLoginAttempts is number of wrong logins after which Run gives up and locks
+// for LoginLockDuration every login which got wrong password
+const MaxLoginAttempts = 3
+
+// LoginLockDuration is time during which core.Login refuses login locked by Run
+const LoginLockDuration = 15 * time.Minute
+
+var ErrLoginFailed = errors.New("too many failed login attempts")
+
+const menu = `
+1. Accounts
+2. ATMs
+3. Services
+4. Transfer by phone number
+5. Transfer by account number
+6. Pay for service
+q. Exit`
+
+type terminal struct {
+	in       *bufio.Scanner
+	out      io.Writer
+	db       *sql.DB
+	clientId int64
+}
+
+// Run logs client in and serves menu until "q" or end of input
+func Run(in io.Reader, out io.Writer, db *sql.DB) error {
+	terminal := &terminal{in: bufio.NewScanner(in), out: out, db: db}
+	err := terminal.run()
+	if err == io.EOF {
+		fmt.Fprintln(out)
+		return nil
+	}
+	return err
+}
+
+func (receiver *terminal) run() error {
+	err := receiver.login()
+	if err != nil {
+		return err
+	}
+
+	actions := map[string]func() error{
+		"1": receiver.showAccounts,
+		"2": receiver.showAtms,
+		"3": receiver.showServices,
+		"4": receiver.transferByPhoneNumber,
+		"5": receiver.transferByBalanceNumber,
+		"6": receiver.payForService,
+	}
+	for {
+		fmt.Fprintln(receiver.out, menu)
+		choice, err := receiver.prompt("> ")
+		if err != nil {
+			return err
+		}
+		if choice == "q" {
+			fmt.Fprintln(receiver.out, "Goodbye")
+			return nil
+		}
+		action, ok := actions[choice]
+		if !ok {
+			fmt.Fprintln(receiver.out, "Unknown command")
+			continue
+		}
+		err = action()
+		if err == io.EOF {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(receiver.out, "Operation failed: %v\n", err)
+		}
+	}
+}
+
+// prompt returns next line of input without surrounding spaces
+func (receiver *terminal) prompt(label string) (string, error) {
+	fmt.Fprint(receiver.out, label)
+	if !receiver.in.Scan() {
+		if receiver.in.Err() != nil {
+			return "", receiver.in.Err()
+		}
+		return "", io.EOF
+	}
+	return strings.TrimSpace(receiver.in.Text()), nil
+}
+
+func (receiver *terminal) promptNumber(label string) (uint64, error) {
+	for {
+		line, err := receiver.prompt(label)
+		if err != nil {
+			return 0, err
+		}
+		number, err := strconv.ParseUint(line, 10, 64)
+		if err == nil && number > 0 {
+			return number, nil
+		}
+		fmt.Fprintln(receiver.out, "Enter positive number")
+	}
+}
+
+func (receiver *terminal) confirm(question string) (bool, error) {
+	answer, err := receiver.prompt(question + " [y/n]: ")
+	if err != nil {
+		return false, err
+	}
+	if strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") {
+		return true, nil
+	}
+	fmt.Fprintln(receiver.out, "Cancelled")
+	return false, nil
+}
+
+func (receiver *terminal) login() error {
+	var failed []string
+	for attempt := 0; attempt < MaxLoginAttempts; attempt++ {
+		login, err := receiver.prompt("Login: ")
+		if err != nil {
+			return err
+		}
+		password, err := receiver.prompt("Password: ")
+		if err != nil {
+			return err
+		}
+		id, ok, err := core.Login(login, password, receiver.db)
+		if err == core.ErrLoginLocked {
+			fmt.Fprintln(receiver.out, "Login is locked, try later")
+			continue
+		}
+		if err != nil && err != core.ErrInvalidPass {
+			return err
+		}
+		if ok {
+			receiver.clientId = id
+			fmt.Fprintf(receiver.out, "Welcome, %s\n", login)
+			return nil
+		}
+		if err == core.ErrInvalidPass && !contains(failed, login) {
+			failed = append(failed, login)
+		}
+		fmt.Fprintln(receiver.out, "Invalid login or password")
+	}
+	until := time.Now().Add(LoginLockDuration)
+	for _, login := range failed {
+		err := core.LockLogin(login, until, receiver.db)
+		if err != nil {
+			return err
+		}
+	}
+	return ErrLoginFailed
+}
+
+func contains(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (receiver *terminal) showAccounts() error {
+	accounts, err := core.GetBalanceList(receiver.db, receiver.clientId)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		fmt.Fprintf(receiver.out, "%d: %d\n", account.BalanceNumber, account.Balance)
+	}
+	return nil
+}
+
+func (receiver *terminal) showAtms() error {
+	atms, err := core.GetAllAtms(receiver.db)
+	if err != nil {
+		return err
+	}
+	for _, atm := range atms {
+		fmt.Fprintf(receiver.out, "%s, %s (%s, %s-%s)\n", atm.Name, atm.Address, atm.Status, atm.OpenTime, atm.CloseTime)
+	}
+	return nil
+}
+
+func (receiver *terminal) showServices() error {
+	services, err := core.GetServices(receiver.db)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		fmt.Fprintf(receiver.out, "%d. %s\n", service.Id, service.Name)
+	}
+	return nil
+}
+
+// chooseAccount asks which account to pay from, only account is chosen without asking
+func (receiver *terminal) chooseAccount() (account core.Client, err error) {
+	accounts, err := core.GetBalanceList(receiver.db, receiver.clientId)
+	if err != nil {
+		return core.Client{}, err
+	}
+	if len(accounts) == 0 {
+		return core.Client{}, errors.New("you have no accounts")
+	}
+	if len(accounts) == 1 {
+		return accounts[0], nil
+	}
+	for index, account := range accounts {
+		fmt.Fprintf(receiver.out, "%d. %d: %d\n", index+1, account.BalanceNumber, account.Balance)
+	}
+	for {
+		choice, err := receiver.promptNumber("Account: ")
+		if err != nil {
+			return core.Client{}, err
+		}
+		if choice <= uint64(len(accounts)) {
+			return accounts[choice-1], nil
+		}
+		fmt.Fprintln(receiver.out, "No such account")
+	}
+}
+
+// promptAmount asks amount which is not greater than balance of account
+func (receiver *terminal) promptAmount(account core.Client) (uint64, error) {
+	for {
+		amount, err := receiver.promptNumber("Amount: ")
+		if err != nil {
+			return 0, err
+		}
+		if amount <= account.Balance {
+			return amount, nil
+		}
+		fmt.Fprintf(receiver.out, "Not enough money, balance is %d\n", account.Balance)
+	}
+}
+
+func (receiver *terminal) transferByPhoneNumber() error {
+	account, err := receiver.chooseAccount()
+	if err != nil {
+		return err
+	}
+	phoneNumber, err := receiver.promptNumber("Phone number: ")
+	if err != nil {
+		return err
+	}
+	amount, err := receiver.promptAmount(account)
+	if err != nil {
+		return err
+	}
+	ok, err := receiver.confirm(fmt.Sprintf("Transfer %d from %d to phone %d?", amount, account.BalanceNumber, phoneNumber))
+	if err != nil || !ok {
+		return err
+	}
+	receipt, err := core.TransferByPhoneNumber(account.BalanceNumber, amount,
+		core.Client{PhoneNumber: int64(phoneNumber), Balance: amount}, receiver.db)
+	if err != nil {
+		return err
+	}
+	return receipt.WriteText(receiver.out)
+}
+
+func (receiver *terminal) transferByBalanceNumber() error {
+	account, err := receiver.chooseAccount()
+	if err != nil {
+		return err
+	}
+	balanceNumber, err := receiver.promptNumber("Account number: ")
+	if err != nil {
+		return err
+	}
+	amount, err := receiver.promptAmount(account)
+	if err != nil {
+		return err
+	}
+	ok, err := receiver.confirm(fmt.Sprintf("Transfer %d from %d to account %d?", amount, account.BalanceNumber, balanceNumber))
+	if err != nil || !ok {
+		return err
+	}
+	receipt, err := core.TransferByBalanceNumber(account.BalanceNumber, amount,
+		core.Client{BalanceNumber: balanceNumber, Balance: amount}, receiver.db)
+	if err != nil {
+		return err
+	}
+	return receipt.WriteText(receiver.out)
+}
+
+func (receiver *terminal) payForService() error {
+	err := receiver.showServices()
+	if err != nil {
+		return err
+	}
+	serviceId, err := receiver.promptNumber("Service: ")
+	if err != nil {
+		return err
+	}
+	account, err := receiver.chooseAccount()
+	if err != nil {
+		return err
+	}
+	reference, err := receiver.prompt("Reference: ")
+	if err != nil {
+		return err
+	}
+	amount, err := receiver.promptAmount(account)
+	if err != nil {
+		return err
+	}
+	ok, err := receiver.confirm(fmt.Sprintf("Pay %d from %d to service %d with reference %q?", amount, account.BalanceNumber, serviceId, reference))
+	if err != nil || !ok {
+		return err
+	}
+	receipt, err := core.PayForServices(account.BalanceNumber, amount, reference,
+		core.Services{Id: int64(serviceId)}, receiver.db)
+	if err != nil {
+		return err
+	}
+	return receipt.WriteText(receiver.out)
+}
@@ -0,0 +1,124 @@
+package terminal
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/ParvizBoymurodov/managers-core/pkg/core"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("can't open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	err = core.Init(db)
+	if err != nil {
+		t.Fatalf("can't init db: %v", err)
+	}
+	err = core.AddClients(core.Client{Name: "Vasya", Login: "vasya", Password: "secret", Balance: 100, BalanceNumber: 1001, PhoneNumber: 992900000001}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	err = core.AddClients(core.Client{Name: "Petya", Login: "petya", Password: "secret", Balance: 0, BalanceNumber: 1002, PhoneNumber: 992900000002}, db)
+	if err != nil {
+		t.Fatalf("can't add client: %v", err)
+	}
+	return db
+}
+
+func TestRun_Transfers(t *testing.T) {
+	db := openDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	script := strings.Join([]string{
+		"vasya", "wrong",
+		"vasya", "secret",
+		"4", "992900000002", "500", "30", "y",
+		"5", "1002", "20", "n",
+		"7",
+		"1",
+		"q",
+	}, "\n")
+	out := &bytes.Buffer{}
+	err := Run(strings.NewReader(script), out, db)
+	if err != nil {
+		t.Fatalf("can't run: %v", err)
+	}
+	for _, expected := range []string{
+		"Invalid login or password",
+		"Welcome, vasya",
+		"Not enough money, balance is 100",
+		"Transfer 30 from 1001 to phone 992900000002?",
+		"Cancelled",
+		"Unknown command",
+		"1001: 70",
+		"Goodbye",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output has no %q:\n%s", expected, out)
+		}
+	}
+
+	accounts, err := core.GetBalanceList(db, 2)
+	if err != nil || len(accounts) != 1 || accounts[0].Balance != 30 {
+		t.Errorf("unexpected accounts of payee: %v %v", accounts, err)
+	}
+}
+
+func TestRun_LoginFailed(t *testing.T) {
+	db := openDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+
+	err := Run(strings.NewReader("a\nb\nc\nd\ne\nf\n"), &bytes.Buffer{}, db)
+	if err != ErrLoginFailed {
+		t.Errorf("Not ErrLoginFailed for wrong logins: %v", err)
+	}
+	err = Run(strings.NewReader("vasya\nsecret\n"), &bytes.Buffer{}, db)
+	if err != nil {
+		t.Errorf("end of input is not normal exit: %v", err)
+	}
+}
+
+func TestRun_LoginLockedEvent(t *testing.T) {
+	db := openDB(t)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("can't close db: %v", err)
+		}
+	}()
+	err := core.AddWebhook(core.Webhook{URL: "https://example.com/hook", Secret: "key", Events: []string{core.EventLoginLocked}}, db)
+	if err != nil {
+		t.Fatalf("can't add webhook: %v", err)
+	}
+
+	err = Run(strings.NewReader("vasya\n1\nvasya\n2\nkolya\n3\n"), &bytes.Buffer{}, db)
+	if err != ErrLoginFailed {
+		t.Errorf("Not ErrLoginFailed for wrong passwords: %v", err)
+	}
+	events, err := core.GetEventsByStatus(core.OutboxPending, db)
+	if err != nil || len(events) != 1 || events[0].Event != core.EventLoginLocked || !strings.Contains(events[0].Payload, `"login":"vasya"`) {
+		t.Errorf("unexpected events: %v %v", events, err)
+	}
+
+	_, ok, err := core.Login("vasya", "secret", db)
+	if ok || err != core.ErrLoginLocked {
+		t.Errorf("locked login is accepted: %v %v", ok, err)
+	}
+	_, ok, err = core.Login("petya", "secret", db)
+	if !ok || err != nil {
+		t.Errorf("login without wrong password is locked: %v %v", ok, err)
+	}
+}